@@ -0,0 +1,159 @@
+// Package aot translates bytecode into Go source ahead of time.
+//
+// The generated package holds the bytecode it was translated from and a Run
+// function which executes it on a vm.Thread. Each decoded instruction becomes a
+// labelled block of Go code, and blocks jump straight to the block of the
+// instruction which runs next. The stack, arithmetic, compare, jump, call and
+// return opcodes are translated into Go which works on the stack and frames of
+// the thread directly, using the vmath helpers for integer arithmetic.
+//
+// A translated block first checks that its instruction can not fault: that
+// its operands have the types it handles, that the stack and frame limits are
+// not reached and that integer arithmetic neither overflows in trapping mode
+// nor divides by zero. If a check fails, or the thread is metered, the
+// instruction is run with vm.Thread.Exec instead, as is every opcode which is
+// not translated, so the generated code behaves exactly like the interpreter,
+// including faults, exception handling and fuel metering. Offsets which are
+// not the start of a decoded instruction fall back to vm.Thread.Step.
+//
+// Calls run by translated code are not counted towards compiling functions of
+// the Program of the thread, which the generated code does not use.
+package aot
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"path"
+	"regexp"
+
+	"github.com/tvarney/consterr"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+// ErrInvalidPackage indicates that the package name given to Generate is not
+// a valid Go identifier.
+const ErrInvalidPackage consterr.Error = "invalid package name"
+
+// bytesPerLine is the number of bytes of bytecode written per line of the
+// generated Data variable.
+const bytesPerLine = 12
+
+// Generate writes the Go source of a package named pkg which runs the given
+// bytecode.
+func Generate(w io.Writer, pkg string, data []uint8) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("%w: %q", ErrInvalidPackage, pkg)
+	}
+
+	p := vm.Decode(data)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by illvm2go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "// Package %s runs bytecode translated ahead of time.\n", pkg)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+
+	var body bytes.Buffer
+	writeData(&body, data)
+	writeCode(&body, p)
+	writeRun(&body, p)
+	writeHelpers(&body)
+
+	writeImports(&buf, body.String())
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+
+	return err
+}
+
+// writeData writes the Data variable and NewThread function of the generated
+// package.
+func writeData(buf *bytes.Buffer, data []uint8) {
+	fmt.Fprintf(buf, "// Data is the bytecode the package was translated from.\n")
+	fmt.Fprintf(buf, "var Data = []uint8{")
+
+	for i, b := range data {
+		if i%bytesPerLine == 0 {
+			fmt.Fprintf(buf, "\n\t")
+		} else {
+			fmt.Fprintf(buf, " ")
+		}
+
+		fmt.Fprintf(buf, "0x%02X,", b)
+	}
+
+	fmt.Fprintf(buf, "\n}\n\n")
+
+	fmt.Fprintf(buf, "// NewThread returns a thread which may be passed to Run.\n")
+	fmt.Fprintf(buf, "func NewThread() *vm.Thread {\n\treturn &vm.Thread{Data: Data}\n}\n\n")
+}
+
+// writeCode writes the decoded instructions of the program.
+func writeCode(buf *bytes.Buffer, p *vm.Program) {
+	fmt.Fprintf(buf, "var code = [...]vm.Instruction{\n")
+
+	for _, ins := range p.Instructions {
+		fmt.Fprintf(buf,
+			"\t{Op: opcode.%s, Control: 0x%02X, Offset: 0x%04X, Next: 0x%04X, Value: %s, Target: 0x%04X, "+
+				"Fused: opcode.%s, FusedControl: 0x%02X, FusedOffset: 0x%04X},\n",
+			ins.Op, ins.Control, ins.Offset, ins.Next, slotExpr(ins.Value), ins.Target,
+			ins.Fused, ins.FusedControl, ins.FusedOffset,
+		)
+	}
+
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+// writeImports writes the import declaration of the generated package, which
+// imports each package the given source of the package body uses.
+func writeImports(buf *bytes.Buffer, body string) {
+	fmt.Fprintf(buf, "import (\n")
+
+	std := false
+	for _, pkg := range []string{"cmp", "math"} {
+		if uses(body, pkg) {
+			fmt.Fprintf(buf, "\t%q\n", pkg)
+			std = true
+		}
+	}
+
+	if std {
+		fmt.Fprintf(buf, "\n")
+	}
+
+	for _, pkg := range []string{"opcode", "types/typeid", "vm", "vm/vmath"} {
+		if uses(body, path.Base(pkg)) {
+			fmt.Fprintf(buf, "\t\"github.com/tvarney/illvm/%s\"\n", pkg)
+		}
+	}
+
+	fmt.Fprintf(buf, ")\n\n")
+}
+
+// uses returns if the given source refers to the package with the given name.
+func uses(src, pkg string) bool {
+	return regexp.MustCompile(`\b` + pkg + `\.`).MatchString(src)
+}
+
+// slotExpr returns a Go expression which evaluates to the given slot.
+func slotExpr(s vm.Slot) string {
+	switch s.Type {
+	case typeid.Uint64:
+		return fmt.Sprintf("vm.UnsignedSlot(0x%X)", s.Unsigned())
+	case typeid.Int64:
+		return fmt.Sprintf("vm.SignedSlot(%d)", s.Signed())
+	case typeid.Float64:
+		return fmt.Sprintf("vm.FloatSlot(math.Float64frombits(0x%016X))", s.Bits)
+	default:
+		return "vm.Slot{}"
+	}
+}
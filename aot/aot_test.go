@@ -0,0 +1,167 @@
+package aot_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/aot"
+	"github.com/tvarney/illvm/aot/internal/corpus/countdown"
+	"github.com/tvarney/illvm/aot/internal/corpus/fib"
+	"github.com/tvarney/illvm/aot/internal/corpus/mixed"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InvalidPackage", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		testerr.Is(aot.ErrInvalidPackage).Require(t, aot.Generate(&buf, "not-valid", nil))
+		require.Empty(t, buf.Bytes())
+	})
+
+	for _, name := range []string{"countdown", "fib", "mixed"} {
+		t.Run("UpToDate/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := filepath.Join("internal", "corpus", name)
+			data, err := os.ReadFile(filepath.Join(dir, name+".ill"))
+			require.NoError(t, err)
+
+			expected, err := os.ReadFile(filepath.Join(dir, name+".go"))
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, aot.Generate(&buf, name, data))
+			require.Equal(t, string(expected), buf.String(), "run go generate ./aot/...")
+		})
+	}
+}
+
+// meteredFuel is the fuel given to metered threads by TestDifferential, which
+// runs out part way through some of the programs.
+const meteredFuel = 200
+
+func TestDifferential(t *testing.T) {
+	t.Parallel()
+
+	fibFrame := []vm.Frame{{Base: 0, Caller: 0, Return: len(fib.Data)}}
+	handlers := []vm.Handler{{Start: 0, End: len(mixed.Data), Target: len(mixed.Data), Depth: 0}}
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		run      func(*vm.Thread) error
+		pc       int
+		frames   []vm.Frame
+		handlers []vm.Handler
+		inputs   [][]types.Value
+	}{
+		{"Fib/Program", fib.Data, fib.Run, 0, nil, nil, [][]types.Value{nil}},
+		{
+			"Fib/Function", fib.Data, fib.Run, 8, fibFrame, nil, [][]types.Value{
+				{types.Uint64(0)}, {types.Uint64(1)}, {types.Uint64(12)}, {types.Int64(9)},
+				{types.Float64(6)}, {types.Error{}}, {},
+			},
+		},
+		{"Countdown/Program", countdown.Data, countdown.Run, 0, nil, nil, [][]types.Value{nil}},
+		{
+			"Countdown/Loop", countdown.Data, countdown.Run, 4, nil, nil, [][]types.Value{
+				{types.Int64(1)}, {types.Int64(50)}, {types.Uint64(7)}, {types.Float64(3)},
+				{types.Int64(math.MinInt64)}, {types.Uint64(math.MaxUint64)}, {types.Error{}}, {},
+			},
+		},
+		{"Mixed/Uncaught", mixed.Data, mixed.Run, 0, nil, nil, mixedInputs()},
+		{"Mixed/Caught", mixed.Data, mixed.Run, 0, nil, handlers, mixedInputs()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, input := range test.inputs {
+				for _, costs := range []*opcode.Costs{nil, opcode.DefaultCosts()} {
+					newThread := func() *vm.Thread {
+						return &vm.Thread{
+							Stack:    vm.Slots(input...),
+							Frames:   append([]vm.Frame(nil), test.frames...),
+							Handlers: test.handlers,
+							Data:     test.data,
+							PC:       test.pc,
+							Costs:    costs,
+							Fuel:     meteredFuel,
+						}
+					}
+
+					interpreted := newThread()
+					expected := interpreted.Run()
+
+					generated := newThread()
+					require.Equal(t, expected, test.run(generated), "input %v", input)
+					require.Equal(t, interpreted.PC, generated.PC, "input %v", input)
+					require.Equal(t, vm.Values(interpreted.Stack), vm.Values(generated.Stack), "input %v", input)
+					require.Equal(t, interpreted.Frames, generated.Frames, "input %v", input)
+					require.Equal(t, interpreted.Fuel, generated.Fuel, "input %v", input)
+				}
+			}
+		})
+	}
+}
+
+// mixedInputs returns the inputs of the mixed program, which divides the top
+// of the stack by the value below it.
+func mixedInputs() [][]types.Value {
+	return [][]types.Value{
+		{types.Uint64(7), types.Uint64(100)},
+		{types.Uint64(0), types.Uint64(100)},
+		{types.Int64(-3), types.Int64(100)},
+		{types.Int64(-1), types.Int64(math.MinInt64)},
+		{types.Uint64(1), types.Uint64(math.MaxUint64)},
+		{types.Float64(0.5), types.Float64(3)},
+		{types.Float64(2), types.Float64(1e300)},
+		{types.Uint64(3), types.Uint64(1)},
+		{types.Uint64(1)},
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	b.Run("Interpreted", func(b *testing.B) {
+		th := fib.NewThread()
+		th.Predecode()
+		th.Program.HotCalls = 0
+
+		b.ReportAllocs()
+
+		for range b.N {
+			th.PC = 0
+			th.Stack = th.Stack[:0]
+
+			if err := th.Run(); !errors.Is(err, vm.ErrBytecodeOverflow) {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Generated", func(b *testing.B) {
+		th := fib.NewThread()
+
+		b.ReportAllocs()
+
+		for range b.N {
+			th.PC = 0
+			th.Stack = th.Stack[:0]
+
+			if err := fib.Run(th); !errors.Is(err, vm.ErrBytecodeOverflow) {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,7 @@
+// Package corpus holds bytecode translated by illvm2go, used to test that
+// generated code behaves exactly like the interpreter.
+package corpus
+
+//go:generate go run ../../../cmd/illvm2go -o countdown/countdown.go countdown/countdown.ill
+//go:generate go run ../../../cmd/illvm2go -o fib/fib.go fib/fib.ill
+//go:generate go run ../../../cmd/illvm2go -o mixed/mixed.go mixed/mixed.ill
@@ -0,0 +1,172 @@
+// Code generated by illvm2go. DO NOT EDIT.
+
+// Package countdown runs bytecode translated ahead of time.
+package countdown
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/illvm/vm/vmath"
+)
+
+// Data is the bytecode the package was translated from.
+var Data = []uint8{
+	0x01, 0x12, 0x00, 0x64, 0x01, 0x11, 0xFF, 0x07, 0x40, 0x02, 0x00, 0x13,
+	0x01, 0x04,
+}
+
+// NewThread returns a thread which may be passed to Run.
+func NewThread() *vm.Thread {
+	return &vm.Thread{Data: Data}
+}
+
+var code = [...]vm.Instruction{
+	{Op: opcode.Push, Control: 0x12, Offset: 0x0000, Next: 0x0004, Value: vm.SignedSlot(100), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Push, Control: 0x11, Offset: 0x0004, Next: 0x0007, Value: vm.SignedSlot(-1), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Add, Control: 0x40, Offset: 0x0007, Next: 0x0009, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Dupe, Control: 0x00, Offset: 0x0009, Next: 0x000B, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.JumpIf, Control: 0x01, Offset: 0x000B, Next: 0x000E, Value: vm.Slot{}, Target: 0x0004, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+}
+
+// Run runs the translated bytecode on the given thread until an error
+// occurs, as vm.Thread.Run would.
+//
+// The bytecode of the thread must be Data.
+func Run(t *vm.Thread) error {
+	for {
+		switch t.PC {
+		case 0x0000:
+			goto pc0000
+		case 0x0004:
+			goto pc0004
+		case 0x0007:
+			goto pc0007
+		case 0x0009:
+			goto pc0009
+		case 0x000B:
+			goto pc000B
+		default:
+			if err := t.Step(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+	pc0000: // Push 0x12 int64 100
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.SignedSlot(100))
+			t.PC = 0x0004
+			goto pc0004
+		}
+
+		if err := t.Exec(&code[0]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0004: // Push 0x11 int64 -1
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.SignedSlot(-1))
+			t.PC = 0x0007
+			goto pc0007
+		}
+
+		if err := t.Exec(&code[1]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0007: // Add 0x40
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				if r, overflow := vmath.AddU64(a.Unsigned(), b.Unsigned()); !overflow {
+					t.Stack[n-2] = vm.UnsignedSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0009
+					goto pc0009
+				}
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				if r, overflow := vmath.AddI64(a.Signed(), b.Signed()); !overflow {
+					t.Stack[n-2] = vm.SignedSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0009
+					goto pc0009
+				}
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64:
+				if r := a.Float() + b.Float(); !math.IsNaN(r) {
+					t.Stack[n-2] = vm.FloatSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0009
+					goto pc0009
+				}
+			}
+		}
+
+		if err := t.Exec(&code[2]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0009: // Dupe 0x00
+		if i := len(t.Stack) - 1; t.Costs == nil && i >= t.FrameBase() && fits(t) {
+			t.Stack = append(t.Stack, t.Stack[i])
+			t.PC = 0x000B
+			goto pc000B
+		}
+
+		if err := t.Exec(&code[3]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000B: // JumpIf 0x01 0x0004
+		if n := len(t.Stack); t.Costs == nil && n > t.FrameBase() {
+			if truth, ok := truthy(t.Stack[n-1]); ok {
+				t.Stack = t.Stack[:n-1]
+				if truth {
+					t.PC = 0x0004
+					goto pc0004
+				}
+
+				t.PC = 0x000E
+				continue
+			}
+		}
+
+		if err := t.Exec(&code[4]); err != nil {
+			return err
+		}
+
+		continue
+
+	}
+}
+
+// fits returns if a value may be pushed onto the stack of the thread without
+// exceeding its stack limit.
+func fits(t *vm.Thread) bool {
+	return t.Limits.Stack <= 0 || t.StackUsed() < t.Limits.Stack
+}
+
+// truthy returns if the given slot is non-zero, or false as its second result
+// if it is not numeric.
+func truthy(s vm.Slot) (bool, bool) {
+	switch s.Type {
+	case typeid.Uint64, typeid.Int64:
+		return s.Bits != 0, true
+	case typeid.Float64:
+		return s.Float() != 0, true
+	default:
+		return false, false
+	}
+}
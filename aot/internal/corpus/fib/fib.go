@@ -0,0 +1,453 @@
+// Code generated by illvm2go. DO NOT EDIT.
+
+// Package fib runs bytecode translated ahead of time.
+package fib
+
+import (
+	"cmp"
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/illvm/vm/vmath"
+)
+
+// Data is the bytecode the package was translated from.
+var Data = []uint8{
+	0x01, 0x8A, 0x14, 0x11, 0x08, 0x12, 0x01, 0x29, 0x02, 0x00, 0x01, 0x82,
+	0x18, 0x04, 0x13, 0x01, 0x27, 0x01, 0x81, 0x02, 0x01, 0x08, 0x00, 0x14,
+	0x11, 0x08, 0x01, 0x82, 0x02, 0x02, 0x08, 0x00, 0x14, 0x11, 0x08, 0x07,
+	0x00, 0x15, 0x01, 0x15, 0x01,
+}
+
+// NewThread returns a thread which may be passed to Run.
+func NewThread() *vm.Thread {
+	return &vm.Thread{Data: Data}
+}
+
+var code = [...]vm.Instruction{
+	{Op: opcode.Push, Control: 0x8A, Offset: 0x0000, Next: 0x0002, Value: vm.UnsignedSlot(0xA), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Call, Control: 0x11, Offset: 0x0002, Next: 0x0005, Value: vm.Slot{}, Target: 0x0008, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Jump, Control: 0x01, Offset: 0x0005, Next: 0x0008, Value: vm.Slot{}, Target: 0x0029, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Dupe, Control: 0x00, Offset: 0x0008, Next: 0x000A, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Push, Control: 0x82, Offset: 0x000A, Next: 0x000C, Value: vm.UnsignedSlot(0x2), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Compare, Control: 0x04, Offset: 0x000C, Next: 0x000E, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.JumpIf, Control: 0x01, Offset: 0x000E, Next: 0x0011, Value: vm.Slot{}, Target: 0x0027, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Push, Control: 0x81, Offset: 0x0011, Next: 0x0013, Value: vm.UnsignedSlot(0x1), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Dupe, Control: 0x01, Offset: 0x0013, Next: 0x0015, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Sub, Control: 0x00, Offset: 0x0015, Next: 0x0017, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Call, Control: 0x11, Offset: 0x0017, Next: 0x001A, Value: vm.Slot{}, Target: 0x0008, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Push, Control: 0x82, Offset: 0x001A, Next: 0x001C, Value: vm.UnsignedSlot(0x2), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Dupe, Control: 0x02, Offset: 0x001C, Next: 0x001E, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Sub, Control: 0x00, Offset: 0x001E, Next: 0x0020, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Call, Control: 0x11, Offset: 0x0020, Next: 0x0023, Value: vm.Slot{}, Target: 0x0008, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Add, Control: 0x00, Offset: 0x0023, Next: 0x0025, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Return, Control: 0x01, Offset: 0x0025, Next: 0x0027, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Return, Control: 0x01, Offset: 0x0027, Next: 0x0029, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+}
+
+// Run runs the translated bytecode on the given thread until an error
+// occurs, as vm.Thread.Run would.
+//
+// The bytecode of the thread must be Data.
+func Run(t *vm.Thread) error {
+	for {
+		switch t.PC {
+		case 0x0000:
+			goto pc0000
+		case 0x0002:
+			goto pc0002
+		case 0x0005:
+			goto pc0005
+		case 0x0008:
+			goto pc0008
+		case 0x000A:
+			goto pc000A
+		case 0x000C:
+			goto pc000C
+		case 0x000E:
+			goto pc000E
+		case 0x0011:
+			goto pc0011
+		case 0x0013:
+			goto pc0013
+		case 0x0015:
+			goto pc0015
+		case 0x0017:
+			goto pc0017
+		case 0x001A:
+			goto pc001A
+		case 0x001C:
+			goto pc001C
+		case 0x001E:
+			goto pc001E
+		case 0x0020:
+			goto pc0020
+		case 0x0023:
+			goto pc0023
+		case 0x0025:
+			goto pc0025
+		case 0x0027:
+			goto pc0027
+		default:
+			if err := t.Step(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+	pc0000: // Push 0x8A uint64 10
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.UnsignedSlot(0xA))
+			t.PC = 0x0002
+			goto pc0002
+		}
+
+		if err := t.Exec(&code[0]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0002: // Call 0x11 0x0008
+		if base := len(t.Stack) - 1; t.Costs == nil && base >= t.FrameBase() &&
+			(t.Limits.Frames <= 0 || len(t.Frames) < t.Limits.Frames) {
+			t.Frames = append(t.Frames, vm.Frame{Base: base, Caller: 0x0002, Return: 0x0005})
+			t.PC = 0x0008
+			goto pc0008
+		}
+
+		if err := t.Exec(&code[1]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0005: // Jump 0x01 0x0029
+		if t.Costs == nil {
+			t.PC = 0x0029
+			continue
+		}
+
+		if err := t.Exec(&code[2]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0008: // Dupe 0x00
+		if i := len(t.Stack) - 1; t.Costs == nil && i >= t.FrameBase() && fits(t) {
+			t.Stack = append(t.Stack, t.Stack[i])
+			t.PC = 0x000A
+			goto pc000A
+		}
+
+		if err := t.Exec(&code[3]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000A: // Push 0x82 uint64 2
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.UnsignedSlot(0x2))
+			t.PC = 0x000C
+			goto pc000C
+		}
+
+		if err := t.Exec(&code[4]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000C: // Compare 0x04
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Unsigned(), b.Unsigned()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Signed(), b.Signed()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64 && !math.IsNaN(a.Float()) && !math.IsNaN(b.Float()):
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Float(), b.Float()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			}
+		}
+
+		if err := t.Exec(&code[5]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000E: // JumpIf 0x01 0x0027
+		if n := len(t.Stack); t.Costs == nil && n > t.FrameBase() {
+			if truth, ok := truthy(t.Stack[n-1]); ok {
+				t.Stack = t.Stack[:n-1]
+				if truth {
+					t.PC = 0x0027
+					goto pc0027
+				}
+
+				t.PC = 0x0011
+				goto pc0011
+			}
+		}
+
+		if err := t.Exec(&code[6]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0011: // Push 0x81 uint64 1
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.UnsignedSlot(0x1))
+			t.PC = 0x0013
+			goto pc0013
+		}
+
+		if err := t.Exec(&code[7]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0013: // Dupe 0x01
+		if i := len(t.Stack) - 2; t.Costs == nil && i >= t.FrameBase() && fits(t) {
+			t.Stack = append(t.Stack, t.Stack[i])
+			t.PC = 0x0015
+			goto pc0015
+		}
+
+		if err := t.Exec(&code[8]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0015: // Sub 0x00
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				r, _ := vmath.SubU64(a.Unsigned(), b.Unsigned())
+				t.Stack[n-2] = vm.UnsignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0017
+				goto pc0017
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				r, _ := vmath.SubI64(a.Signed(), b.Signed())
+				t.Stack[n-2] = vm.SignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0017
+				goto pc0017
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64:
+				if r := a.Float() - b.Float(); !math.IsNaN(r) {
+					t.Stack[n-2] = vm.FloatSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0017
+					goto pc0017
+				}
+			}
+		}
+
+		if err := t.Exec(&code[9]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0017: // Call 0x11 0x0008
+		if base := len(t.Stack) - 1; t.Costs == nil && base >= t.FrameBase() &&
+			(t.Limits.Frames <= 0 || len(t.Frames) < t.Limits.Frames) {
+			t.Frames = append(t.Frames, vm.Frame{Base: base, Caller: 0x0017, Return: 0x001A})
+			t.PC = 0x0008
+			goto pc0008
+		}
+
+		if err := t.Exec(&code[10]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc001A: // Push 0x82 uint64 2
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.UnsignedSlot(0x2))
+			t.PC = 0x001C
+			goto pc001C
+		}
+
+		if err := t.Exec(&code[11]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc001C: // Dupe 0x02
+		if i := len(t.Stack) - 3; t.Costs == nil && i >= t.FrameBase() && fits(t) {
+			t.Stack = append(t.Stack, t.Stack[i])
+			t.PC = 0x001E
+			goto pc001E
+		}
+
+		if err := t.Exec(&code[12]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc001E: // Sub 0x00
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				r, _ := vmath.SubU64(a.Unsigned(), b.Unsigned())
+				t.Stack[n-2] = vm.UnsignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0020
+				goto pc0020
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				r, _ := vmath.SubI64(a.Signed(), b.Signed())
+				t.Stack[n-2] = vm.SignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0020
+				goto pc0020
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64:
+				if r := a.Float() - b.Float(); !math.IsNaN(r) {
+					t.Stack[n-2] = vm.FloatSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0020
+					goto pc0020
+				}
+			}
+		}
+
+		if err := t.Exec(&code[13]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0020: // Call 0x11 0x0008
+		if base := len(t.Stack) - 1; t.Costs == nil && base >= t.FrameBase() &&
+			(t.Limits.Frames <= 0 || len(t.Frames) < t.Limits.Frames) {
+			t.Frames = append(t.Frames, vm.Frame{Base: base, Caller: 0x0020, Return: 0x0023})
+			t.PC = 0x0008
+			goto pc0008
+		}
+
+		if err := t.Exec(&code[14]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0023: // Add 0x00
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				r, _ := vmath.AddU64(a.Unsigned(), b.Unsigned())
+				t.Stack[n-2] = vm.UnsignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0025
+				goto pc0025
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				r, _ := vmath.AddI64(a.Signed(), b.Signed())
+				t.Stack[n-2] = vm.SignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0025
+				goto pc0025
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64:
+				if r := a.Float() + b.Float(); !math.IsNaN(r) {
+					t.Stack[n-2] = vm.FloatSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0025
+					goto pc0025
+				}
+			}
+		}
+
+		if err := t.Exec(&code[15]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0025: // Return 0x01
+		if depth := len(t.Frames); t.Costs == nil && depth > 0 {
+			if f := t.Frames[depth-1]; len(t.Stack)-1 >= f.Base {
+				copy(t.Stack[f.Base:], t.Stack[len(t.Stack)-1:])
+				t.Stack = t.Stack[:f.Base+1]
+				t.Frames = t.Frames[:depth-1]
+				t.PC = f.Return
+				continue
+			}
+		}
+
+		if err := t.Exec(&code[16]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0027: // Return 0x01
+		if depth := len(t.Frames); t.Costs == nil && depth > 0 {
+			if f := t.Frames[depth-1]; len(t.Stack)-1 >= f.Base {
+				copy(t.Stack[f.Base:], t.Stack[len(t.Stack)-1:])
+				t.Stack = t.Stack[:f.Base+1]
+				t.Frames = t.Frames[:depth-1]
+				t.PC = f.Return
+				continue
+			}
+		}
+
+		if err := t.Exec(&code[17]); err != nil {
+			return err
+		}
+
+		continue
+
+	}
+}
+
+// fits returns if a value may be pushed onto the stack of the thread without
+// exceeding its stack limit.
+func fits(t *vm.Thread) bool {
+	return t.Limits.Stack <= 0 || t.StackUsed() < t.Limits.Stack
+}
+
+// truthy returns if the given slot is non-zero, or false as its second result
+// if it is not numeric.
+func truthy(s vm.Slot) (bool, bool) {
+	switch s.Type {
+	case typeid.Uint64, typeid.Int64:
+		return s.Bits != 0, true
+	case typeid.Float64:
+		return s.Float() != 0, true
+	default:
+		return false, false
+	}
+}
+
+// boolSlot returns 1 if b is true, otherwise 0.
+func boolSlot(b bool) vm.Slot {
+	if b {
+		return vm.UnsignedSlot(1)
+	}
+
+	return vm.UnsignedSlot(0)
+}
@@ -0,0 +1,215 @@
+// Code generated by illvm2go. DO NOT EDIT.
+
+// Package mixed runs bytecode translated ahead of time.
+package mixed
+
+import (
+	"cmp"
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/illvm/vm/vmath"
+)
+
+// Data is the bytecode the package was translated from.
+var Data = []uint8{
+	0x0D, 0x40, 0x09, 0x80, 0x02, 0x00, 0x01, 0x24, 0x3F, 0xC0, 0x00, 0x00,
+	0x18, 0x04, 0x13, 0x01, 0x13, 0x17, 0x45,
+}
+
+// NewThread returns a thread which may be passed to Run.
+func NewThread() *vm.Thread {
+	return &vm.Thread{Data: Data}
+}
+
+var code = [...]vm.Instruction{
+	{Op: opcode.DivMod, Control: 0x40, Offset: 0x0000, Next: 0x0002, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Mul, Control: 0x80, Offset: 0x0002, Next: 0x0004, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Dupe, Control: 0x00, Offset: 0x0004, Next: 0x0006, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Push, Control: 0x24, Offset: 0x0006, Next: 0x000C, Value: vm.FloatSlot(math.Float64frombits(0x3FF8000000000000)), Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Compare, Control: 0x04, Offset: 0x000C, Next: 0x000E, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.JumpIf, Control: 0x01, Offset: 0x000E, Next: 0x0011, Value: vm.Slot{}, Target: 0x0013, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+	{Op: opcode.Cast, Control: 0x45, Offset: 0x0011, Next: 0x0013, Value: vm.Slot{}, Target: 0x0000, Fused: opcode.NoOp, FusedControl: 0x00, FusedOffset: 0x0000},
+}
+
+// Run runs the translated bytecode on the given thread until an error
+// occurs, as vm.Thread.Run would.
+//
+// The bytecode of the thread must be Data.
+func Run(t *vm.Thread) error {
+	for {
+		switch t.PC {
+		case 0x0000:
+			goto pc0000
+		case 0x0002:
+			goto pc0002
+		case 0x0004:
+			goto pc0004
+		case 0x0006:
+			goto pc0006
+		case 0x000C:
+			goto pc000C
+		case 0x000E:
+			goto pc000E
+		case 0x0011:
+			goto pc0011
+		default:
+			if err := t.Step(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+	pc0000: // DivMod 0x40
+		if err := t.Exec(&code[0]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0002: // Mul 0x80
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				r := vmath.MulSatU64(a.Unsigned(), b.Unsigned())
+				t.Stack[n-2] = vm.UnsignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0004
+				goto pc0004
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				r := vmath.MulSatI64(a.Signed(), b.Signed())
+				t.Stack[n-2] = vm.SignedSlot(r)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x0004
+				goto pc0004
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64:
+				if r := a.Float() * b.Float(); !math.IsNaN(r) {
+					t.Stack[n-2] = vm.FloatSlot(r)
+					t.Stack = t.Stack[:n-1]
+					t.PC = 0x0004
+					goto pc0004
+				}
+			}
+		}
+
+		if err := t.Exec(&code[1]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0004: // Dupe 0x00
+		if i := len(t.Stack) - 1; t.Costs == nil && i >= t.FrameBase() && fits(t) {
+			t.Stack = append(t.Stack, t.Stack[i])
+			t.PC = 0x0006
+			goto pc0006
+		}
+
+		if err := t.Exec(&code[2]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0006: // Push 0x24 float64 1.5
+		if t.Costs == nil && fits(t) {
+			t.Stack = append(t.Stack, vm.FloatSlot(math.Float64frombits(0x3FF8000000000000)))
+			t.PC = 0x000C
+			goto pc000C
+		}
+
+		if err := t.Exec(&code[3]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000C: // Compare 0x04
+		if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {
+			a, b := t.Stack[n-1], t.Stack[n-2]
+			switch {
+			case a.Type == typeid.Uint64 && b.Type == typeid.Uint64:
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Unsigned(), b.Unsigned()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			case a.Type == typeid.Int64 && b.Type == typeid.Int64:
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Signed(), b.Signed()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			case a.Type == typeid.Float64 && b.Type == typeid.Float64 && !math.IsNaN(a.Float()) && !math.IsNaN(b.Float()):
+				t.Stack[n-2] = boolSlot(cmp.Compare(a.Float(), b.Float()) > 0)
+				t.Stack = t.Stack[:n-1]
+				t.PC = 0x000E
+				goto pc000E
+			}
+		}
+
+		if err := t.Exec(&code[4]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc000E: // JumpIf 0x01 0x0013
+		if n := len(t.Stack); t.Costs == nil && n > t.FrameBase() {
+			if truth, ok := truthy(t.Stack[n-1]); ok {
+				t.Stack = t.Stack[:n-1]
+				if truth {
+					t.PC = 0x0013
+					continue
+				}
+
+				t.PC = 0x0011
+				goto pc0011
+			}
+		}
+
+		if err := t.Exec(&code[5]); err != nil {
+			return err
+		}
+
+		continue
+
+	pc0011: // Cast 0x45
+		if err := t.Exec(&code[6]); err != nil {
+			return err
+		}
+
+		continue
+
+	}
+}
+
+// fits returns if a value may be pushed onto the stack of the thread without
+// exceeding its stack limit.
+func fits(t *vm.Thread) bool {
+	return t.Limits.Stack <= 0 || t.StackUsed() < t.Limits.Stack
+}
+
+// truthy returns if the given slot is non-zero, or false as its second result
+// if it is not numeric.
+func truthy(s vm.Slot) (bool, bool) {
+	switch s.Type {
+	case typeid.Uint64, typeid.Int64:
+		return s.Bits != 0, true
+	case typeid.Float64:
+		return s.Float() != 0, true
+	default:
+		return false, false
+	}
+}
+
+// boolSlot returns 1 if b is true, otherwise 0.
+func boolSlot(b bool) vm.Slot {
+	if b {
+		return vm.UnsignedSlot(1)
+	}
+
+	return vm.UnsignedSlot(0)
+}
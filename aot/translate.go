@@ -0,0 +1,277 @@
+package aot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/vm"
+)
+
+const (
+	popImmediateBit  = 0x80
+	popImmediateMask = 0x7F
+
+	arithModeMask = 0xC0
+	arithModeWrap = 0x00
+	arithModeTrap = 0x40
+
+	jumpIfZeroBit   = 0x10
+	callArgsShift   = 4
+	returnCountMask = 0x0F
+)
+
+// relations holds the Go comparison of the order of the operands of a Compare
+// with 0 for each relation of its control byte.
+var relations = [...]string{"== 0", "!= 0", "< 0", "<= 0", "> 0", ">= 0"}
+
+// operandKind describes how the generated code reads operands of one stack
+// type.
+type operandKind struct {
+	name   string
+	typeID string
+	get    string
+	slot   string
+}
+
+var (
+	unsignedKind = operandKind{name: "U64", typeID: "typeid.Uint64", get: "Unsigned()", slot: "vm.UnsignedSlot"}
+	signedKind   = operandKind{name: "I64", typeID: "typeid.Int64", get: "Signed()", slot: "vm.SignedSlot"}
+	floatKind    = operandKind{name: "F64", typeID: "typeid.Float64", get: "Float()", slot: "vm.FloatSlot"}
+)
+
+// writeRun writes the Run function of the generated package.
+func writeRun(buf *bytes.Buffer, p *vm.Program) {
+	fmt.Fprintf(buf, "// Run runs the translated bytecode on the given thread until an error\n")
+	fmt.Fprintf(buf, "// occurs, as vm.Thread.Run would.\n")
+	fmt.Fprintf(buf, "//\n")
+	fmt.Fprintf(buf, "// The bytecode of the thread must be Data.\n")
+	fmt.Fprintf(buf, "func Run(t *vm.Thread) error {\n")
+	fmt.Fprintf(buf, "\tfor {\n")
+	fmt.Fprintf(buf, "\t\tswitch t.PC {\n")
+
+	for _, ins := range p.Instructions {
+		fmt.Fprintf(buf, "\t\tcase 0x%04X:\n\t\t\tgoto %s\n", ins.Offset, label(ins.Offset))
+	}
+
+	fmt.Fprintf(buf, "\t\tdefault:\n")
+	fmt.Fprintf(buf, "\t\t\tif err := t.Step(); err != nil {\n\t\t\t\treturn err\n\t\t\t}\n\n")
+	fmt.Fprintf(buf, "\t\t\tcontinue\n")
+	fmt.Fprintf(buf, "\t\t}\n\n")
+
+	for i, ins := range p.Instructions {
+		fmt.Fprintf(buf, "\t%s: // %s\n", label(ins.Offset), ins)
+		translate(buf, p, ins)
+		fmt.Fprintf(buf, "\t\tif err := t.Exec(&code[%d]); err != nil {\n\t\t\treturn err\n\t\t}\n\n", i)
+		fmt.Fprintf(buf, "\t\tcontinue\n\n")
+	}
+
+	fmt.Fprintf(buf, "\t}\n")
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+// label returns the label of the block of the instruction at the given
+// offset.
+func label(offset int) string {
+	return fmt.Sprintf("pc%04X", offset)
+}
+
+// jump returns the statements which continue execution at the given offset,
+// going straight to its block if an instruction starts there.
+func jump(p *vm.Program, offset int) string {
+	if _, ok := p.Lookup(offset); ok {
+		return fmt.Sprintf("t.PC = 0x%04X\ngoto %s\n", offset, label(offset))
+	}
+
+	return fmt.Sprintf("t.PC = 0x%04X\ncontinue\n", offset)
+}
+
+// translate writes the Go code which runs the given instruction without the
+// interpreter, if its opcode can be translated. The code leaves the thread
+// untouched and falls through to the code which follows when the instruction
+// could fault or the thread is metered.
+func translate(buf *bytes.Buffer, p *vm.Program, ins vm.Instruction) {
+	next := jump(p, ins.Next)
+
+	switch ins.Op {
+	case opcode.NoOp:
+		fmt.Fprintf(buf, "if t.Costs == nil {\n%s}\n\n", next)
+	case opcode.Jump:
+		fmt.Fprintf(buf, "if t.Costs == nil {\n%s}\n\n", jump(p, ins.Target))
+	case opcode.Push:
+		fmt.Fprintf(buf, "if t.Costs == nil && fits(t) {\nt.Stack = append(t.Stack, %s)\n%s}\n\n",
+			slotExpr(ins.Value), next)
+	case opcode.Dupe:
+		fmt.Fprintf(buf, "if i := len(t.Stack) - %d; t.Costs == nil && i >= t.FrameBase() && fits(t) {\n",
+			ins.Control+1)
+		fmt.Fprintf(buf, "t.Stack = append(t.Stack, t.Stack[i])\n%s}\n\n", next)
+	case opcode.Pop:
+		if ins.Control&popImmediateBit == 0 {
+			return
+		}
+
+		count := int(ins.Control&popImmediateMask) + 1
+		fmt.Fprintf(buf, "if n := len(t.Stack) - %d; t.Costs == nil && n >= t.FrameBase() {\n", count)
+		fmt.Fprintf(buf, "t.Stack = t.Stack[:n]\n%s}\n\n", next)
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv, opcode.Mod:
+		translateArith(buf, ins, next)
+	case opcode.Compare:
+		translateCompare(buf, ins, next)
+	case opcode.JumpIf:
+		cond := "truth"
+		if ins.Control&jumpIfZeroBit != 0 {
+			cond = "!truth"
+		}
+
+		fmt.Fprintf(buf, "if n := len(t.Stack); t.Costs == nil && n > t.FrameBase() {\n")
+		fmt.Fprintf(buf, "if truth, ok := truthy(t.Stack[n-1]); ok {\nt.Stack = t.Stack[:n-1]\n")
+		fmt.Fprintf(buf, "if %s {\n%s}\n\n%s}\n}\n\n", cond, jump(p, ins.Target), next)
+	case opcode.Call:
+		fmt.Fprintf(buf, "if base := len(t.Stack) - %d; t.Costs == nil && base >= t.FrameBase() &&\n",
+			ins.Control>>callArgsShift)
+		fmt.Fprintf(buf, "(t.Limits.Frames <= 0 || len(t.Frames) < t.Limits.Frames) {\n")
+		fmt.Fprintf(buf, "t.Frames = append(t.Frames, vm.Frame{Base: base, Caller: 0x%04X, Return: 0x%04X})\n%s}\n\n",
+			ins.Offset, ins.Next, jump(p, ins.Target))
+	case opcode.Return:
+		count := ins.Control & returnCountMask
+		fmt.Fprintf(buf, "if depth := len(t.Frames); t.Costs == nil && depth > 0 {\n")
+		fmt.Fprintf(buf, "if f := t.Frames[depth-1]; len(t.Stack)-%d >= f.Base {\n", count)
+		fmt.Fprintf(buf, "copy(t.Stack[f.Base:], t.Stack[len(t.Stack)-%d:])\n", count)
+		fmt.Fprintf(buf, "t.Stack = t.Stack[:f.Base+%d]\nt.Frames = t.Frames[:depth-1]\n", count)
+		fmt.Fprintf(buf, "t.PC = f.Return\ncontinue\n}\n}\n\n")
+	}
+}
+
+// translateArith writes the code of an arithmetic instruction whose operands
+// have the same stack type.
+func translateArith(buf *bytes.Buffer, ins vm.Instruction, next string) {
+	mode := ins.Control & arithModeMask
+
+	fmt.Fprintf(buf, "if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {\n")
+	fmt.Fprintf(buf, "a, b := t.Stack[n-1], t.Stack[n-2]\n")
+	fmt.Fprintf(buf, "switch {\n")
+
+	for _, kind := range []operandKind{unsignedKind, signedKind, floatKind} {
+		fmt.Fprintf(buf, "case a.Type == %s && b.Type == %s:\n", kind.typeID, kind.typeID)
+
+		result := fmt.Sprintf("t.Stack[n-2] = %s(r)\nt.Stack = t.Stack[:n-1]\n%s", kind.slot, next)
+		init, cond := arithExpr(ins.Op, mode, kind)
+		if cond == "" {
+			fmt.Fprintf(buf, "%s\n%s", init, result)
+		} else {
+			fmt.Fprintf(buf, "if %s; %s {\n%s}\n", init, cond, result)
+		}
+	}
+
+	fmt.Fprintf(buf, "}\n}\n\n")
+}
+
+// arithExpr returns the statement which sets r to the result of an arithmetic
+// opcode with operands a and b of the given kind, along with the condition
+// under which r holds the result the interpreter would give, if there is one.
+func arithExpr(op opcode.ID, mode uint8, kind operandKind) (string, string) {
+	a, b := "a."+kind.get, "b."+kind.get
+
+	if kind == floatKind {
+		var expr string
+		switch op {
+		case opcode.Add:
+			expr = a + " + " + b
+		case opcode.Sub:
+			expr = a + " - " + b
+		case opcode.Mul:
+			expr = a + " * " + b
+		case opcode.Div:
+			expr = a + " / " + b
+		case opcode.FDiv:
+			expr = "math.Floor(" + a + " / " + b + ")"
+		default:
+			expr = "math.Mod(" + a + ", " + b + ")"
+		}
+
+		// A NaN is left to the interpreter, which replaces it on a
+		// deterministic machine.
+		return "r := " + expr, "!math.IsNaN(r)"
+	}
+
+	args := "(" + a + ", " + b + ")"
+	switch op {
+	case opcode.Add, opcode.Sub, opcode.Mul:
+		name := op.String() + kind.name
+		switch mode {
+		case arithModeWrap:
+			return "r, _ := vmath." + name + args, ""
+		case arithModeTrap:
+			return "r, overflow := vmath." + name + args, "!overflow"
+		default:
+			return "r := vmath." + op.String() + "Sat" + kind.name + args, ""
+		}
+	case opcode.Div:
+		return "r, _, err := vmath.Div" + kind.name + args, "err == nil"
+	case opcode.FDiv:
+		if kind == signedKind {
+			return "r, _, err := vmath.FloorDivI64" + args, "err == nil"
+		}
+
+		return "r, _, err := vmath.DivU64" + args, "err == nil"
+	default:
+		return "_, r, err := vmath.Div" + kind.name + args, "err == nil"
+	}
+}
+
+// translateCompare writes the code of a Compare instruction whose operands
+// have the same stack type and are ordered.
+func translateCompare(buf *bytes.Buffer, ins vm.Instruction, next string) {
+	relation := relations[ins.Control]
+
+	fmt.Fprintf(buf, "if n := len(t.Stack); t.Costs == nil && n-2 >= t.FrameBase() {\n")
+	fmt.Fprintf(buf, "a, b := t.Stack[n-1], t.Stack[n-2]\n")
+	fmt.Fprintf(buf, "switch {\n")
+
+	for _, kind := range []operandKind{unsignedKind, signedKind, floatKind} {
+		fmt.Fprintf(buf, "case a.Type == %s && b.Type == %s", kind.typeID, kind.typeID)
+		if kind == floatKind {
+			fmt.Fprintf(buf, " && !math.IsNaN(a.Float()) && !math.IsNaN(b.Float())")
+		}
+
+		fmt.Fprintf(buf, ":\nt.Stack[n-2] = boolSlot(cmp.Compare(a.%s, b.%s) %s)\n", kind.get, kind.get, relation)
+		fmt.Fprintf(buf, "t.Stack = t.Stack[:n-1]\n%s", next)
+	}
+
+	fmt.Fprintf(buf, "}\n}\n\n")
+}
+
+// writeHelpers writes the functions used by the translated code which the
+// body written so far refers to.
+func writeHelpers(buf *bytes.Buffer) {
+	body := buf.String()
+
+	if strings.Contains(body, "fits(") {
+		fmt.Fprintf(buf, "// fits returns if a value may be pushed onto the stack of the thread without\n")
+		fmt.Fprintf(buf, "// exceeding its stack limit.\n")
+		fmt.Fprintf(buf, "func fits(t *vm.Thread) bool {\n")
+		fmt.Fprintf(buf, "\treturn t.Limits.Stack <= 0 || t.StackUsed() < t.Limits.Stack\n")
+		fmt.Fprintf(buf, "}\n\n")
+	}
+
+	if strings.Contains(body, "truthy(") {
+		fmt.Fprintf(buf, "// truthy returns if the given slot is non-zero, or false as its second result\n")
+		fmt.Fprintf(buf, "// if it is not numeric.\n")
+		fmt.Fprintf(buf, "func truthy(s vm.Slot) (bool, bool) {\n")
+		fmt.Fprintf(buf, "\tswitch s.Type {\n")
+		fmt.Fprintf(buf, "\tcase typeid.Uint64, typeid.Int64:\n\t\treturn s.Bits != 0, true\n")
+		fmt.Fprintf(buf, "\tcase typeid.Float64:\n\t\treturn s.Float() != 0, true\n")
+		fmt.Fprintf(buf, "\tdefault:\n\t\treturn false, false\n")
+		fmt.Fprintf(buf, "\t}\n")
+		fmt.Fprintf(buf, "}\n\n")
+	}
+
+	if strings.Contains(body, "boolSlot(") {
+		fmt.Fprintf(buf, "// boolSlot returns 1 if b is true, otherwise 0.\n")
+		fmt.Fprintf(buf, "func boolSlot(b bool) vm.Slot {\n")
+		fmt.Fprintf(buf, "\tif b {\n\t\treturn vm.UnsignedSlot(1)\n\t}\n\n")
+		fmt.Fprintf(buf, "\treturn vm.UnsignedSlot(0)\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+}
@@ -0,0 +1,84 @@
+// Package bench holds a corpus of representative bytecode programs used to
+// benchmark the virtual machine, along with the tools needed to compare the
+// results of two builds.
+//
+// The corpus covers arithmetic loops and call-heavy recursion. String, list
+// and map workloads will be added once those types exist.
+package bench
+
+import (
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+)
+
+const (
+	opPush    = uint8(opcode.Push)
+	opDupe    = uint8(opcode.Dupe)
+	opAdd     = uint8(opcode.Add)
+	opSub     = uint8(opcode.Sub)
+	opJump    = uint8(opcode.Jump)
+	opJumpIf  = uint8(opcode.JumpIf)
+	opCall    = uint8(opcode.Call)
+	opReturn  = uint8(opcode.Return)
+	opCompare = uint8(opcode.Compare)
+
+	modeWrap     = 0x00
+	modeTrap     = 0x40
+	modeSaturate = 0x80
+
+	compareGreater = 0x04
+)
+
+// Program is a single program of the benchmark corpus.
+type Program struct {
+	// Name is the name the program is benchmarked under.
+	Name string
+
+	// Data is the bytecode of the program.
+	Data []uint8
+
+	// Result is the stack expected once the program has finished.
+	Result []types.Value
+}
+
+// Corpus returns the programs of the benchmark corpus.
+func Corpus() []Program {
+	return []Program{
+		{Name: "Countdown/Wrap", Data: Countdown(1000, modeWrap), Result: []types.Value{types.Int64(0)}},
+		{Name: "Countdown/Trap", Data: Countdown(1000, modeTrap), Result: []types.Value{types.Int64(0)}},
+		{Name: "Countdown/Saturate", Data: Countdown(1000, modeSaturate), Result: []types.Value{types.Int64(0)}},
+		{Name: "Fib", Data: Fib(20), Result: []types.Value{types.Uint64(6765)}},
+	}
+}
+
+// Countdown returns a program which decrements a signed counter from n to 0
+// using the given arithmetic mode.
+func Countdown(n int16, mode uint8) []uint8 {
+	return []uint8{
+		// 0x00: Push i16 n
+		opPush, 0x12, uint8(n >> 8), uint8(n),
+		// 0x04: Push i8 -1, Add, Dupe 0, JumpIf 0x04
+		opPush, 0x11, 0xFF, opAdd, mode, opDupe, 0x00, opJumpIf, 0x01, 0x04,
+	}
+}
+
+// Fib returns a program which recursively calculates the nth fibonacci
+// number.
+//
+// The value of n must be less than 128.
+func Fib(n uint8) []uint8 {
+	return []uint8{
+		// 0x00: Push n, Call fib(n), Jump to the end
+		opPush, 0x80 | n, opCall, 0x11, 0x08, opJump, 0x01, 0x29,
+		// 0x08: if 2 > n return n
+		opDupe, 0x00, opPush, 0x82, opCompare, compareGreater, opJumpIf, 0x01, 0x27,
+		// 0x11: fib(n-1)
+		opPush, 0x81, opDupe, 0x01, opSub, modeWrap, opCall, 0x11, 0x08,
+		// 0x1A: fib(n-2)
+		opPush, 0x82, opDupe, 0x02, opSub, modeWrap, opCall, 0x11, 0x08,
+		// 0x23: Add, Return 1
+		opAdd, modeWrap, opReturn, 0x01,
+		// 0x27: Return 1
+		opReturn, 0x01,
+	}
+}
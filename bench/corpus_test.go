@@ -0,0 +1,103 @@
+package bench_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/verify"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range bench.Corpus() {
+		t.Run(p.Name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: p.Data}
+			require.ErrorIs(t, th.Run(), vm.ErrBytecodeOverflow)
+			require.Empty(t, th.Frames)
+			require.Equal(t, p.Result, vm.Values(th.Stack))
+		})
+	}
+}
+
+func BenchmarkCorpus(b *testing.B) {
+	for _, p := range bench.Corpus() {
+		b.Run(p.Name+"/Raw", func(b *testing.B) {
+			run(b, &vm.Thread{Data: p.Data})
+		})
+
+		b.Run(p.Name+"/Predecoded", func(b *testing.B) {
+			th := &vm.Thread{Data: p.Data}
+			th.Predecode()
+			th.Program.HotCalls = 0
+			run(b, th)
+		})
+
+		b.Run(p.Name+"/Specialized", func(b *testing.B) {
+			th := &vm.Thread{Data: p.Data}
+			if err := verify.Predecode(th); err != nil {
+				b.Fatal(err)
+			}
+
+			th.Program.HotCalls = 0
+			run(b, th)
+		})
+
+		b.Run(p.Name+"/Compiled", func(b *testing.B) {
+			th := &vm.Thread{Data: p.Data}
+			th.Predecode()
+			if err := th.Program.Compile(0); err != nil {
+				b.Fatal(err)
+			}
+
+			run(b, th)
+		})
+	}
+}
+
+// run benchmarks running the given thread to completion, reporting the number
+// of instructions executed per second.
+func run(b *testing.B, th *vm.Thread) {
+	b.Helper()
+
+	steps := count(b, th.Data)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		th.PC = 0
+		th.Stack = th.Stack[:0]
+		th.Frames = th.Frames[:0]
+
+		if err := th.Run(); !errors.Is(err, vm.ErrBytecodeOverflow) {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(steps)*float64(b.N)/b.Elapsed().Seconds(), "instr/s")
+}
+
+// count returns the number of instructions run by the given bytecode.
+func count(b *testing.B, data []uint8) int {
+	b.Helper()
+
+	th := &vm.Thread{Data: data}
+
+	steps := 0
+	err := th.Step()
+	for ; err == nil; err = th.Step() {
+		steps++
+	}
+
+	if !errors.Is(err, vm.ErrBytecodeOverflow) {
+		b.Fatal(err)
+	}
+
+	return steps
+}
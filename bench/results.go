@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Results holds every sample of every metric reported by a set of benchmarks,
+// keyed by benchmark name and then by unit.
+type Results map[string]map[string][]float64
+
+// Parse reads the output of `go test -bench` and returns the results held in
+// it.
+//
+// Lines which are not benchmark results are ignored. The `Benchmark` prefix
+// and the GOMAXPROCS suffix are removed from the benchmark names, so results
+// from machines with different numbers of CPUs may be compared.
+func Parse(r io.Reader) (Results, error) {
+	results := Results{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+
+		name := benchmarkName(fields[0])
+		for i := 2; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+
+			if results[name] == nil {
+				results[name] = map[string][]float64{}
+			}
+
+			results[name][fields[i+1]] = append(results[name][fields[i+1]], v)
+		}
+	}
+
+	return results, scanner.Err()
+}
+
+// benchmarkName strips the prefix and GOMAXPROCS suffix from the name of a
+// benchmark.
+func benchmarkName(name string) string {
+	name = strings.TrimPrefix(name, "Benchmark")
+	if idx := strings.LastIndexByte(name, '-'); idx >= 0 {
+		if _, err := strconv.Atoi(name[idx+1:]); err == nil {
+			return name[:idx]
+		}
+	}
+
+	return name
+}
+
+// Delta is the change of a single metric of a benchmark between two sets of
+// results.
+type Delta struct {
+	Name string
+	Unit string
+	Old  float64
+	New  float64
+}
+
+// Change returns the relative change from the old to the new value, e.g.
+// -0.25 for a drop of 25%.
+//
+// If the old value is 0 the change is 0 when the new value is too, otherwise
+// it is an infinity.
+func (d Delta) Change() float64 {
+	if d.Old == 0 {
+		if d.New == 0 {
+			return 0
+		}
+
+		return math.Inf(int(math.Copysign(1, d.New)))
+	}
+
+	return (d.New - d.Old) / d.Old
+}
+
+// Compare returns the change in the mean of every metric reported in both sets
+// of results.
+//
+// The deltas are ordered by benchmark name, and then by unit with the time per
+// operation first.
+func Compare(old, updated Results) []Delta {
+	var deltas []Delta
+	for name, metrics := range updated {
+		for unit, samples := range metrics {
+			before, ok := old[name][unit]
+			if !ok {
+				continue
+			}
+
+			deltas = append(deltas, Delta{Name: name, Unit: unit, Old: mean(before), New: mean(samples)})
+		}
+	}
+
+	slices.SortFunc(deltas, func(a, b Delta) int {
+		if a.Name != b.Name {
+			return strings.Compare(a.Name, b.Name)
+		}
+
+		if ra, rb := unitRank(a.Unit), unitRank(b.Unit); ra != rb {
+			return ra - rb
+		}
+
+		return strings.Compare(a.Unit, b.Unit)
+	})
+
+	return deltas
+}
+
+// unitRank returns the position of a unit when ordering deltas.
+func unitRank(unit string) int {
+	switch unit {
+	case "ns/op":
+		return 0
+	case "instr/s":
+		return 1
+	case "B/op":
+		return 2
+	case "allocs/op":
+		return 3
+	default:
+		return 4
+	}
+}
+
+// mean returns the arithmetic mean of the given samples.
+func mean(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, v := range samples {
+		total += v
+	}
+
+	return total / float64(len(samples))
+}
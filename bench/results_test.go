@@ -0,0 +1,82 @@
+package bench_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: github.com/tvarney/illvm/bench
+BenchmarkCorpus/Fib/Raw-8   	     200	   5000 ns/op	  40000000 instr/s	       0 B/op	       0 allocs/op
+BenchmarkCorpus/Fib/Raw-8   	     200	   7000 ns/op	  20000000 instr/s	       0 B/op	       0 allocs/op
+BenchmarkThreadPushPop      	    5000	   75.5 ns/op
+BenchmarkBroken             	    many	   75.5 ns/op
+PASS
+ok  	github.com/tvarney/illvm/bench	1.971s
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	results, err := bench.Parse(strings.NewReader(output))
+	require.NoError(t, err)
+	require.Equal(t, bench.Results{
+		"Corpus/Fib/Raw": {
+			"ns/op":     {5000, 7000},
+			"instr/s":   {40000000, 20000000},
+			"B/op":      {0, 0},
+			"allocs/op": {0, 0},
+		},
+		"ThreadPushPop": {"ns/op": {75.5}},
+	}, results)
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	old := bench.Results{
+		"A": {"allocs/op": {1}, "ns/op": {100, 300}, "custom": {2}},
+		"B": {"ns/op": {10}},
+		"C": {"ns/op": {10}},
+	}
+	updated := bench.Results{
+		"A": {"allocs/op": {0}, "ns/op": {150}, "custom": {2}},
+		"B": {"ns/op": {10}, "B/op": {8}},
+		"D": {"ns/op": {10}},
+	}
+
+	require.Equal(t, []bench.Delta{
+		{Name: "A", Unit: "ns/op", Old: 200, New: 150},
+		{Name: "A", Unit: "allocs/op", Old: 1, New: 0},
+		{Name: "A", Unit: "custom", Old: 2, New: 2},
+		{Name: "B", Unit: "ns/op", Old: 10, New: 10},
+	}, bench.Compare(old, updated))
+}
+
+func TestDeltaChange(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		old      float64
+		updated  float64
+		expected float64
+	}{
+		{"Drop", 200, 150, -0.25},
+		{"Rise", 100, 150, 0.5},
+		{"Zero", 0, 0, 0},
+		{"FromZero", 0, 8, math.Inf(1)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := bench.Delta{Name: "A", Unit: "ns/op", Old: test.old, New: test.updated}
+			require.Equal(t, test.expected, d.Change())
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package bytecode
+
+import (
+	"io"
+)
+
+// Reader reads values written by a Writer from an underlying [io.Reader].
+//
+// If the underlying reader ends before a value is complete the error is
+// [io.ErrUnexpectedEOF], or [io.EOF] if no byte of the value was read.
+type Reader struct {
+	reader io.Reader
+}
+
+// NewReader returns a Reader wrapped around the given [io.Reader].
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: r}
+}
+
+// ReadU8 reads a byte from the underlying reader.
+func (r *Reader) ReadU8() (uint8, error) {
+	v, err := r.readUnsigned(1)
+	return uint8(v), err
+}
+
+// ReadU16 reads a uint16 written as two bytes from the underlying reader.
+func (r *Reader) ReadU16() (uint16, error) {
+	v, err := r.readUnsigned(2)
+	return uint16(v), err
+}
+
+// ReadU32 reads a uint32 written as four bytes from the underlying reader.
+func (r *Reader) ReadU32() (uint32, error) {
+	v, err := r.readUnsigned(4)
+	return uint32(v), err
+}
+
+// ReadU64 reads a uint64 written as eight bytes from the underlying reader.
+func (r *Reader) ReadU64() (uint64, error) {
+	return r.readUnsigned(8)
+}
+
+// ReadBytes reads the given number of bytes from the underlying reader.
+func (r *Reader) ReadBytes(count int) ([]byte, error) {
+	b := make([]byte, count)
+	if _, err := io.ReadFull(r.reader, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// readUnsigned reads an unsigned integer written as the given number of bytes,
+// most significant first.
+func (r *Reader) readUnsigned(size int) (uint64, error) {
+	b, err := r.ReadBytes(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, octet := range b {
+		v = v<<8 | uint64(octet)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,55 @@
+package bytecode_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bytecode"
+)
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	buf := bytes.Buffer{}
+	w := bytecode.NewWriter(&buf)
+	for _, write := range []func() (int, error){
+		func() (int, error) { return w.WriteU8(0x69) },
+		func() (int, error) { return w.WriteU16(0x1F20) },
+		func() (int, error) { return w.WriteU32(0x01020304) },
+		func() (int, error) { return w.WriteU64(0xFEDCBA9876543210) },
+		func() (int, error) { return w.WriteBytes([]byte("illvm")) },
+	} {
+		_, err := write()
+		require.NoError(t, err)
+	}
+
+	r := bytecode.NewReader(&buf)
+
+	u8, err := r.ReadU8()
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x69), u8)
+
+	u16, err := r.ReadU16()
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x1F20), u16)
+
+	u32, err := r.ReadU32()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x01020304), u32)
+
+	u64, err := r.ReadU64()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0xFEDCBA9876543210), u64)
+
+	b, err := r.ReadBytes(5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("illvm"), b)
+
+	_, err = r.ReadU8()
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = bytecode.NewReader(bytes.NewReader([]byte{0x01})).ReadU16()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
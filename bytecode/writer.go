@@ -121,3 +121,8 @@ func (w *Writer) WriteU64(u uint64) (int, error) {
 		uint8((u & 0x00000000000000FF) >> 0),
 	})
 }
+
+// WriteBytes writes the given bytes as they are to the underlying writer.
+func (w *Writer) WriteBytes(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
@@ -0,0 +1,110 @@
+// Command illbench compares the benchmark results of two builds of illvm.
+//
+// Usage:
+//
+//	illbench [flags] OLD NEW
+//
+// OLD and NEW are either checkouts of the module, in which case the benchmarks
+// are run in each, or files holding the saved output of `go test -bench`. The
+// mean of every metric reported by both is printed along with the relative
+// change from OLD to NEW.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/tvarney/illvm/bench"
+)
+
+func main() {
+	flags := flag.NewFlagSet("illbench", flag.ExitOnError)
+	pattern := flags.String("bench", ".", "run only the benchmarks matching the regular expression")
+	count := flags.Int("count", 5, "run each benchmark the given number of times")
+	pkg := flags.String("pkg", "./bench", "the package holding the benchmarks")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: illbench [flags] OLD NEW\n")
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	args := []string{
+		"test", "-run", "^$", "-bench", *pattern, "-benchmem",
+		"-count", strconv.Itoa(*count), *pkg,
+	}
+
+	old, err := load(flags.Arg(0), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	updated, err := load(flags.Arg(1), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := report(os.Stdout, bench.Compare(old, updated)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// load returns the benchmark results of the given path.
+//
+// If the path is a directory the benchmarks are run there with `go` and the
+// given arguments, otherwise the path is read as saved benchmark output.
+func load(path string, args []string) (bench.Results, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return bench.Parse(f)
+	}
+
+	var out bytes.Buffer
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = path
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+
+	fmt.Fprintf(os.Stderr, "running benchmarks in %s\n", path)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running benchmarks in %s: %w", path, err)
+	}
+
+	return bench.Parse(&out)
+}
+
+// report writes the given deltas as a table.
+func report(w io.Writer, deltas []bench.Delta) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tunit\told\tnew\tdelta")
+
+	for _, d := range deltas {
+		fmt.Fprintf(tw, "%s\t%s\t%.4g\t%.4g\t%+.2f%%\n", d.Name, d.Unit, d.Old, d.New, d.Change()*100)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,66 @@
+// Command illvm2go translates a bytecode file into a Go package.
+//
+// Usage:
+//
+//	illvm2go [flags] INPUT
+//
+// The generated package holds the bytecode along with a Run function which
+// executes it exactly as the interpreter would. By default the package is
+// named after the input file and written to standard output.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tvarney/illvm/aot"
+)
+
+func main() {
+	flags := flag.NewFlagSet("illvm2go", flag.ExitOnError)
+	pkg := flags.String("pkg", "", "the name of the generated package (default: the input file name)")
+	out := flags.String("o", "", "the file to write the generated package to (default: standard output)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: illvm2go [flags] INPUT\n")
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flags.Arg(0), *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run translates the bytecode file at input and writes the result to out.
+func run(input, pkg, out string) error {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	if pkg == "" {
+		pkg = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	}
+
+	var buf bytes.Buffer
+	if err := aot.Generate(&buf, pkg, data); err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	return os.WriteFile(out, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,124 @@
+package opcode
+
+// Variable is the value of Info.Pops and Info.Pushes for opcodes whose stack
+// effect depends on their control byte or on the stack itself.
+const Variable = -1
+
+// Info describes the encoding and stack effect of an opcode.
+type Info struct {
+	// Control is if the opcode is followed by a control byte.
+	Control bool
+
+	// Pops is the number of values the opcode removes from the stack, or
+	// Variable.
+	Pops int
+
+	// Pushes is the number of values the opcode adds to the stack, or
+	// Variable.
+	Pushes int
+
+	// Branch is if the opcode may continue at the target held in its
+	// immediate.
+	Branch bool
+
+	// Terminal is if execution never continues with the following opcode.
+	Terminal bool
+
+	// Cost is the default fuel cost of running the opcode. See Costs.
+	Cost uint64
+}
+
+// Lookup returns the Info of the given opcode.
+//
+// If the opcode is not defined, or is a superinstruction, false is returned.
+func Lookup(id ID) (Info, bool) {
+	switch id {
+	case NoOp:
+		return Info{Control: false, Pops: 0, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true
+	case Push:
+		return Info{Control: true, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 1}, true
+	case Dupe:
+		return Info{Control: true, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 1}, true
+	case Pop:
+		return Info{Control: true, Pops: Variable, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true
+	case Add, Sub, Mul, Compare:
+		return Info{Control: true, Pops: 2, Pushes: 1, Branch: false, Terminal: false, Cost: 1}, true
+	case Div, FDiv, Mod:
+		return Info{Control: true, Pops: 2, Pushes: 1, Branch: false, Terminal: false, Cost: 4}, true
+	case DivMod:
+		return Info{Control: true, Pops: 2, Pushes: 2, Branch: false, Terminal: false, Cost: 4}, true
+	case Jump:
+		return Info{Control: true, Pops: 0, Pushes: 0, Branch: true, Terminal: true, Cost: 1}, true
+	case JumpIf:
+		return Info{Control: true, Pops: 1, Pushes: 0, Branch: true, Terminal: false, Cost: 1}, true
+	case Call:
+		return Info{Control: true, Pops: Variable, Pushes: Variable, Branch: false, Terminal: false, Cost: 5}, true
+	case Return:
+		return Info{Control: true, Pops: Variable, Pushes: 0, Branch: false, Terminal: true, Cost: 3}, true
+	case Throw:
+		return Info{Control: false, Pops: 1, Pushes: 0, Branch: false, Terminal: true, Cost: 10}, true
+	case Cast:
+		return Info{Control: true, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 2}, true
+	case Yield:
+		return Info{Control: false, Pops: 0, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true
+	case Join:
+		return Info{Control: false, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true
+	case Chan:
+		return Info{Control: true, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 5}, true
+	case Send:
+		return Info{Control: false, Pops: 2, Pushes: 0, Branch: false, Terminal: false, Cost: 2}, true
+	case Close:
+		return Info{Control: false, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 2}, true
+	case Recv, TryRecv:
+		return Info{Control: false, Pops: 1, Pushes: 2, Branch: false, Terminal: false, Cost: 2}, true
+	case Select:
+		return Info{Control: true, Pops: Variable, Pushes: 3, Branch: false, Terminal: false, Cost: 4}, true
+	case CoCreate:
+		return Info{Control: true, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 5}, true
+	case CoResume:
+		return Info{Control: false, Pops: 2, Pushes: 2, Branch: false, Terminal: false, Cost: 5}, true
+	case CoYield:
+		return Info{Control: false, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 5}, true
+	case LoadGlobal:
+		return Info{Control: true, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 2}, true
+	case StoreGlobal:
+		return Info{Control: true, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 2}, true
+	case LoadLocal:
+		return Info{Control: true, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 1}, true
+	case StoreLocal:
+		return Info{Control: true, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true
+	case CallHost:
+		return Info{Control: true, Pops: Variable, Pushes: 1, Branch: false, Terminal: false, Cost: 5}, true
+	case Random, Time:
+		return Info{Control: false, Pops: 0, Pushes: 1, Branch: false, Terminal: false, Cost: 2}, true
+	case Weak:
+		return Info{Control: false, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 5}, true
+	case Deref:
+		return Info{Control: false, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 2}, true
+	default:
+		return Info{Control: false, Pops: 0, Pushes: 0, Branch: false, Terminal: false, Cost: 0}, false
+	}
+}
+
+// Costs holds the fuel cost of running each opcode, indexed by ID.
+//
+// A superinstruction costs as much as the opcodes fused into it, so its own
+// entry is never used.
+type Costs [256]uint64
+
+// DefaultCosts returns the default cost of every opcode, as given by Lookup.
+//
+// Opcodes which are not defined cost 1, so that a thread with no fuel left
+// never runs another opcode. The returned table may be changed to adjust the
+// cost of individual opcodes.
+func DefaultCosts() *Costs {
+	var costs Costs
+	for i := range costs {
+		costs[i] = 1
+		if info, ok := Lookup(ID(i)); ok {
+			costs[i] = info.Cost
+		}
+	}
+
+	return &costs
+}
@@ -0,0 +1,75 @@
+package opcode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		id       opcode.ID
+		expected opcode.Info
+		ok       bool
+	}{
+		{"NoOp", opcode.NoOp, opcode.Info{Control: false, Pops: 0, Pushes: 0, Branch: false, Terminal: false, Cost: 1}, true},
+		{"DivMod", opcode.DivMod, opcode.Info{
+			Control: true, Pops: 2, Pushes: 2, Branch: false, Terminal: false, Cost: 4,
+		}, true},
+		{"Jump", opcode.Jump, opcode.Info{Control: true, Pops: 0, Pushes: 0, Branch: true, Terminal: true, Cost: 1}, true},
+		{"JumpIf", opcode.JumpIf, opcode.Info{
+			Control: true, Pops: 1, Pushes: 0, Branch: true, Terminal: false, Cost: 1,
+		}, true},
+		{"Call", opcode.Call, opcode.Info{
+			Control: true, Pops: opcode.Variable, Pushes: opcode.Variable, Branch: false, Terminal: false, Cost: 5,
+		}, true},
+		{"Throw", opcode.Throw, opcode.Info{
+			Control: false, Pops: 1, Pushes: 0, Branch: false, Terminal: true, Cost: 10,
+		}, true},
+		{"Join", opcode.Join, opcode.Info{
+			Control: false, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 1,
+		}, true},
+		{"Select", opcode.Select, opcode.Info{
+			Control: true, Pops: opcode.Variable, Pushes: 3, Branch: false, Terminal: false, Cost: 4,
+		}, true},
+		{"CoResume", opcode.CoResume, opcode.Info{
+			Control: false, Pops: 2, Pushes: 2, Branch: false, Terminal: false, Cost: 5,
+		}, true},
+		{"StoreGlobal", opcode.StoreGlobal, opcode.Info{
+			Control: true, Pops: 1, Pushes: 0, Branch: false, Terminal: false, Cost: 2,
+		}, true},
+		{"CallHost", opcode.CallHost, opcode.Info{
+			Control: true, Pops: opcode.Variable, Pushes: 1, Branch: false, Terminal: false, Cost: 5,
+		}, true},
+		{"Weak", opcode.Weak, opcode.Info{
+			Control: false, Pops: 1, Pushes: 1, Branch: false, Terminal: false, Cost: 5,
+		}, true},
+		{"Superinstruction", opcode.PushArith, opcode.Info{}, false},
+		{"Undefined", opcode.ID(0xFF), opcode.Info{}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			info, ok := opcode.Lookup(test.id)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.expected, info)
+		})
+	}
+}
+
+func TestDefaultCosts(t *testing.T) {
+	t.Parallel()
+
+	costs := opcode.DefaultCosts()
+	require.Equal(t, uint64(1), costs[opcode.Add])
+	require.Equal(t, uint64(4), costs[opcode.Div])
+	require.Equal(t, uint64(5), costs[opcode.Call])
+	require.Equal(t, uint64(1), costs[0xFF])
+
+	costs[opcode.Add] = 7
+	require.Equal(t, uint64(1), opcode.DefaultCosts()[opcode.Add])
+}
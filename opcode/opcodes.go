@@ -25,4 +25,152 @@ const (
 	Or  // [.., A, B] -> [.., A | B]
 	Xor // [.., A, B] -> [.., A ^ B]
 	Not // [.., V]    -> [.., ~V]
+
+	Jump   // [..]              -> [..]
+	JumpIf // [.., V]           -> [..]
+	Call   // [.., s1..sA]      -> [.. | s1..sA]
+	Return // [.. | .., s1..sR] -> [.., s1..sR]
+	Throw  // [.., V]           -> !V
+
+	Cast // [.., V] -> [.., T(V)]
+
+	Compare // [.., A, B] -> [.., B ? A]
+
+	Yield // [..]     -> [..]
+	Join  // [.., ID] -> [..]
+
+	Chan    // [.., N]      -> [.., C]
+	Send    // [.., V, C]   -> [..]
+	Recv    // [.., C]      -> [.., V, S]
+	TryRecv // [.., C]      -> [.., V, S]
+	Close   // [.., C]      -> [..]
+	Select  // [.., C1..CN] -> [.., V, S, I]
+
+	CoCreate // [..]       -> [.., K]
+	CoResume // [.., V, K] -> [.., R, S]
+	CoYield  // [.., V]    -> [.., W]
+
+	LoadGlobal  // [..]    -> [.., V]
+	StoreGlobal // [.., V] -> [..]
+	LoadLocal   // [..]    -> [.., V]
+	StoreLocal  // [.., V] -> [..]
+
+	CallHost // [.., s1..sA] -> [.., R]
+	Random   // [..]         -> [.., N]
+	Time     // [..]         -> [.., T]
+
+	Weak  // [.., R] -> [.., W]
+	Deref // [.., W] -> [.., R]
 )
+
+// Superinstructions are never found in bytecode. They are created when the
+// bytecode is decoded, by fusing a common sequence of opcodes into a single
+// instruction.
+const (
+	// PushArith is a Push followed by one of the math opcodes.
+	PushArith ID = 0xF0 + iota
+
+	// CompareJumpIf is a Compare followed by a JumpIf.
+	CompareJumpIf
+)
+
+func (i ID) String() string {
+	switch i {
+	case NoOp:
+		return "NoOp"
+	case Push:
+		return "Push"
+	case Dupe:
+		return "Dupe"
+	case Pop:
+		return "Pop"
+	case Swap:
+		return "Swap"
+	case Reverse:
+		return "Reverse"
+	case Length:
+		return "Length"
+	case Add:
+		return "Add"
+	case Sub:
+		return "Sub"
+	case Mul:
+		return "Mul"
+	case Div:
+		return "Div"
+	case FDiv:
+		return "FDiv"
+	case Mod:
+		return "Mod"
+	case DivMod:
+		return "DivMod"
+	case And:
+		return "And"
+	case Or:
+		return "Or"
+	case Xor:
+		return "Xor"
+	case Not:
+		return "Not"
+	case Jump:
+		return "Jump"
+	case JumpIf:
+		return "JumpIf"
+	case Call:
+		return "Call"
+	case Return:
+		return "Return"
+	case Throw:
+		return "Throw"
+	case Cast:
+		return "Cast"
+	case Compare:
+		return "Compare"
+	case Yield:
+		return "Yield"
+	case Join:
+		return "Join"
+	case Chan:
+		return "Chan"
+	case Send:
+		return "Send"
+	case Recv:
+		return "Recv"
+	case TryRecv:
+		return "TryRecv"
+	case Close:
+		return "Close"
+	case Select:
+		return "Select"
+	case CoCreate:
+		return "CoCreate"
+	case CoResume:
+		return "CoResume"
+	case CoYield:
+		return "CoYield"
+	case LoadGlobal:
+		return "LoadGlobal"
+	case StoreGlobal:
+		return "StoreGlobal"
+	case LoadLocal:
+		return "LoadLocal"
+	case StoreLocal:
+		return "StoreLocal"
+	case CallHost:
+		return "CallHost"
+	case Random:
+		return "Random"
+	case Time:
+		return "Time"
+	case Weak:
+		return "Weak"
+	case Deref:
+		return "Deref"
+	case PushArith:
+		return "PushArith"
+	case CompareJumpIf:
+		return "CompareJumpIf"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,75 @@
+package opcode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+)
+
+func TestID(t *testing.T) {
+	t.Parallel()
+
+	t.Run("String", func(t *testing.T) {
+		t.Parallel()
+
+		for _, test := range []struct {
+			id       opcode.ID
+			expected string
+		}{
+			{opcode.NoOp, "NoOp"},
+			{opcode.Push, "Push"},
+			{opcode.Dupe, "Dupe"},
+			{opcode.Pop, "Pop"},
+			{opcode.Swap, "Swap"},
+			{opcode.Reverse, "Reverse"},
+			{opcode.Length, "Length"},
+			{opcode.Add, "Add"},
+			{opcode.Sub, "Sub"},
+			{opcode.Mul, "Mul"},
+			{opcode.Div, "Div"},
+			{opcode.FDiv, "FDiv"},
+			{opcode.Mod, "Mod"},
+			{opcode.DivMod, "DivMod"},
+			{opcode.And, "And"},
+			{opcode.Or, "Or"},
+			{opcode.Xor, "Xor"},
+			{opcode.Not, "Not"},
+			{opcode.Jump, "Jump"},
+			{opcode.JumpIf, "JumpIf"},
+			{opcode.Call, "Call"},
+			{opcode.Return, "Return"},
+			{opcode.Throw, "Throw"},
+			{opcode.Cast, "Cast"},
+			{opcode.Compare, "Compare"},
+			{opcode.Yield, "Yield"},
+			{opcode.Join, "Join"},
+			{opcode.Chan, "Chan"},
+			{opcode.Send, "Send"},
+			{opcode.Recv, "Recv"},
+			{opcode.TryRecv, "TryRecv"},
+			{opcode.Close, "Close"},
+			{opcode.Select, "Select"},
+			{opcode.CoCreate, "CoCreate"},
+			{opcode.CoResume, "CoResume"},
+			{opcode.CoYield, "CoYield"},
+			{opcode.LoadGlobal, "LoadGlobal"},
+			{opcode.StoreGlobal, "StoreGlobal"},
+			{opcode.LoadLocal, "LoadLocal"},
+			{opcode.StoreLocal, "StoreLocal"},
+			{opcode.CallHost, "CallHost"},
+			{opcode.Random, "Random"},
+			{opcode.Time, "Time"},
+			{opcode.Weak, "Weak"},
+			{opcode.Deref, "Deref"},
+			{opcode.PushArith, "PushArith"},
+			{opcode.CompareJumpIf, "CompareJumpIf"},
+			{opcode.ID(255), "unknown"},
+		} {
+			t.Run(test.expected, func(t *testing.T) {
+				t.Parallel()
+				require.Equal(t, test.expected, test.id.String())
+			})
+		}
+	})
+}
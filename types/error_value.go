@@ -0,0 +1,53 @@
+package types
+
+import (
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Error is a VM fault which has been caught by an exception handler.
+//
+// Error implements the StackValue interface, allowing a handler to inspect or
+// rethrow the fault. It may not be cast to any other type.
+type Error struct {
+	Err error
+}
+
+func (e Error) ID() typeid.ID {
+	return typeid.Error
+}
+
+func (e Error) Size() int {
+	return 8
+}
+
+func (e Error) Upcast() StackValue {
+	return e
+}
+
+func (e Error) Downcast(to typeid.ID) (Value, error) {
+	if to == typeid.Error {
+		return e, nil
+	}
+
+	return nil, CastError{From: typeid.Error, To: to}
+}
+
+func (e Error) DowncastChecked(to typeid.ID) (Value, error) {
+	return e.Downcast(to)
+}
+
+func (e Error) DowncastSaturating(to typeid.ID) (Value, error) {
+	return e.Downcast(to)
+}
+
+func (e Error) Error() string {
+	if e.Err == nil {
+		return "error"
+	}
+
+	return e.Err.Error()
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
@@ -16,3 +16,14 @@ type CastError struct {
 func (e CastError) Error() string {
 	return fmt.Sprintf("unable to cast %s to %s", e.From, e.To)
 }
+
+// OverflowError is an error type which indicates that a value was out of the
+// range of the type it was being converted to.
+type OverflowError struct {
+	From typeid.ID
+	To   typeid.ID
+}
+
+func (e OverflowError) Error() string {
+	return fmt.Sprintf("%s value overflows %s", e.From, e.To)
+}
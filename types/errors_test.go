@@ -29,3 +29,24 @@ func TestCastError(t *testing.T) {
 		})
 	}
 }
+
+func TestOverflowError(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		from     typeid.ID
+		to       typeid.ID
+		expected string
+	}{
+		{"Uint64ToUint8", typeid.Uint64, typeid.Uint8, "uint64 value overflows uint8"},
+		{"Float64ToInt32", typeid.Float64, typeid.Int32, "float64 value overflows int32"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			e := types.OverflowError{From: test.from, To: test.to}
+			require.Equal(t, test.expected, e.Error())
+		})
+	}
+}
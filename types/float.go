@@ -1,6 +1,8 @@
 package types
 
 import (
+	"math"
+
 	"github.com/tvarney/illvm/types/typeid"
 )
 
@@ -58,3 +60,38 @@ func (f Float64) Downcast(to typeid.ID) (Value, error) {
 		return nil, CastError{From: typeid.Float64, To: to}
 	}
 }
+
+func (f Float64) DowncastChecked(to typeid.ID) (Value, error) {
+	if lo, hi, ok := integerRange(to); ok {
+		lower, upper := floatBounds(lo, hi)
+		if v := math.Trunc(float64(f)); math.IsNaN(v) || v < lower || v >= upper {
+			return nil, OverflowError{From: typeid.Float64, To: to}
+		}
+	}
+
+	if to == typeid.Float32 && !math.IsInf(float64(f), 0) && math.Abs(float64(f)) > math.MaxFloat32 {
+		return nil, OverflowError{From: typeid.Float64, To: to}
+	}
+
+	return f.Downcast(to)
+}
+
+func (f Float64) DowncastSaturating(to typeid.ID) (Value, error) {
+	if lo, hi, ok := integerRange(to); ok {
+		lower, upper := floatBounds(lo, hi)
+		switch v := math.Trunc(float64(f)); {
+		case math.IsNaN(v):
+			return Uint64(0).Downcast(to)
+		case v < lower:
+			return Int64(lo).Downcast(to)
+		case v >= upper:
+			return Uint64(hi).Downcast(to)
+		}
+	}
+
+	if to == typeid.Float32 && !math.IsInf(float64(f), 0) && math.Abs(float64(f)) > math.MaxFloat32 {
+		return Float32(math.Copysign(math.MaxFloat32, float64(f))), nil
+	}
+
+	return f.Downcast(to)
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// HostTag identifies the kind of Go value held by a Host value, such as a file
+// handle or a database cursor. Tags are chosen by the embedder.
+type HostTag uint32
+
+// Host is an opaque value wrapping a Go value provided by the embedder.
+//
+// Scripts may hold host values on the stack and pass them back to the host,
+// but can not inspect them or cast them to any other type.
+type Host struct {
+	Tag   HostTag
+	Value any
+}
+
+func (h Host) ID() typeid.ID {
+	return typeid.Host
+}
+
+func (h Host) Size() int {
+	return 24
+}
+
+func (h Host) Upcast() StackValue {
+	return h
+}
+
+func (h Host) Downcast(to typeid.ID) (Value, error) {
+	if to == typeid.Host {
+		return h, nil
+	}
+
+	return nil, CastError{From: typeid.Host, To: to}
+}
+
+func (h Host) DowncastChecked(to typeid.ID) (Value, error) {
+	return h.Downcast(to)
+}
+
+func (h Host) DowncastSaturating(to typeid.ID) (Value, error) {
+	return h.Downcast(to)
+}
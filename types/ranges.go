@@ -0,0 +1,42 @@
+package types
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// integerRange returns the inclusive range of the given integer type.
+//
+// If the type is not an integer type, ok is false.
+func integerRange(id typeid.ID) (lo int64, hi uint64, ok bool) {
+	switch id {
+	case typeid.Uint8:
+		return 0, math.MaxUint8, true
+	case typeid.Uint16:
+		return 0, math.MaxUint16, true
+	case typeid.Uint32:
+		return 0, math.MaxUint32, true
+	case typeid.Uint64:
+		return 0, math.MaxUint64, true
+	case typeid.Int8:
+		return math.MinInt8, math.MaxInt8, true
+	case typeid.Int16:
+		return math.MinInt16, math.MaxInt16, true
+	case typeid.Int32:
+		return math.MinInt32, math.MaxInt32, true
+	case typeid.Int64:
+		return math.MinInt64, math.MaxInt64, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// floatBounds returns the range of an integer type as float64 values.
+//
+// The lower bound is inclusive and the upper bound exclusive. As the upper
+// bound of an integer range is always one less than a power of two, both are
+// exactly representable.
+func floatBounds(lo int64, hi uint64) (float64, float64) {
+	return float64(lo), float64(hi/2+1) * 2
+}
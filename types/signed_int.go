@@ -102,3 +102,24 @@ func (i Int64) Downcast(to typeid.ID) (Value, error) {
 		return nil, CastError{From: typeid.Int64, To: to}
 	}
 }
+
+func (i Int64) DowncastChecked(to typeid.ID) (Value, error) {
+	if lo, hi, ok := integerRange(to); ok && (int64(i) < lo || i > 0 && uint64(i) > hi) {
+		return nil, OverflowError{From: typeid.Int64, To: to}
+	}
+
+	return i.Downcast(to)
+}
+
+func (i Int64) DowncastSaturating(to typeid.ID) (Value, error) {
+	if lo, hi, ok := integerRange(to); ok {
+		switch {
+		case int64(i) < lo:
+			return Int64(lo).Downcast(to)
+		case i > 0 && uint64(i) > hi:
+			return Uint64(hi).Downcast(to)
+		}
+	}
+
+	return i.Downcast(to)
+}
@@ -22,6 +22,11 @@ const (
 	Class
 	Function
 	Method
+	Error
+	Host
+	Weak
+	Channel
+	Coroutine
 	// Closure?
 )
 
@@ -65,6 +70,16 @@ func (i ID) String() string {
 		return "function"
 	case Method:
 		return "method"
+	case Error:
+		return "error"
+	case Host:
+		return "host"
+	case Weak:
+		return "weak"
+	case Channel:
+		return "channel"
+	case Coroutine:
+		return "coroutine"
 	}
 
 	return "unknown"
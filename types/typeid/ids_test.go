@@ -37,6 +37,11 @@ func TestID(t *testing.T) {
 			{"Class", typeid.Class, "class"},
 			{"Function", typeid.Function, "function"},
 			{"Method", typeid.Method, "method"},
+			{"Error", typeid.Error, "error"},
+			{"Host", typeid.Host, "host"},
+			{"Weak", typeid.Weak, "weak"},
+			{"Channel", typeid.Channel, "channel"},
+			{"Coroutine", typeid.Coroutine, "coroutine"},
 			{"Unknown", typeid.ID(255), "unknown"},
 		} {
 			t.Run(test.name, func(t *testing.T) {
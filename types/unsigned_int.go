@@ -102,3 +102,19 @@ func (u Uint64) Downcast(to typeid.ID) (Value, error) {
 		return nil, CastError{From: typeid.Uint64, To: to}
 	}
 }
+
+func (u Uint64) DowncastChecked(to typeid.ID) (Value, error) {
+	if _, hi, ok := integerRange(to); ok && uint64(u) > hi {
+		return nil, OverflowError{From: typeid.Uint64, To: to}
+	}
+
+	return u.Downcast(to)
+}
+
+func (u Uint64) DowncastSaturating(to typeid.ID) (Value, error) {
+	if _, hi, ok := integerRange(to); ok && uint64(u) > hi {
+		return Uint64(hi).Downcast(to)
+	}
+
+	return u.Downcast(to)
+}
@@ -15,8 +15,23 @@ type Value interface {
 }
 
 // StackValue is a Value which may be pushed onto the stack of a thread.
+//
+// A StackValue may be converted to another type in one of three modes:
+//
+//   - Downcast wraps integers which are out of range of the target type and
+//     converts floats as Go does, which is implementation defined for values
+//     outside of the target range.
+//   - DowncastChecked returns an OverflowError if the value is out of range of
+//     the target type, or is NaN when converting to an integer.
+//   - DowncastSaturating clamps the value to the range of the target type and
+//     converts NaN to 0 when converting to an integer.
+//
+// In every mode floats are truncated towards zero when converted to an
+// integer, and a CastError is returned if the types are not convertible.
 type StackValue interface {
 	Value
 
 	Downcast(to typeid.ID) (Value, error)
+	DowncastChecked(to typeid.ID) (Value, error)
+	DowncastSaturating(to typeid.ID) (Value, error)
 }
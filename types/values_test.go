@@ -31,6 +31,8 @@ func TestValue(t *testing.T) {
 		{"Int64", types.Int64(10), typeid.Int64, 8, typeid.Int64},
 		{"Float32", types.Float32(1.0), typeid.Float32, 4, typeid.Float64},
 		{"Float64", types.Float64(1.0), typeid.Float64, 8, typeid.Float64},
+		{"Error", types.Error{}, typeid.Error, 8, typeid.Error},
+		{"Host", types.Host{Tag: 1, Value: nil}, typeid.Host, 24, typeid.Host},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
@@ -185,6 +187,16 @@ func TestDowncast(t *testing.T) {
 		{"Float64/Class", f64(1.2), typeid.Class, nil, castErr(Float64, typeid.Class)},
 		{"Float64/Function", f64(1.2), typeid.Function, nil, castErr(Float64, typeid.Function)},
 		{"Float64/Method", f64(1.2), typeid.Method, nil, castErr(Float64, typeid.Method)},
+		{"Float64/Error", f64(1.2), typeid.Error, nil, castErr(Float64, typeid.Error)},
+		// Error: Error (self)
+		{"Error/Error", types.Error{}, typeid.Error, types.Error{}, nilErr},
+		// Error: Other (errors)
+		{"Error/Int64", types.Error{}, Int64, nil, castErr(typeid.Error, Int64)},
+		{"Error/Float64", types.Error{}, Float64, nil, castErr(typeid.Error, Float64)},
+		// Host: Host (self)
+		{"Host/Host", types.Host{Tag: 1, Value: "x"}, typeid.Host, types.Host{Tag: 1, Value: "x"}, nilErr},
+		// Host: Other (errors)
+		{"Host/Uint64", types.Host{Tag: 1, Value: "x"}, Uint64, nil, castErr(typeid.Host, Uint64)},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
@@ -201,6 +213,112 @@ func TestDowncast(t *testing.T) {
 	}
 }
 
+func TestDowncastChecked(t *testing.T) {
+	t.Parallel()
+
+	nilErr := testerr.Nil()
+	overflow := func(from, to typeid.ID) testerr.ExpectedError {
+		return testerr.Is(types.OverflowError{From: from, To: to})
+	}
+
+	for _, test := range []struct {
+		name     string
+		value    types.StackValue
+		to       typeid.ID
+		expected types.Value
+		err      testerr.ExpectedError
+	}{
+		{"Uint64/Uint8/Normal", u64(255), typeid.Uint8, u8(255), nilErr},
+		{"Uint64/Uint8/Overflow", u64(257), typeid.Uint8, nil, overflow(typeid.Uint64, typeid.Uint8)},
+		{"Uint64/Int8/Overflow", u64(128), typeid.Int8, nil, overflow(typeid.Uint64, typeid.Int8)},
+		{"Uint64/Int64/Overflow", u64(1 << 63), typeid.Int64, nil, overflow(typeid.Uint64, typeid.Int64)},
+		{"Uint64/Float32", u64(math.MaxUint64), typeid.Float32, f32(math.MaxUint64), nilErr},
+		{"Uint64/String", u64(0), typeid.String, nil, testerr.Is(types.CastError{From: typeid.Uint64, To: typeid.String})},
+		{"Int64/Uint8/Underflow", i64(-2), typeid.Uint8, nil, overflow(typeid.Int64, typeid.Uint8)},
+		{"Int64/Uint64/Underflow", i64(-1), typeid.Uint64, nil, overflow(typeid.Int64, typeid.Uint64)},
+		{"Int64/Int16/Normal", i64(-32768), typeid.Int16, i16(-32768), nilErr},
+		{"Int64/Int16/Underflow", i64(-32769), typeid.Int16, nil, overflow(typeid.Int64, typeid.Int16)},
+		{"Int64/Int32/Overflow", i64(math.MaxInt32 + 1), typeid.Int32, nil, overflow(typeid.Int64, typeid.Int32)},
+		{"Int64/Uint64/Normal", i64(math.MaxInt64), typeid.Uint64, u64(math.MaxInt64), nilErr},
+		{"Float64/Uint8/Fractional", f64(255.9), typeid.Uint8, u8(255), nilErr},
+		{"Float64/Uint8/Overflow", f64(256), typeid.Uint8, nil, overflow(typeid.Float64, typeid.Uint8)},
+		{"Float64/Uint8/Negative", f64(-1), typeid.Uint8, nil, overflow(typeid.Float64, typeid.Uint8)},
+		{"Float64/Uint8/NegativeFraction", f64(-0.5), typeid.Uint8, u8(0), nilErr},
+		{"Float64/Uint64/Overflow", f64(18446744073709551615000.5), typeid.Uint64, nil, overflow(typeid.Float64, typeid.Uint64)},
+		{"Float64/Uint64/Max", f64(1 << 63), typeid.Uint64, u64(1 << 63), nilErr},
+		{"Float64/Int64/Overflow", f64(1 << 63), typeid.Int64, nil, overflow(typeid.Float64, typeid.Int64)},
+		{"Float64/Int64/Min", f64(-1 << 63), typeid.Int64, i64(math.MinInt64), nilErr},
+		{"Float64/Int32/NaN", f64(math.NaN()), typeid.Int32, nil, overflow(typeid.Float64, typeid.Int32)},
+		{"Float64/Int32/Inf", f64(math.Inf(-1)), typeid.Int32, nil, overflow(typeid.Float64, typeid.Int32)},
+		{"Float64/Float32/Normal", f64(1.5), typeid.Float32, f32(1.5), nilErr},
+		{"Float64/Float32/Overflow", f64(1e39), typeid.Float32, nil, overflow(typeid.Float64, typeid.Float32)},
+		{"Float64/Float32/Inf", f64(math.Inf(1)), typeid.Float32, f32(float32(math.Inf(1))), nilErr},
+		{"Float64/Float64", f64(math.MaxFloat64), typeid.Float64, f64(math.MaxFloat64), nilErr},
+		{"Error/Error", types.Error{}, typeid.Error, types.Error{}, nilErr},
+		{"Host/Host", types.Host{Tag: 1, Value: nil}, typeid.Host, types.Host{Tag: 1, Value: nil}, nilErr},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := test.value.DowncastChecked(test.to)
+			test.err.Require(t, err)
+			require.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestDowncastSaturating(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		value    types.StackValue
+		to       typeid.ID
+		expected types.Value
+	}{
+		{"Uint64/Uint8/Normal", u64(200), typeid.Uint8, u8(200)},
+		{"Uint64/Uint8/Overflow", u64(257), typeid.Uint8, u8(math.MaxUint8)},
+		{"Uint64/Int16/Overflow", u64(40000), typeid.Int16, i16(math.MaxInt16)},
+		{"Uint64/Int64/Overflow", u64(math.MaxUint64), typeid.Int64, i64(math.MaxInt64)},
+		{"Uint64/Float64", u64(10), typeid.Float64, f64(10)},
+		{"Int64/Uint8/Underflow", i64(-2), typeid.Uint8, u8(0)},
+		{"Int64/Uint8/Overflow", i64(300), typeid.Uint8, u8(math.MaxUint8)},
+		{"Int64/Uint64/Underflow", i64(math.MinInt64), typeid.Uint64, u64(0)},
+		{"Int64/Int8/Underflow", i64(-300), typeid.Int8, i8(math.MinInt8)},
+		{"Int64/Int32/Normal", i64(-300), typeid.Int32, i32(-300)},
+		{"Float64/Uint8/Negative", f64(-1.0), typeid.Uint8, u8(0)},
+		{"Float64/Uint8/Overflow", f64(300), typeid.Uint8, u8(math.MaxUint8)},
+		{"Float64/Uint8/NaN", f64(math.NaN()), typeid.Uint8, u8(0)},
+		{"Float64/Uint32/Fractional", f64(18.82), typeid.Uint32, u32(18)},
+		{"Float64/Uint64/Overflow", f64(18446744073709551615000.5), typeid.Uint64, u64(math.MaxUint64)},
+		{"Float64/Uint64/Inf", f64(math.Inf(1)), typeid.Uint64, u64(math.MaxUint64)},
+		{"Float64/Int64/Overflow", f64(1 << 63), typeid.Int64, i64(math.MaxInt64)},
+		{"Float64/Int64/Underflow", f64(-1e300), typeid.Int64, i64(math.MinInt64)},
+		{"Float64/Int64/NaN", f64(math.NaN()), typeid.Int64, i64(0)},
+		{"Float64/Int16/NegativeInf", f64(math.Inf(-1)), typeid.Int16, i16(math.MinInt16)},
+		{"Float64/Float32/Overflow", f64(1e39), typeid.Float32, f32(math.MaxFloat32)},
+		{"Float64/Float32/Underflow", f64(-1e39), typeid.Float32, f32(-math.MaxFloat32)},
+		{"Float64/Float32/Inf", f64(math.Inf(-1)), typeid.Float32, f32(float32(math.Inf(-1)))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := test.value.DowncastSaturating(test.to)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, actual)
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		for _, v := range []types.StackValue{u64(0), i64(0), f64(0), types.Error{}, types.Host{}} {
+			_, err := v.DowncastSaturating(typeid.String)
+			require.ErrorIs(t, err, types.CastError{From: v.ID(), To: typeid.String})
+		}
+	})
+}
+
 // Helper functions
 // ================
 
@@ -0,0 +1,72 @@
+package verify
+
+import (
+	"strconv"
+
+	"github.com/tvarney/consterr"
+)
+
+const (
+	// ErrMalformed indicates that an instruction could not be decoded.
+	ErrMalformed consterr.Error = "malformed instruction"
+
+	// ErrInvalidTarget indicates that a jump, call or handler target is not
+	// the start of an instruction.
+	ErrInvalidTarget consterr.Error = "target is not an instruction"
+
+	// ErrStackUnderflow indicates that an instruction removes more values than
+	// its stack frame holds.
+	ErrStackUnderflow consterr.Error = "stack underflow"
+
+	// ErrStackHeight indicates that an instruction is reached with different
+	// stack heights along different paths.
+	ErrStackHeight consterr.Error = "inconsistent stack height"
+
+	// ErrType indicates that an instruction is given a value of a type it can
+	// not operate on.
+	ErrType consterr.Error = "invalid operand type"
+
+	// ErrReturnCount indicates that a function returns different numbers of
+	// values from different Return instructions.
+	ErrReturnCount consterr.Error = "inconsistent return count"
+
+	// ErrArgumentCount indicates that a function is called with different
+	// numbers of arguments.
+	ErrArgumentCount consterr.Error = "inconsistent argument count"
+
+	// ErrFrameUnderflow indicates that a Return instruction is reachable
+	// outside of any function.
+	ErrFrameUnderflow consterr.Error = "return outside of a function"
+
+	// ErrNoLocal indicates that a local variable instruction uses a slot which
+	// is not in its stack frame.
+	ErrNoLocal consterr.Error = "local is not in the frame"
+)
+
+// Error is an error which indicates that the instruction at Offset failed
+// verification.
+//
+// Err is one of the errors of this package, and Cause the error which caused
+// it, if any.
+type Error struct {
+	Offset int
+	Err    error
+	Cause  error
+}
+
+func (e Error) Error() string {
+	msg := "0x" + strconv.FormatInt(int64(e.Offset), 16) + ": " + e.Err.Error()
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+
+	return msg
+}
+
+func (e Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+
+	return []error{e.Err, e.Cause}
+}
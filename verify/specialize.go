@@ -0,0 +1,87 @@
+package verify
+
+import (
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+// Operands returns the stack type every operand of the instruction at the
+// given offset is proven to have.
+//
+// Only arithmetic opcodes, Compare and JumpIf are considered. If the operands
+// may have different types, or their types are not known, false is returned.
+func (a *Analysis) Operands(offset int) (typeid.ID, bool) {
+	s, ok := a.StateAt(offset)
+	if !ok {
+		return typeid.Void, false
+	}
+
+	var count int
+	switch a.Instructions[a.index[offset]].Op {
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod, opcode.Compare:
+		count = 2
+	case opcode.JumpIf:
+		count = 1
+	default:
+		return typeid.Void, false
+	}
+
+	operands, _ := s.Top(0)
+	for depth := range count {
+		t, ok := s.Top(depth)
+		if !ok || t != operands {
+			return typeid.Void, false
+		}
+	}
+
+	return operands, operands != Unknown && numeric(operands)
+}
+
+// Specialize records every instruction whose operands are proven to have a
+// single type in the given program, so that they are run by the typed fast
+// paths of the interpreter.
+//
+// The program must have been decoded from the bytecode the analysis was made
+// for, and must be run with the same exception handlers. Threads and
+// coroutines must only be started at offset 0 and at the entries the analysis
+// was made with, given arguments of the declared types, as the operands of
+// code reachable from other offsets are not proven.
+func (a *Analysis) Specialize(p *vm.Program) {
+	for _, ins := range a.Instructions {
+		if operands, ok := a.Operands(ins.Offset); ok {
+			p.Specialize(ins.Offset, operands)
+		}
+	}
+}
+
+// Predecode verifies the bytecode of the given thread against its exception
+// handlers, then predecodes it and specializes every instruction whose
+// operand types are proven.
+//
+// The offset the thread is at, with the values on its stack, is verified as
+// an entry along with the given entries, which declare where the program of
+// the thread is started by other threads and coroutines.
+//
+// If verification fails the thread is left as it was.
+func Predecode(t *vm.Thread, entries ...Entry) error {
+	if t.PC != 0 || len(t.Stack) > 0 {
+		args := make([]typeid.ID, len(t.Stack))
+		for i, s := range t.Stack {
+			args[i] = s.Type
+		}
+
+		entries = append(entries, Entry{Offset: t.PC, Args: args, Coroutine: false})
+	}
+
+	a, err := Verify(t.Data, t.Handlers, entries...)
+	if err != nil {
+		return err
+	}
+
+	t.Predecode()
+	a.Specialize(t.Program)
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"slices"
+
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Unknown is the type of stack values whose type can not be known statically.
+const Unknown typeid.ID = 0xFF
+
+// State is the statically known state of a stack frame before an instruction
+// runs.
+type State struct {
+	// Height is the number of values in the frame, or -1 if it can not be
+	// known statically.
+	Height int
+
+	// Types holds the type of each value in the frame from the bottom up, with
+	// Unknown for values whose type can not be known. Types is nil when the
+	// height is not known.
+	Types []typeid.ID
+}
+
+// newState returns a state holding the given number of values of unknown
+// type.
+func newState(height int) *State {
+	types := make([]typeid.ID, height)
+	for i := range types {
+		types[i] = Unknown
+	}
+
+	return &State{Height: height, Types: types}
+}
+
+// unknownState returns a state whose height is not known.
+func unknownState() *State {
+	return &State{Height: -1, Types: nil}
+}
+
+// Known returns if the height of the frame is known.
+func (s *State) Known() bool {
+	return s.Height >= 0
+}
+
+// Top returns the type of the value depth values from the top of the frame.
+//
+// If the height of the frame is not known Unknown is returned. If the frame
+// holds depth values or fewer, false is returned.
+func (s *State) Top(depth int) (typeid.ID, bool) {
+	if !s.Known() {
+		return Unknown, true
+	}
+
+	if depth < 0 || depth >= s.Height {
+		return Unknown, false
+	}
+
+	return s.Types[s.Height-1-depth], true
+}
+
+func (s *State) clone() *State {
+	return &State{Height: s.Height, Types: slices.Clone(s.Types)}
+}
+
+// pop removes count values from the frame and returns their types, with the
+// top value last. If the frame holds fewer than count values false is
+// returned.
+func (s *State) pop(count int) ([]typeid.ID, bool) {
+	if !s.Known() {
+		popped := make([]typeid.ID, count)
+		for i := range popped {
+			popped[i] = Unknown
+		}
+
+		return popped, true
+	}
+
+	if count > s.Height {
+		return nil, false
+	}
+
+	s.Height -= count
+	popped := slices.Clone(s.Types[s.Height:])
+	s.Types = s.Types[:s.Height]
+
+	return popped, true
+}
+
+// push adds values of the given types to the frame.
+func (s *State) push(types ...typeid.ID) {
+	if !s.Known() {
+		return
+	}
+
+	s.Types = append(s.Types, types...)
+	s.Height += len(types)
+}
+
+// merge merges the given state into s, returning if s changed.
+//
+// Values whose types differ become Unknown. If both heights are known and
+// differ, ErrStackHeight is returned.
+func (s *State) merge(other *State) (bool, error) {
+	switch {
+	case !s.Known():
+		return false, nil
+	case !other.Known():
+		*s = *unknownState()
+		return true, nil
+	case s.Height != other.Height:
+		return false, ErrStackHeight
+	}
+
+	changed := false
+	for i, t := range other.Types {
+		if s.Types[i] != t && s.Types[i] != Unknown {
+			s.Types[i] = Unknown
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
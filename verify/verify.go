@@ -0,0 +1,801 @@
+// Package verify checks bytecode before it is run, so that untrusted bytecode
+// can be rejected when it is loaded rather than faulting part way through.
+//
+// Verification decodes every instruction and checks that every jump, call and
+// handler target is the start of an instruction. It then follows every path
+// through each function, checking that the height of the stack frame is the
+// same along every path to an instruction, that no instruction removes more
+// values than the frame holds, and that no instruction is given a value of a
+// type it can not operate on.
+//
+// The functions of the bytecode are the code reachable from offset 0, from the
+// target of each Call and from each declared Entry. Heights are relative to the
+// frame of the function. A Pop which takes its count from the stack makes the
+// height unknown until the frame is cleared, and the checks which depend on it
+// are skipped.
+package verify
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+// The layout of the control bytes used by verification. See docs/opcodes.md.
+const (
+	popImmediateBit  = 0x80
+	popImmediateMask = 0x7F
+	popModeMask      = 0xC0
+	popModeClear     = 0x40
+	callArgsShift    = 4
+	returnCountMask  = 0x0F
+	castTypeMask     = 0x1F
+	selectCountMask  = 0x0F
+)
+
+// Analysis is the result of verifying bytecode.
+type Analysis struct {
+	// Instructions holds every instruction of the bytecode in order.
+	Instructions []vm.Instruction
+
+	// States holds the state of the stack frame before each instruction, or
+	// nil for instructions which are never reached.
+	States []*State
+
+	index []int
+}
+
+// StateAt returns the state of the stack frame before the instruction which
+// starts at the given offset.
+//
+// If no instruction starts at the offset, or it is never reached, false is
+// returned.
+func (a *Analysis) StateAt(offset int) (*State, bool) {
+	if offset < 0 || offset >= len(a.index) || a.index[offset] < 0 {
+		return nil, false
+	}
+
+	s := a.States[a.index[offset]]
+
+	return s, s != nil
+}
+
+// Entry declares an offset other than offset 0 at which Go code starts running
+// the bytecode, such as the entry of a thread started by vm.Machine.Spawn or of
+// a coroutine created by vm.Thread.NewCoroutine.
+type Entry struct {
+	// Offset is the offset the thread or coroutine starts running at.
+	Offset int
+
+	// Args holds the stack type of each value on the stack when the entry
+	// starts running, from the bottom up. Unknown is used for values whose
+	// type is not known. A coroutine starts with the single value it is first
+	// resumed with.
+	Args []typeid.ID
+
+	// Coroutine is set if the entry is that of a coroutine, which may Return
+	// to the thread resuming it. Threads can not Return from their entry.
+	Coroutine bool
+}
+
+// Verify verifies the given bytecode, along with the exception handler table
+// it is run with and the entries it is started at other than offset 0.
+//
+// If the bytecode fails verification an Error is returned for the first
+// problem found.
+func Verify(data []uint8, handlers []vm.Handler, entries ...Entry) (*Analysis, error) {
+	v := &verifier{
+		data:    data,
+		code:    nil,
+		index:   make([]int, len(data)),
+		args:    map[int]int{},
+		types:   map[int][]typeid.ID{},
+		threads: map[int]bool{},
+		returns: map[int]int{},
+		roots:   map[int][]root{},
+		states:  nil,
+	}
+
+	if err := v.decode(); err != nil {
+		return nil, err
+	}
+
+	if err := v.checkTargets(handlers, entries); err != nil {
+		return nil, err
+	}
+
+	if err := v.checkFunctions(handlers); err != nil {
+		return nil, err
+	}
+
+	if err := v.flow(); err != nil {
+		return nil, err
+	}
+
+	return &Analysis{Instructions: v.code, States: v.states, index: v.index}, nil
+}
+
+// root is an offset from which a function starts running with the given stack
+// height. The types of the values on the stack are given by types, or are not
+// known if types is nil.
+type root struct {
+	offset int
+	height int
+	types  []typeid.ID
+}
+
+type verifier struct {
+	data  []uint8
+	code  []vm.Instruction
+	index []int
+
+	// args holds the argument count of each function, by entry offset.
+	args map[int]int
+
+	// types holds the argument types of each declared Entry which is not
+	// called by the bytecode, by entry offset.
+	types map[int][]typeid.ID
+
+	// threads holds the entries threads are started at, which can not Return.
+	threads map[int]bool
+
+	// returns holds the return count of each function which returns, by entry
+	// offset.
+	returns map[int]int
+
+	// roots holds the roots of each function, by entry offset.
+	roots map[int][]root
+
+	states []*State
+}
+
+// decode decodes every instruction of the bytecode.
+func (v *verifier) decode() error {
+	for i := range v.index {
+		v.index[i] = -1
+	}
+
+	for offset := 0; offset < len(v.data); {
+		ins, err := vm.DecodeAt(v.data, offset)
+		if err != nil {
+			return Error{Offset: offset, Err: ErrMalformed, Cause: err}
+		}
+
+		v.index[offset] = len(v.code)
+		v.code = append(v.code, ins)
+		offset = ins.Next
+	}
+
+	v.states = make([]*State, len(v.code))
+
+	return nil
+}
+
+// at returns the index of the instruction which starts at the given offset.
+func (v *verifier) at(offset int) (int, bool) {
+	if offset < 0 || offset >= len(v.index) || v.index[offset] < 0 {
+		return 0, false
+	}
+
+	return v.index[offset], true
+}
+
+// checkTargets checks that every target and declared entry is the start of an
+// instruction, and that every function is always called with the same number
+// of arguments. The entry of a coroutine is a function called with the value
+// it is first resumed with.
+func (v *verifier) checkTargets(handlers []vm.Handler, entries []Entry) error {
+	for _, ins := range v.code {
+		switch ins.Op {
+		case opcode.Jump, opcode.JumpIf:
+			if _, ok := v.at(ins.Target); !ok && ins.Target != len(v.data) {
+				return Error{Offset: ins.Offset, Err: ErrInvalidTarget, Cause: nil}
+			}
+		case opcode.Call, opcode.CoCreate:
+			if _, ok := v.at(ins.Target); !ok {
+				return Error{Offset: ins.Offset, Err: ErrInvalidTarget, Cause: nil}
+			}
+
+			args := 1
+			if ins.Op == opcode.Call {
+				args = int(ins.Control >> callArgsShift)
+			}
+
+			if n, ok := v.args[ins.Target]; ok && n != args {
+				return Error{Offset: ins.Offset, Err: ErrArgumentCount, Cause: nil}
+			}
+
+			v.args[ins.Target] = args
+		}
+	}
+
+	called := maps.Clone(v.args)
+	for _, e := range entries {
+		if _, ok := v.at(e.Offset); !ok {
+			return Error{Offset: e.Offset, Err: ErrInvalidTarget, Cause: nil}
+		}
+
+		args := len(e.Args)
+		if e.Coroutine && args != 1 {
+			return Error{Offset: e.Offset, Err: ErrArgumentCount, Cause: nil}
+		}
+
+		if n, ok := v.args[e.Offset]; ok && n != args {
+			return Error{Offset: e.Offset, Err: ErrArgumentCount, Cause: nil}
+		}
+
+		v.args[e.Offset] = args
+		if !e.Coroutine {
+			v.threads[e.Offset] = true
+		}
+
+		// The arguments of a function called by the bytecode have no known
+		// types. An argument declared with different types by entries at the
+		// same offset has none either.
+		if _, ok := called[e.Offset]; ok {
+			continue
+		}
+
+		types, ok := v.types[e.Offset]
+		if !ok {
+			v.types[e.Offset] = slices.Clone(e.Args)
+			continue
+		}
+
+		for i, t := range e.Args {
+			if types[i] != t {
+				types[i] = Unknown
+			}
+		}
+	}
+
+	for _, h := range handlers {
+		if _, ok := v.at(h.Target); !ok {
+			return Error{Offset: h.Target, Err: ErrInvalidTarget, Cause: nil}
+		}
+	}
+
+	return nil
+}
+
+// checkFunctions finds the roots of each function and checks that every
+// Return of a function returns the same number of values.
+func (v *verifier) checkFunctions(handlers []vm.Handler) error {
+	entries := []int{0}
+	for entry := range v.args {
+		if entry != 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	slices.Sort(entries)
+
+	for _, entry := range entries {
+		v.roots[entry] = []root{{offset: entry, height: v.args[entry], types: v.types[entry]}}
+	}
+
+	for _, h := range handlers {
+		owner := 0
+		if start, ok := v.at(h.Start); ok {
+			for _, entry := range entries {
+				if v.reach(v.roots[entry])[start] {
+					owner = entry
+					break
+				}
+			}
+		}
+
+		v.roots[owner] = append(v.roots[owner], root{offset: h.Target, height: h.Depth + 1, types: nil})
+	}
+
+	for _, entry := range entries {
+		_, called := v.args[entry]
+		called = called && !v.threads[entry]
+		for i, reached := range v.reach(v.roots[entry]) {
+			if !reached || v.code[i].Op != opcode.Return {
+				continue
+			}
+
+			if !called {
+				return Error{Offset: v.code[i].Offset, Err: ErrFrameUnderflow, Cause: nil}
+			}
+
+			count := int(v.code[i].Control & returnCountMask)
+			if n, ok := v.returns[entry]; ok && n != count {
+				return Error{Offset: v.code[i].Offset, Err: ErrReturnCount, Cause: nil}
+			}
+
+			v.returns[entry] = count
+		}
+	}
+
+	return nil
+}
+
+// reach returns which instructions are reachable from the given roots without
+// following a Call.
+func (v *verifier) reach(roots []root) []bool {
+	reached := make([]bool, len(v.code))
+
+	pending := []int{}
+	for _, r := range roots {
+		if i, ok := v.at(r.offset); ok {
+			pending = append(pending, i)
+		}
+	}
+
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reached[i] {
+			continue
+		}
+
+		reached[i] = true
+		for _, offset := range v.successors(&v.code[i]) {
+			if next, ok := v.at(offset); ok {
+				pending = append(pending, next)
+			}
+		}
+	}
+
+	return reached
+}
+
+// successors returns the offsets execution may continue from after the given
+// instruction within its function.
+func (v *verifier) successors(ins *vm.Instruction) []int {
+	info, _ := opcode.Lookup(ins.Op)
+
+	var next []int
+	if !info.Terminal {
+		next = append(next, ins.Next)
+	}
+
+	if info.Branch {
+		next = append(next, ins.Target)
+	}
+
+	return next
+}
+
+// flow follows every path through every function, recording the state of the
+// frame before each instruction.
+func (v *verifier) flow() error {
+	entries := make([]int, 0, len(v.roots))
+	for entry := range v.roots {
+		entries = append(entries, entry)
+	}
+
+	slices.Sort(entries)
+
+	pending := []int{}
+	for _, entry := range entries {
+		for _, r := range v.roots[entry] {
+			i, ok := v.at(r.offset)
+			if !ok {
+				continue
+			}
+
+			state := newState(r.height)
+			copy(state.Types, r.types)
+
+			if _, err := v.enter(i, state); err != nil {
+				return err
+			}
+
+			pending = append(pending, i)
+		}
+	}
+
+	for len(pending) > 0 {
+		i := pending[0]
+		pending = pending[1:]
+
+		ins := &v.code[i]
+		out, err := v.step(ins, v.states[i])
+		if err != nil {
+			return err
+		}
+
+		for _, offset := range v.successors(ins) {
+			next, ok := v.at(offset)
+			if !ok {
+				continue
+			}
+
+			state := out
+			switch {
+			case ins.Op == opcode.Jump:
+				state = v.states[i]
+			case ins.Op == opcode.Call && offset == ins.Next:
+				if _, ok := v.returns[ins.Target]; !ok {
+					continue
+				}
+			}
+
+			changed, err := v.enter(next, state)
+			if err != nil {
+				return err
+			}
+
+			if changed {
+				pending = append(pending, next)
+			}
+		}
+	}
+
+	return nil
+}
+
+// enter merges the given state into the state before the instruction at the
+// given index, returning if it changed.
+func (v *verifier) enter(i int, state *State) (bool, error) {
+	if v.states[i] == nil {
+		v.states[i] = state.clone()
+		return true, nil
+	}
+
+	changed, err := v.states[i].merge(state)
+	if err != nil {
+		return false, Error{Offset: v.code[i].Offset, Err: err, Cause: nil}
+	}
+
+	return changed, nil
+}
+
+// step returns the state of the frame after the given instruction runs from
+// the given state.
+func (v *verifier) step(ins *vm.Instruction, in *State) (*State, error) {
+	s := in.clone()
+
+	var err error
+	switch ins.Op {
+	case opcode.NoOp, opcode.Jump, opcode.Yield:
+	case opcode.Push:
+		s.push(ins.Value.Type)
+	case opcode.Dupe:
+		top, ok := s.Top(int(ins.Control))
+		if !ok {
+			err = ErrStackUnderflow
+		}
+
+		s.push(top)
+	case opcode.Pop:
+		s, err = v.stepPop(ins, s)
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod:
+		err = v.stepArith(ins, s)
+	case opcode.Compare:
+		err = v.stepArith(ins, s)
+	case opcode.JumpIf:
+		err = v.stepOperands(s, 1)
+	case opcode.Call:
+		if _, ok := s.pop(int(ins.Control >> callArgsShift)); !ok {
+			err = ErrStackUnderflow
+		}
+
+		s.push(newState(v.returns[ins.Target]).Types...)
+	case opcode.Return:
+		if _, ok := s.pop(int(ins.Control & returnCountMask)); !ok {
+			err = ErrStackUnderflow
+		}
+	case opcode.Throw:
+		if _, ok := s.pop(1); !ok {
+			err = ErrStackUnderflow
+		}
+	case opcode.Cast:
+		err = stepCast(ins, s)
+	case opcode.Join:
+		err = stepInteger(s)
+	case opcode.Chan, opcode.Send, opcode.Recv, opcode.TryRecv, opcode.Close, opcode.Select:
+		err = stepChannel(ins, s)
+	case opcode.CoCreate, opcode.CoResume, opcode.CoYield:
+		err = stepCoroutine(ins, s)
+	case opcode.LoadGlobal:
+		s.push(Unknown)
+	case opcode.StoreGlobal:
+		if _, ok := s.pop(1); !ok {
+			err = ErrStackUnderflow
+		}
+	case opcode.LoadLocal, opcode.StoreLocal:
+		err = stepLocal(ins, s)
+	case opcode.CallHost:
+		if _, ok := s.pop(int(ins.Control >> callArgsShift)); !ok {
+			err = ErrStackUnderflow
+		}
+
+		s.push(Unknown)
+	case opcode.Random:
+		s.push(typeid.Uint64)
+	case opcode.Time:
+		s.push(typeid.Int64)
+	case opcode.Weak, opcode.Deref:
+		err = stepWeak(ins, s)
+	}
+
+	if err != nil {
+		return nil, Error{Offset: ins.Offset, Err: err, Cause: nil}
+	}
+
+	return s, nil
+}
+
+// stepPop returns the state of the frame after the given Pop.
+func (v *verifier) stepPop(ins *vm.Instruction, s *State) (*State, error) {
+	switch {
+	case ins.Control&popImmediateBit != 0:
+		if _, ok := s.pop(int(ins.Control&popImmediateMask) + 1); !ok {
+			return nil, ErrStackUnderflow
+		}
+
+		return s, nil
+	case ins.Control&popModeMask == popModeClear:
+		return newState(0), nil
+	}
+
+	count, ok := s.pop(1)
+	if !ok {
+		return nil, ErrStackUnderflow
+	}
+
+	if count[0] != Unknown && count[0] != typeid.Uint64 && count[0] != typeid.Int64 {
+		return nil, ErrType
+	}
+
+	return unknownState(), nil
+}
+
+// stepOperands removes the given number of numeric operands from the frame.
+func (v *verifier) stepOperands(s *State, count int) error {
+	popped, ok := s.pop(count)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	for _, t := range popped {
+		if !numeric(t) {
+			return ErrType
+		}
+	}
+
+	return nil
+}
+
+// stepArith updates the frame for an arithmetic opcode or a Compare.
+func (v *verifier) stepArith(ins *vm.Instruction, s *State) error {
+	left, _ := s.Top(0)
+	right, _ := s.Top(1)
+	if err := v.stepOperands(s, 2); err != nil {
+		return err
+	}
+
+	result := promote(left, right)
+
+	switch ins.Op {
+	case opcode.Compare:
+		s.push(typeid.Uint64)
+	case opcode.DivMod:
+		s.push(result, result)
+	default:
+		s.push(result)
+	}
+
+	return nil
+}
+
+// stepCast updates the frame for a Cast.
+func stepCast(ins *vm.Instruction, s *State) error {
+	from, ok := s.pop(1)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	to, ok := castResult(from[0], typeid.ID(ins.Control&castTypeMask))
+	if !ok {
+		return ErrType
+	}
+
+	s.push(to)
+
+	return nil
+}
+
+// stepInteger removes an integer operand from the frame, such as the ID of the
+// thread a Join waits for or the capacity of a new channel.
+func stepInteger(s *State) error {
+	id, ok := s.pop(1)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	if id[0] != Unknown && id[0] != typeid.Uint64 && id[0] != typeid.Int64 {
+		return ErrType
+	}
+
+	return nil
+}
+
+// stepChannel updates the frame for a channel opcode.
+func stepChannel(ins *vm.Instruction, s *State) error {
+	count := 1
+	switch ins.Op {
+	case opcode.Chan:
+		if err := stepInteger(s); err != nil {
+			return err
+		}
+
+		s.push(typeid.Channel)
+
+		return nil
+	case opcode.Send:
+		count = 2
+	case opcode.Select:
+		count = int(ins.Control & selectCountMask)
+	}
+
+	popped, ok := s.pop(count)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	if ins.Op == opcode.Send {
+		popped = popped[1:]
+	}
+
+	for _, t := range popped {
+		if t != Unknown && t != typeid.Channel {
+			return ErrType
+		}
+	}
+
+	switch ins.Op {
+	case opcode.Recv, opcode.TryRecv:
+		s.push(Unknown, typeid.Uint64)
+	case opcode.Select:
+		s.push(Unknown, typeid.Uint64, typeid.Uint64)
+	}
+
+	return nil
+}
+
+// stepCoroutine updates the frame for a coroutine opcode.
+func stepCoroutine(ins *vm.Instruction, s *State) error {
+	switch ins.Op {
+	case opcode.CoCreate:
+		s.push(typeid.Coroutine)
+	case opcode.CoResume:
+		popped, ok := s.pop(2)
+		if !ok {
+			return ErrStackUnderflow
+		}
+
+		if popped[1] != Unknown && popped[1] != typeid.Coroutine {
+			return ErrType
+		}
+
+		s.push(Unknown, typeid.Uint64)
+	case opcode.CoYield:
+		if _, ok := s.pop(1); !ok {
+			return ErrStackUnderflow
+		}
+
+		s.push(Unknown)
+	}
+
+	return nil
+}
+
+// stepWeak updates the frame for Weak, which takes a reference, and Deref,
+// which takes a weak reference and gives a reference or void.
+func stepWeak(ins *vm.Instruction, s *State) error {
+	popped, ok := s.pop(1)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	if ins.Op == opcode.Weak {
+		if popped[0] != Unknown && (numeric(popped[0]) || popped[0] == typeid.Void) {
+			return ErrType
+		}
+
+		s.push(typeid.Weak)
+
+		return nil
+	}
+
+	if popped[0] != Unknown && popped[0] != typeid.Weak {
+		return ErrType
+	}
+
+	s.push(Unknown)
+
+	return nil
+}
+
+// stepLocal updates the frame for a local variable opcode. The slot of the
+// local is only checked when the height of the frame is known.
+func stepLocal(ins *vm.Instruction, s *State) error {
+	if ins.Op == opcode.LoadLocal {
+		if s.Known() && ins.Target >= s.Height {
+			return ErrNoLocal
+		}
+
+		v := Unknown
+		if s.Known() {
+			v = s.Types[ins.Target]
+		}
+
+		s.push(v)
+
+		return nil
+	}
+
+	popped, ok := s.pop(1)
+	if !ok {
+		return ErrStackUnderflow
+	}
+
+	if s.Known() {
+		if ins.Target >= s.Height {
+			return ErrNoLocal
+		}
+
+		s.Types[ins.Target] = popped[0]
+	}
+
+	return nil
+}
+
+// numeric returns if values of the given type may be used as numeric
+// operands.
+func numeric(t typeid.ID) bool {
+	return t == Unknown || t == typeid.Uint64 || t == typeid.Int64 || t == typeid.Float64
+}
+
+// promote returns the type numeric operands of the given types are converted
+// to before an operation.
+func promote(left, right typeid.ID) typeid.ID {
+	switch {
+	case left == Unknown || right == Unknown:
+		return Unknown
+	case left == typeid.Float64 || right == typeid.Float64:
+		return typeid.Float64
+	case left == typeid.Int64 || right == typeid.Int64:
+		return typeid.Int64
+	default:
+		return typeid.Uint64
+	}
+}
+
+// castResult returns the type of the value a Cast of a value of the given type
+// to the given target produces, or false if the cast always fails.
+func castResult(from, to typeid.ID) (typeid.ID, bool) {
+	var result typeid.ID
+	switch to {
+	case typeid.Uint8, typeid.Uint16, typeid.Uint32, typeid.Uint64:
+		result = typeid.Uint64
+	case typeid.Int8, typeid.Int16, typeid.Int32, typeid.Int64:
+		result = typeid.Int64
+	case typeid.Float32, typeid.Float64:
+		result = typeid.Float64
+	case typeid.Error:
+		result = typeid.Error
+	default:
+		return 0, false
+	}
+
+	switch {
+	case from == Unknown:
+		return result, true
+	case from == typeid.Error:
+		return result, result == typeid.Error
+	case numeric(from):
+		return result, result != typeid.Error
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,333 @@
+package verify_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/verify"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opNoOp    = uint8(opcode.NoOp)
+	opPush    = uint8(opcode.Push)
+	opDupe    = uint8(opcode.Dupe)
+	opPop     = uint8(opcode.Pop)
+	opAdd     = uint8(opcode.Add)
+	opJump    = uint8(opcode.Jump)
+	opJumpIf  = uint8(opcode.JumpIf)
+	opCall    = uint8(opcode.Call)
+	opReturn  = uint8(opcode.Return)
+	opThrow   = uint8(opcode.Throw)
+	opCast    = uint8(opcode.Cast)
+	opCompare = uint8(opcode.Compare)
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		handlers []vm.Handler
+		errval   testerr.ExpectedError
+		offset   int
+	}{
+		{"Empty", []uint8{}, nil, testerr.Nil(), 0},
+		{"Countdown", bench.Countdown(10, 0x00), nil, testerr.Nil(), 0},
+		{"Fib", bench.Fib(10), nil, testerr.Nil(), 0},
+		{"JumpToEnd", []uint8{opJump, 0x01, 0x03}, nil, testerr.Nil(), 0},
+		{"Malformed", []uint8{opPush, 0x81, opPush, 0x1F}, nil, testerr.Is(verify.ErrMalformed), 2},
+		{"MalformedCause", []uint8{opPush, 0x81, 0xFF}, nil, testerr.Is(vm.ErrOperationUndefined), 2},
+		{"JumpIntoImmediate", []uint8{opJump, 0x01, 0x04, opPush, 0x01, 0x81}, nil,
+			testerr.Is(verify.ErrInvalidTarget), 0},
+		{"CallToEnd", []uint8{opCall, 0x01, 0x03}, nil, testerr.Is(verify.ErrInvalidTarget), 0},
+		{"HandlerTarget", []uint8{opPush, 0x81, opThrow}, []vm.Handler{{Start: 0, End: 3, Target: 1, Depth: 0}},
+			testerr.Is(verify.ErrInvalidTarget), 1},
+		{"Underflow", []uint8{opPush, 0x81, opAdd, 0x00}, nil, testerr.Is(verify.ErrStackUnderflow), 2},
+		{"DupeUnderflow", []uint8{opPush, 0x81, opDupe, 0x01}, nil, testerr.Is(verify.ErrStackUnderflow), 2},
+		{"PopUnderflow", []uint8{opPush, 0x81, opPop, 0x81}, nil, testerr.Is(verify.ErrStackUnderflow), 2},
+		{"Height", []uint8{opPush, 0x81, opPush, 0x81, opJumpIf, 0x01, 0x09, opPush, 0x81, opNoOp}, nil,
+			testerr.Is(verify.ErrStackHeight), 9},
+		{"Loop", []uint8{opPush, 0x81, opPush, 0x81, opPush, 0x81, opJumpIf, 0x01, 0x02}, nil,
+			testerr.Is(verify.ErrStackHeight), 2},
+		{"CastType", []uint8{opPush, 0x81, opCast, byte(typeid.String)}, nil, testerr.Is(verify.ErrType), 2},
+		{"CastToError", []uint8{opPush, 0x81, opCast, byte(typeid.Error)}, nil, testerr.Is(verify.ErrType), 2},
+		{"DynamicCount", []uint8{
+			opPush, 0x81, opPush, 0x81, opCast, byte(typeid.Float64), opPop, 0x00,
+		}, nil, testerr.Is(verify.ErrType), 6},
+		{"Join", []uint8{byte(opcode.Yield), opPush, 0x81, byte(opcode.Join)}, nil, testerr.Nil(), 0},
+		{"JoinType", []uint8{opPush, 0x24, 0, 0, 0, 0, byte(opcode.Join)}, nil, testerr.Is(verify.ErrType), 6},
+		{"JoinUnderflow", []uint8{byte(opcode.Join)}, nil, testerr.Is(verify.ErrStackUnderflow), 0},
+		{"Channel", []uint8{
+			opPush, 0x80, byte(opcode.Chan), 0x04, opDupe, 0x00, byte(opcode.Recv), opPop, 0x81,
+			opDupe, 0x00, opDupe, 0x00, byte(opcode.Select), 0x02, opPop, 0x82, byte(opcode.Close),
+		}, nil, testerr.Nil(), 0},
+		{"SendType", []uint8{opPush, 0x81, opPush, 0x81, byte(opcode.Send)}, nil, testerr.Is(verify.ErrType), 4},
+		{"ChanType", []uint8{opPush, 0x24, 0, 0, 0, 0, byte(opcode.Chan), 0x04}, nil, testerr.Is(verify.ErrType), 6},
+		{"Coroutine", []uint8{
+			byte(opcode.CoCreate), 0x01, 0x0D, opPush, 0x81, opDupe, 0x01, byte(opcode.CoResume), opPop, 0x82,
+			opJump, 0x01, 0x10, byte(opcode.CoYield), opReturn, 0x01,
+		}, nil, testerr.Nil(), 0},
+		{"ResumeType", []uint8{opPush, 0x81, opPush, 0x81, byte(opcode.CoResume)}, nil,
+			testerr.Is(verify.ErrType), 4},
+		{"Locals", []uint8{
+			opPush, 0x81, byte(opcode.LoadLocal), 0x01, 0x00, opPush, 0x11, 0xFF, byte(opcode.StoreLocal), 0x01, 0x01,
+			byte(opcode.LoadGlobal), 0x02, 0x01, 0x00, byte(opcode.StoreGlobal), 0x01, 0x03,
+		}, nil, testerr.Nil(), 0},
+		{"LocalType", []uint8{
+			opPush, 0x81, opPush, 0x80, byte(opcode.Chan), 0x04, byte(opcode.StoreLocal), 0x01, 0x00,
+			byte(opcode.LoadLocal), 0x01, 0x00, opAdd, 0x00,
+		}, nil, testerr.Is(verify.ErrType), 0x0C},
+		{"NoLocal", []uint8{opPush, 0x81, byte(opcode.StoreLocal), 0x01, 0x00}, nil, testerr.Is(verify.ErrNoLocal), 2},
+		{"Host", []uint8{
+			byte(opcode.Random), byte(opcode.Time), byte(opcode.CallHost), 0x21, 0x00, opPush, 0x81, opAdd, 0x00,
+		}, nil, testerr.Nil(), 0},
+		{"HostUnderflow", []uint8{opPush, 0x81, byte(opcode.CallHost), 0x21, 0x00}, nil,
+			testerr.Is(verify.ErrStackUnderflow), 2},
+		{"Weak", []uint8{
+			opPush, 0x80, byte(opcode.Chan), 0x04, byte(opcode.Weak), byte(opcode.Deref), byte(opcode.Weak),
+		}, nil, testerr.Nil(), 0},
+		{"WeakType", []uint8{opPush, 0x81, byte(opcode.Weak)}, nil, testerr.Is(verify.ErrType), 2},
+		{"DerefType", []uint8{opPush, 0x80, byte(opcode.Chan), 0x04, byte(opcode.Deref)}, nil,
+			testerr.Is(verify.ErrType), 4},
+		{"ReturnOutside", []uint8{opPush, 0x81, opReturn, 0x01}, nil, testerr.Is(verify.ErrFrameUnderflow), 2},
+		{"ReturnCount", []uint8{
+			opPush, 0x81, opCall, 0x11, 0x08, opJump, 0x01, 0x11,
+			opJumpIf, 0x01, 0x0F, opPush, 0x81, opReturn, 0x01, opReturn, 0x00,
+		}, nil, testerr.Is(verify.ErrReturnCount), 0x0F},
+		{"ArgumentCount", []uint8{
+			opCall, 0x01, 0x0B, opPush, 0x81, opCall, 0x11, 0x0B, opJump, 0x01, 0x0D, opReturn, 0x00,
+		}, nil, testerr.Is(verify.ErrArgumentCount), 5},
+		{"DynamicPop", []uint8{
+			opPush, 0x81, opPush, 0x80, opPop, 0x00, opPop, 0x40, opPush, 0x81, opAdd, 0x00,
+		}, nil, testerr.Is(verify.ErrStackUnderflow), 0x0A},
+		{"Handled", []uint8{opPush, 0x81, opThrow, opPop, 0x80}, []vm.Handler{{Start: 0, End: 3, Target: 3, Depth: 0}},
+			testerr.Nil(), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := verify.Verify(test.data, test.handlers)
+			test.errval.Require(t, err)
+
+			if err != nil {
+				var verr verify.Error
+				require.ErrorAs(t, err, &verr)
+				require.Equal(t, test.offset, verr.Offset)
+			}
+		})
+	}
+}
+
+func TestVerifyEntries(t *testing.T) {
+	t.Parallel()
+
+	u64s := []typeid.ID{typeid.Uint64, typeid.Uint64}
+
+	// 0x00: Push 1, Call 0x08, Jump 0x0A, Return 1
+	called := []uint8{opPush, 0x81, opCall, 0x11, 0x08, opJump, 0x01, 0x0A, opReturn, 0x01}
+
+	for _, test := range []struct {
+		name    string
+		data    []uint8
+		entries []verify.Entry
+		errval  testerr.ExpectedError
+		offset  int
+	}{
+		{"Args", []uint8{opAdd, 0x00}, []verify.Entry{{Offset: 0, Args: u64s, Coroutine: false}}, testerr.Nil(), 0},
+		{"Unreachable", []uint8{opJump, 0x01, 0x05, opAdd, 0x00},
+			[]verify.Entry{{Offset: 3, Args: u64s, Coroutine: false}}, testerr.Nil(), 0},
+		{"Underflow", []uint8{opJump, 0x01, 0x05, opAdd, 0x00},
+			[]verify.Entry{{Offset: 3, Args: u64s[:1], Coroutine: false}}, testerr.Is(verify.ErrStackUnderflow), 3},
+		{"Target", []uint8{opPush, 0x81}, []verify.Entry{{Offset: 1, Args: nil, Coroutine: false}},
+			testerr.Is(verify.ErrInvalidTarget), 1},
+		{"Type", []uint8{opPush, 0x81, opAdd, 0x00},
+			[]verify.Entry{{Offset: 0, Args: []typeid.ID{typeid.String}, Coroutine: false}},
+			testerr.Is(verify.ErrType), 2},
+		{"ThreadReturn", []uint8{opJump, 0x01, 0x05, opReturn, 0x00},
+			[]verify.Entry{{Offset: 3, Args: nil, Coroutine: false}}, testerr.Is(verify.ErrFrameUnderflow), 3},
+		{"CoroutineReturn", []uint8{opJump, 0x01, 0x05, opReturn, 0x01},
+			[]verify.Entry{{Offset: 3, Args: []typeid.ID{verify.Unknown}, Coroutine: true}}, testerr.Nil(), 0},
+		{"CoroutineArgs", []uint8{opJump, 0x01, 0x05, opReturn, 0x00},
+			[]verify.Entry{{Offset: 3, Args: nil, Coroutine: true}}, testerr.Is(verify.ErrArgumentCount), 3},
+		{"Called", called, []verify.Entry{{Offset: 8, Args: []typeid.ID{typeid.Uint64}, Coroutine: true}},
+			testerr.Nil(), 0},
+		{"CalledArgs", called, []verify.Entry{{Offset: 8, Args: u64s, Coroutine: true}},
+			testerr.Is(verify.ErrArgumentCount), 8},
+		{"CalledThread", called, []verify.Entry{{Offset: 8, Args: []typeid.ID{typeid.Uint64}, Coroutine: false}},
+			testerr.Is(verify.ErrFrameUnderflow), 8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := verify.Verify(test.data, nil, test.entries...)
+			test.errval.Require(t, err)
+
+			if err != nil {
+				var verr verify.Error
+				require.ErrorAs(t, err, &verr)
+				require.Equal(t, test.offset, verr.Offset)
+			}
+		})
+	}
+
+	t.Run("Types", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Jump 0x05, Compare Lt
+		data := []uint8{opJump, 0x01, 0x05, opCompare, 0x02}
+		entries := []verify.Entry{
+			{Offset: 3, Args: []typeid.ID{typeid.Int64, typeid.Uint64}, Coroutine: false},
+			{Offset: 3, Args: []typeid.ID{typeid.Int64, typeid.Float64}, Coroutine: false},
+		}
+
+		a, err := verify.Verify(data, nil, entries...)
+		require.NoError(t, err)
+
+		s, ok := a.StateAt(3)
+		require.True(t, ok)
+		require.Equal(t, &verify.State{Height: 2, Types: []typeid.ID{typeid.Int64, verify.Unknown}}, s)
+
+		a, err = verify.Verify(called, nil, verify.Entry{Offset: 8, Args: []typeid.ID{typeid.Int64}, Coroutine: true})
+		require.NoError(t, err)
+
+		s, ok = a.StateAt(8)
+		require.True(t, ok)
+		require.Equal(t, &verify.State{Height: 1, Types: []typeid.ID{verify.Unknown}}, s)
+	})
+}
+
+func TestVerifyCorpus(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range bench.Corpus() {
+		t.Run(p.Name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := verify.Verify(p.Data, nil)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAnalysisStateAt(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: Push 1, Push i8 -1, Compare Lt, JumpIf 0x12, Push f32 2.0, Pop 1
+	data := []uint8{
+		opPush, 0x81, opPush, 0x11, 0xFF, opCompare, 0x02, opJumpIf, 0x01, 0x12,
+		opPush, 0x24, 0x40, 0x00, 0x00, 0x00, opPop, 0x80,
+	}
+
+	a, err := verify.Verify(data, nil)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name     string
+		offset   int
+		expected *verify.State
+	}{
+		{"Entry", 0, &verify.State{Height: 0, Types: []typeid.ID{}}},
+		{"Pushed", 5, &verify.State{Height: 2, Types: []typeid.ID{typeid.Uint64, typeid.Int64}}},
+		{"Compared", 7, &verify.State{Height: 1, Types: []typeid.ID{typeid.Uint64}}},
+		{"Float", 16, &verify.State{Height: 1, Types: []typeid.ID{typeid.Float64}}},
+		{"Immediate", 1, nil},
+		{"PastEnd", 18, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, ok := a.StateAt(test.offset)
+			require.Equal(t, test.expected != nil, ok)
+			if ok {
+				require.Equal(t, test.expected, s)
+			}
+		})
+	}
+}
+
+func TestAnalysisOperands(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: Push 1, Push i8 -1, Add, Push 2, Dupe 0, Compare Eq, Dupe 0,
+	// JumpIf 0x12, Add
+	data := []uint8{
+		opPush, 0x81, opPush, 0x11, 0xFF, opAdd, 0x00, opPush, 0x82, opDupe, 0x00,
+		opCompare, 0x00, opDupe, 0x00, opJumpIf, 0x01, 0x12, opAdd, 0x00,
+	}
+
+	a, err := verify.Verify(data, nil)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name     string
+		offset   int
+		expected typeid.ID
+		ok       bool
+	}{
+		{"Mixed", 5, typeid.Void, false},
+		{"Compare", 11, typeid.Uint64, true},
+		{"JumpIf", 15, typeid.Uint64, true},
+		{"Promoted", 18, typeid.Void, false},
+		{"NotOperation", 7, typeid.Void, false},
+		{"NotInstruction", 1, typeid.Void, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			operands, ok := a.Operands(test.offset)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.expected, operands)
+		})
+	}
+}
+
+func TestPredecode(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range bench.Corpus() {
+		t.Run(p.Name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: p.Data}
+			require.NoError(t, verify.Predecode(th))
+			require.ErrorIs(t, th.Run(), vm.ErrBytecodeOverflow)
+			require.Equal(t, p.Result, vm.Values(th.Stack))
+		})
+	}
+
+	t.Run("Entries", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 2, Add
+		data := []uint8{opPush, 0x81, opPush, 0x82, opAdd, 0x00}
+		floats := []typeid.ID{typeid.Float64, typeid.Float64}
+
+		th := &vm.Thread{Data: data}
+		require.NoError(t, verify.Predecode(th))
+		require.Equal(t, typeid.Uint64, th.Program.Instructions[2].Operands)
+
+		th = &vm.Thread{Data: data}
+		require.NoError(t, verify.Predecode(th, verify.Entry{Offset: 4, Args: floats, Coroutine: false}))
+		require.Equal(t, typeid.Void, th.Program.Instructions[2].Operands)
+
+		th = &vm.Thread{Data: data, PC: 4, Stack: []vm.Slot{vm.FloatSlot(1.5), vm.FloatSlot(2)}}
+		require.NoError(t, verify.Predecode(th))
+		require.Equal(t, typeid.Void, th.Program.Instructions[2].Operands)
+		require.ErrorIs(t, th.Run(), vm.ErrBytecodeOverflow)
+		require.Equal(t, []vm.Slot{vm.FloatSlot(3.5)}, th.Stack)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opAdd, 0x00}}
+		require.ErrorIs(t, verify.Predecode(th), verify.ErrStackUnderflow)
+		require.Nil(t, th.Program)
+	})
+}
@@ -0,0 +1,482 @@
+package vm
+
+import (
+	"math"
+	"slices"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	chanTypeMask       = 0x1F
+	selectCountMask    = 0x0F
+	selectNoWaitBit    = 0x80
+	selectReservedMask = 0x70
+)
+
+// Status values pushed by Recv, TryRecv and Select along with the value
+// received.
+const (
+	// RecvClosed indicates that the channel was closed and drained. The value
+	// pushed is the zero value of the element type of the channel.
+	RecvClosed uint64 = iota
+
+	// RecvOK indicates that a value was received.
+	RecvOK
+
+	// RecvEmpty indicates that a TryRecv or a non-blocking Select found no
+	// value to receive. The value pushed is void.
+	RecvEmpty
+)
+
+// channel is a heap object passing values between the threads of a machine.
+//
+// Values are received in the order they were sent. A channel with a capacity
+// of 0 is unbuffered; a value is only sent on it by handing it to a thread
+// which is waiting to receive from it.
+type channel struct {
+	elem     typeid.ID
+	capacity int
+	buf      []Slot
+	closed   bool
+	handle   Handle
+	waiters  []chanWaiter
+}
+
+// chanWaiter is a thread blocked on a channel.
+type chanWaiter struct {
+	thread *Thread
+	recv   bool
+}
+
+// delivery is a value handed to a thread blocked receiving from an unbuffered
+// channel, which the thread receives once it runs again.
+type delivery struct {
+	ch    *channel
+	value Slot
+}
+
+func (c *channel) ID() typeid.ID {
+	return typeid.Channel
+}
+
+func (c *channel) Size() int {
+	return 64 + 32*c.capacity
+}
+
+func (c *channel) Upcast() types.StackValue {
+	return nil
+}
+
+// Trace visits every heap object referred to by a value buffered in the
+// channel.
+func (c *channel) Trace(visit func(Handle)) {
+	traceSlots(c.buf, visit)
+}
+
+// canSend returns if a value may be sent on the channel without blocking.
+func (c *channel) canSend() bool {
+	if c.capacity > 0 {
+		return len(c.buf) < c.capacity
+	}
+
+	return slices.ContainsFunc(c.waiters, func(w chanWaiter) bool {
+		return w.recv
+	})
+}
+
+// send sends the given value on the channel, which must be able to take it
+// without blocking. The machine must be locked.
+//
+// A value sent on an unbuffered channel is handed to the receiver which has
+// waited longest. Waking the receiver removes it from the waiters of every
+// channel it waits on, so a Select is never handed more than one value.
+func (c *channel) send(m *Machine, v Slot) {
+	if c.capacity > 0 {
+		c.buf = append(c.buf, v)
+		c.wakeAll(m, true)
+
+		return
+	}
+
+	i := slices.IndexFunc(c.waiters, func(w chanWaiter) bool {
+		return w.recv
+	})
+
+	receiver := c.waiters[i].thread
+	receiver.delivered = &delivery{ch: c, value: v}
+	m.wake(receiver)
+}
+
+// recv removes the next value from the channel, returning it and its status.
+// If no value can be received without blocking false is returned.
+func (c *channel) recv() (Slot, uint64, bool) {
+	switch {
+	case len(c.buf) > 0:
+		s := c.buf[0]
+		c.buf[0] = Slot{}
+		c.buf = c.buf[1:]
+
+		return s, RecvOK, true
+	case c.closed:
+		return zeroSlot(c.elem), RecvClosed, true
+	default:
+		return Slot{}, RecvEmpty, false
+	}
+}
+
+// wakeAll wakes every thread waiting on the channel as a receiver if recv is
+// set, or as a sender otherwise. The machine must be locked.
+func (c *channel) wakeAll(m *Machine, recv bool) {
+	var woken []*Thread
+	for _, w := range c.waiters {
+		if w.recv == recv {
+			woken = append(woken, w.thread)
+		}
+	}
+
+	for _, t := range woken {
+		m.wake(t)
+	}
+}
+
+// NewChannel returns a slot referring to a new channel of values of the given
+// element type, buffering up to capacity values.
+//
+// A channel with the void element type accepts values of any type, and one of
+// a narrow numeric type accepts values in its range. The channel is a heap
+// object, accounted for as by New. If the capacity is negative or does not fit
+// in an int32 ErrInvalidCapacity is returned.
+func (t *Thread) NewChannel(elem typeid.ID, capacity int) (Slot, error) {
+	if capacity < 0 || capacity > math.MaxInt32 {
+		return Slot{}, ErrInvalidCapacity
+	}
+
+	ch := &channel{elem: elem, capacity: capacity, buf: nil, closed: false, handle: 0, waiters: nil}
+
+	s, err := t.New(ch)
+	if err != nil {
+		return Slot{}, err
+	}
+
+	ch.handle, _ = s.Handle()
+
+	return s, nil
+}
+
+// channelAt returns the channel referred to by the slot at the given depth
+// from the top of the current frame.
+func (t *Thread) channelAt(depth int) (*channel, error) {
+	if len(t.Stack)-depth <= t.FrameBase() {
+		return nil, ErrStackUnderflow
+	}
+
+	if t.Machine == nil {
+		return nil, ErrNoMachine
+	}
+
+	s := t.Stack[len(t.Stack)-depth-1]
+
+	handle, ok := s.Handle()
+	if !ok || s.Type != typeid.Channel {
+		return nil, UnexpectedTypeError{ID: s.Type}
+	}
+
+	v, ok := t.Machine.heap.Get(handle)
+	if !ok {
+		return nil, ErrNotReference
+	}
+
+	ch, ok := v.(*channel)
+	if !ok {
+		return nil, UnexpectedTypeError{ID: v.ID()}
+	}
+
+	return ch, nil
+}
+
+// decodeChan reads the control byte of a Chan opcode.
+func (t *Thread) decodeChan(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control&^chanTypeMask != 0 {
+		return InvalidControlError{Op: opcode.Chan, Control: control}
+	}
+
+	return nil
+}
+
+// decodeSelect reads the control byte of a Select opcode.
+func (t *Thread) decodeSelect(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control&selectReservedMask != 0 || control&selectCountMask == 0 {
+		return InvalidControlError{Op: opcode.Select, Control: control}
+	}
+
+	return nil
+}
+
+func (t *Thread) opChan(ins *Instruction) error {
+	if len(t.Stack) <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	capacity := -1
+	switch s := t.Stack[len(t.Stack)-1]; s.Type {
+	case typeid.Uint64:
+		if s.Unsigned() <= math.MaxInt32 {
+			capacity = int(s.Unsigned())
+		}
+	case typeid.Int64:
+		if s.Signed() <= math.MaxInt32 {
+			capacity = int(s.Signed())
+		}
+	default:
+		return UnexpectedTypeError{ID: s.Type}
+	}
+
+	s, err := t.NewChannel(typeid.ID(ins.Control&chanTypeMask), capacity)
+	if err != nil {
+		return err
+	}
+
+	t.Stack[len(t.Stack)-1] = s
+
+	return nil
+}
+
+// opSend sends the value below the channel on top of the stack, blocking until
+// the channel can take it.
+func (t *Thread) opSend(ins *Instruction) error {
+	ch, err := t.channelAt(0)
+	if err != nil {
+		return err
+	}
+
+	t.Machine.mu.Lock()
+	defer t.Machine.mu.Unlock()
+
+	if len(t.Stack)-1 <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	v, err := narrow(ch.elem, t.Stack[len(t.Stack)-2])
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ch.closed:
+		return ErrClosed
+	case !ch.canSend():
+		return t.block(ins, &wait{op: ins.Op, offset: ins.Offset, join: nil, channels: []*channel{ch}}, false)
+	}
+
+	ch.send(t.Machine, v)
+	t.Stack = t.Stack[:len(t.Stack)-2]
+
+	return nil
+}
+
+// opRecv receives a value from the channel on top of the stack. A Recv blocks
+// until a value can be received, while a TryRecv pushes RecvEmpty instead.
+func (t *Thread) opRecv(ins *Instruction) error {
+	ch, err := t.channelAt(0)
+	if err != nil {
+		return err
+	}
+
+	t.Machine.mu.Lock()
+	defer t.Machine.mu.Unlock()
+
+	if err := t.reserve(1); err != nil {
+		return err
+	}
+
+	v, _, ok := t.takeDelivery([]*channel{ch})
+	status := RecvOK
+	if !ok {
+		v, status, ok = ch.recv()
+	}
+
+	if !ok && ins.Op == opcode.Recv {
+		return t.blockRecv(ins, []*channel{ch})
+	}
+
+	if ok {
+		ch.wakeAll(t.Machine, false)
+	}
+
+	t.Stack[len(t.Stack)-1] = v
+	t.push(UnsignedSlot(status))
+
+	return nil
+}
+
+// opClose closes the channel on top of the stack, waking every thread blocked
+// on it.
+func (t *Thread) opClose() error {
+	ch, err := t.channelAt(0)
+	if err != nil {
+		return err
+	}
+
+	t.Machine.mu.Lock()
+	defer t.Machine.mu.Unlock()
+
+	if ch.closed {
+		return ErrClosed
+	}
+
+	ch.closed = true
+	t.Stack = t.Stack[:len(t.Stack)-1]
+	ch.wakeAll(t.Machine, true)
+	ch.wakeAll(t.Machine, false)
+
+	return nil
+}
+
+// opSelect receives a value from the first of the channels on top of the stack
+// which has one, pushing the value, its status and the index of the channel,
+// counting from the deepest. Unless the no-wait bit is set it blocks until one
+// of the channels has a value.
+func (t *Thread) opSelect(ins *Instruction) error {
+	count := int(ins.Control & selectCountMask)
+
+	channels := make([]*channel, count)
+	for i := range count {
+		ch, err := t.channelAt(count - i - 1)
+		if err != nil {
+			return err
+		}
+
+		channels[i] = ch
+	}
+
+	t.Machine.mu.Lock()
+	defer t.Machine.mu.Unlock()
+
+	if err := t.reserve(3 - count); err != nil {
+		return err
+	}
+
+	index, v, status := count, Slot{}, RecvEmpty
+	if s, i, ok := t.takeDelivery(channels); ok {
+		index, v, status = i, s, RecvOK
+	} else {
+		for i, ch := range channels {
+			if s, st, ok := ch.recv(); ok {
+				index, v, status = i, s, st
+				ch.wakeAll(t.Machine, false)
+
+				break
+			}
+		}
+	}
+
+	if index == count && ins.Control&selectNoWaitBit == 0 {
+		return t.blockRecv(ins, channels)
+	}
+
+	t.Stack = t.Stack[:len(t.Stack)-count]
+	t.push(v)
+	t.push(UnsignedSlot(status))
+	t.push(UnsignedSlot(uint64(index)))
+
+	return nil
+}
+
+// takeDelivery returns the value handed to the thread by a send on one of the
+// given channels, along with the index of the channel, removing it from the
+// thread. If no such value was handed to it false is returned. The machine
+// must be locked.
+func (t *Thread) takeDelivery(channels []*channel) (Slot, int, bool) {
+	d := t.delivered
+	if d == nil {
+		return Slot{}, 0, false
+	}
+
+	i := slices.Index(channels, d.ch)
+	if i < 0 {
+		return Slot{}, 0, false
+	}
+
+	t.delivered = nil
+
+	return d.value, i, true
+}
+
+// blockRecv blocks the thread until a value can be received from one of the
+// given channels. Senders blocked on an unbuffered channel are woken, as they
+// may now hand a value over.
+func (t *Thread) blockRecv(ins *Instruction, channels []*channel) error {
+	err := t.block(ins, &wait{op: ins.Op, offset: ins.Offset, join: nil, channels: channels}, true)
+	for _, ch := range channels {
+		if ch.capacity == 0 {
+			ch.wakeAll(t.Machine, false)
+		}
+	}
+
+	return err
+}
+
+// narrow returns the given value as held by a channel or global of the given
+// element type, which holds any value if it is void. A numeric value is
+// narrowed to the element type as by a checked Cast, so a value out of its
+// range results in an OverflowError.
+func narrow(elem typeid.ID, v Slot) (Slot, error) {
+	switch {
+	case elem == typeid.Void:
+		return v, nil
+	case stackType(elem) != v.Type:
+		return Slot{}, UnexpectedTypeError{ID: v.Type}
+	case elem == v.Type:
+		return v, nil
+	}
+
+	r, err := v.Value().DowncastChecked(elem)
+	if err != nil {
+		return Slot{}, err
+	}
+
+	return SlotOf(r), nil
+}
+
+// stackType returns the type a value of the given type has on the stack.
+func stackType(id typeid.ID) typeid.ID {
+	switch id {
+	case typeid.Uint8, typeid.Uint16, typeid.Uint32, typeid.Uint64:
+		return typeid.Uint64
+	case typeid.Int8, typeid.Int16, typeid.Int32, typeid.Int64:
+		return typeid.Int64
+	case typeid.Float32, typeid.Float64:
+		return typeid.Float64
+	default:
+		return id
+	}
+}
+
+// zeroSlot returns the zero value of the given type.
+func zeroSlot(id typeid.ID) Slot {
+	switch stackType(id) {
+	case typeid.Uint64:
+		return UnsignedSlot(0)
+	case typeid.Int64:
+		return SignedSlot(0)
+	case typeid.Float64:
+		return FloatSlot(0)
+	default:
+		return Slot{}
+	}
+}
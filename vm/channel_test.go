@@ -0,0 +1,330 @@
+package vm_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opChan    = uint8(opcode.Chan)
+	opSend    = uint8(opcode.Send)
+	opRecv    = uint8(opcode.Recv)
+	opTryRecv = uint8(opcode.TryRecv)
+	opClose   = uint8(opcode.Close)
+	opSelect  = uint8(opcode.Select)
+)
+
+// producer sends 1, 2 and 3 on the channel on top of the stack, then closes it
+// and jumps past the end of the bytecode.
+var producer = []uint8{
+	opPush, 0x81, opDupe, 0x01, opSend,
+	opPush, 0x82, opDupe, 0x01, opSend,
+	opPush, 0x83, opDupe, 0x01, opSend,
+	opClose, opJump, 0x01, 0xFF,
+}
+
+// consumer receives four times from the channel on top of the stack.
+var consumer = []uint8{
+	opDupe, 0x00, opRecv, opDupe, 0x02, opRecv, opDupe, 0x04, opRecv, opDupe, 0x06, opRecv,
+}
+
+func TestMachineChannels(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name          string
+		capacity      int
+		slice         int
+		consumerFirst bool
+	}{
+		{"Buffered", 3, 0, false},
+		{"BufferedSliced", 1, 2, true},
+		{"UnbufferedProducerFirst", 0, 1, false},
+		{"UnbufferedConsumerFirst", 0, 1, true},
+		{"UnbufferedSliced", 0, 3, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: slices.Concat(producer, consumer), Slice: test.slice}
+			ch, err := (&vm.Thread{Machine: m}).NewChannel(typeid.Uint8, test.capacity)
+			require.NoError(t, err)
+
+			var recv *vm.Thread
+			if test.consumerFirst {
+				recv = m.Spawn(len(producer), ch)
+				m.Spawn(0, ch)
+			} else {
+				m.Spawn(0, ch)
+				recv = m.Spawn(len(producer), ch)
+			}
+
+			require.NoError(t, m.Run())
+			require.NoError(t, recv.Err)
+			require.Equal(t, vals(u64(1), u64(1), u64(2), u64(1), u64(3), u64(1), u64(0), u64(0)),
+				vm.Values(recv.Stack[1:]))
+		})
+	}
+}
+
+func TestThreadChannel(t *testing.T) {
+	t.Parallel()
+
+	run := func(t *testing.T, data []uint8, capacity int, elem typeid.ID) (*vm.Thread, error) {
+		t.Helper()
+
+		m := &vm.Machine{Data: data}
+		ch, err := (&vm.Thread{Machine: m}).NewChannel(elem, capacity)
+		require.NoError(t, err)
+
+		th := m.Spawn(0, ch)
+
+		return th, m.Run()
+	}
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		capacity int
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"TryRecvEmpty", []uint8{opDupe, 0x00, opTryRecv}, 1, vals(nil, nil, u64(2)), testerr.Nil()},
+		{"TryRecv", []uint8{opPush, 0x85, opDupe, 0x01, opSend, opDupe, 0x00, opTryRecv}, 1,
+			vals(nil, u64(5), u64(1)), testerr.Nil()},
+		{"TryRecvClosed", []uint8{opDupe, 0x00, opClose, opDupe, 0x00, opTryRecv}, 0,
+			vals(nil, u64(0), u64(0)), testerr.Nil()},
+		{"SendClosed", []uint8{opDupe, 0x00, opClose, opPush, 0x81, opDupe, 0x01, opSend}, 1,
+			vals(nil, u64(1), nil), testerr.Is(vm.ErrClosed)},
+		{"CloseClosed", []uint8{opDupe, 0x00, opClose, opDupe, 0x00, opClose}, 1,
+			vals(nil, nil), testerr.Is(vm.ErrClosed)},
+		{"SendType", []uint8{opPush, 0x11, 0x01, opDupe, 0x01, opSend}, 1,
+			vals(nil, i64(1), nil), testerr.Is(vm.ErrUnexpectedType)},
+		{"SendNotChannel", []uint8{opPush, 0x81, opPush, 0x81, opSend}, 1,
+			vals(nil, u64(1), u64(1)), testerr.Is(vm.ErrUnexpectedType)},
+		{"RecvUnderflow", []uint8{opPop, 0x80, opRecv}, 1, []types.Value{}, testerr.Is(vm.ErrStackUnderflow)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th, err := run(t, test.data, test.capacity, typeid.Uint64)
+			require.NoError(t, err)
+			require.Equal(t, vm.ThreadDone, th.State)
+			test.errval.Require(t, th.Err)
+			if th.Err != nil {
+				require.ErrorIs(t, th.Err, vm.ErrUncaughtException)
+			}
+
+			got := vm.Values(th.Stack)
+			for i, v := range got {
+				if v != nil && v.ID() == typeid.Channel {
+					got[i] = nil
+				}
+			}
+
+			require.Equal(t, test.expected, got)
+		})
+	}
+
+	t.Run("Narrow", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 255, Dupe 1, Send, Push 256, Dupe 1, Send
+		data := []uint8{opPush, 0x01, 0xFF, opDupe, 0x01, opSend, opPush, 0x02, 0x01, 0x00, opDupe, 0x01, opSend}
+		th, err := run(t, data, 2, typeid.Uint8)
+		require.NoError(t, err)
+		testerr.Is(types.OverflowError{From: typeid.Uint64, To: typeid.Uint8}).Require(t, th.Err)
+	})
+
+	t.Run("Chan", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 0, Chan i64, Push -7, Dupe 1, Send, Recv
+		m := &vm.Machine{Data: []uint8{
+			opPush, 0x80, opChan, byte(typeid.Int64), opPush, 0x11, 0xF9, opDupe, 0x01, opSend,
+		}}
+		th := m.Spawn(0)
+
+		err := m.Run()
+		require.ErrorIs(t, err, vm.ErrDeadlock)
+
+		var deadlock vm.DeadlockError
+		require.ErrorAs(t, err, &deadlock)
+		require.Len(t, deadlock.Blocked, 1)
+
+		ch := th.Stack[0]
+		require.Equal(t, typeid.Channel, ch.Type)
+		require.Equal(t, []vm.Blocked{{
+			Thread: 1, Op: opcode.Send, Offset: 0x09, Join: 0, Channels: []vm.Handle{handle(t, ch)},
+		}}, deadlock.Blocked)
+		require.Equal(t, deadlock.Blocked, m.Blocked())
+		require.EqualError(t, err, "deadlock: thread 1 blocked in Send at 0x9 on channel 1")
+	})
+
+	t.Run("ChanFaults", func(t *testing.T) {
+		t.Parallel()
+
+		for _, test := range []struct {
+			name   string
+			data   []uint8
+			errval testerr.ExpectedError
+		}{
+			{"Negative", []uint8{opPush, 0x11, 0xFF, opChan, 0x00}, testerr.Is(vm.ErrInvalidCapacity)},
+			{"Type", []uint8{opPush, 0x24, 0, 0, 0, 0, opChan, 0x00}, testerr.Is(vm.ErrUnexpectedType)},
+			{"Control", []uint8{opPush, 0x81, opChan, 0x20},
+				testerr.Is(vm.InvalidControlError{Op: opcode.Chan, Control: 0x20})},
+			{"SelectCount", []uint8{opSelect, 0x80},
+				testerr.Is(vm.InvalidControlError{Op: opcode.Select, Control: 0x80})},
+			{"SelectReserved", []uint8{opSelect, 0x11},
+				testerr.Is(vm.InvalidControlError{Op: opcode.Select, Control: 0x11})},
+		} {
+			m := &vm.Machine{Data: test.data}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run(), test.name)
+			test.errval.Require(t, th.Err)
+		}
+
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opChan, 0x00}}
+		require.ErrorIs(t, th.Run(), vm.ErrNoMachine)
+	})
+
+	t.Run("Deadlock", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Dupe 0, Recv
+		// 0x03: Push 1, Join
+		m := &vm.Machine{Data: []uint8{opDupe, 0x00, opRecv, opPush, 0x81, opJoin}}
+		ch, err := (&vm.Thread{Machine: m}).NewChannel(typeid.Void, 0)
+		require.NoError(t, err)
+
+		m.Spawn(0, ch)
+		m.Spawn(3)
+		require.EqualError(t, m.Run(),
+			"deadlock: thread 1 blocked in Recv at 0x2 on channel 1; thread 2 blocked in Join at 0x5 on thread 1")
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: []uint8{opSend}}
+		th := &vm.Thread{Machine: m}
+		ch, err := th.NewChannel(typeid.Void, 1)
+		require.NoError(t, err)
+
+		value, err := th.New(&cell{})
+		require.NoError(t, err)
+
+		sender := m.Spawn(0, value, ch)
+		require.NoError(t, m.Run())
+		require.NoError(t, sender.Err)
+
+		m.Heap().AddRoots(th)
+		th.Stack = []vm.Slot{ch}
+		require.Equal(t, 0, m.Collect())
+
+		th.Stack = nil
+		require.Equal(t, 2, m.Collect())
+	})
+}
+
+func TestThreadSelect(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+	}{
+		{
+			"Second",
+			[]uint8{opPush, 0x87, opDupe, 0x01, opSend, opDupe, 0x01, opDupe, 0x01, opSelect, 0x02},
+			vals(u64(7), u64(1), u64(1)),
+		},
+		{
+			"First",
+			[]uint8{
+				opPush, 0x87, opDupe, 0x01, opSend, opPush, 0x88, opDupe, 0x02, opSend,
+				opDupe, 0x01, opDupe, 0x01, opSelect, 0x02,
+			},
+			vals(u64(8), u64(1), u64(0)),
+		},
+		{"NoWait", []uint8{opDupe, 0x01, opDupe, 0x01, opSelect, 0x82}, vals(nil, u64(2), u64(2))},
+		{
+			"Closed",
+			[]uint8{opDupe, 0x00, opClose, opDupe, 0x01, opDupe, 0x01, opSelect, 0x02},
+			vals(u64(0), u64(0), u64(1)),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data}
+			th := &vm.Thread{Machine: m}
+			first, err := th.NewChannel(typeid.Uint64, 1)
+			require.NoError(t, err)
+
+			second, err := th.NewChannel(typeid.Uint64, 1)
+			require.NoError(t, err)
+
+			th = m.Spawn(0, first, second)
+			require.NoError(t, m.Run())
+			require.NoError(t, th.Err)
+			require.Equal(t, test.expected, vm.Values(th.Stack[2:]))
+		})
+	}
+
+	t.Run("Blocking", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Dupe 1, Dupe 1, Select 2, Jump 0xFF
+		// 0x09: Yield, Push 9, Dupe 1, Send
+		m := &vm.Machine{Data: []uint8{
+			opDupe, 0x01, opDupe, 0x01, opSelect, 0x02, opJump, 0x01, 0xFF,
+			opYield, opPush, 0x89, opDupe, 0x01, opSend,
+		}}
+		th := &vm.Thread{Machine: m}
+		first, err := th.NewChannel(typeid.Uint64, 0)
+		require.NoError(t, err)
+
+		second, err := th.NewChannel(typeid.Uint64, 0)
+		require.NoError(t, err)
+
+		recv := m.Spawn(0, first, second)
+		m.Spawn(9, second)
+		require.NoError(t, m.Run())
+		require.Equal(t, vals(u64(9), u64(1), u64(1)), vm.Values(recv.Stack[2:]))
+	})
+
+	t.Run("Handoff", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Dupe 1, Dupe 1, Select 2, Jump 0xFF
+		// 0x09: Yield, Push 8, Dupe 1, Send
+		m := &vm.Machine{Data: []uint8{
+			opDupe, 0x01, opDupe, 0x01, opSelect, 0x02, opJump, 0x01, 0xFF,
+			opYield, opPush, 0x88, opDupe, 0x01, opSend,
+		}}
+		th := &vm.Thread{Machine: m}
+		buffered, err := th.NewChannel(typeid.Uint64, 1)
+		require.NoError(t, err)
+
+		unbuffered, err := th.NewChannel(typeid.Uint64, 0)
+		require.NoError(t, err)
+
+		// The value sent on the unbuffered channel is handed to the Select,
+		// which receives it even though the buffered channel it checks first
+		// has a value by the time it runs again.
+		recv := m.Spawn(0, buffered, unbuffered)
+		m.Spawn(9, unbuffered)
+		m.Spawn(9, buffered)
+		require.NoError(t, m.Run())
+		require.Equal(t, vals(u64(8), u64(1), u64(1)), vm.Values(recv.Stack[2:]))
+	})
+}
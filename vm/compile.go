@@ -0,0 +1,268 @@
+package vm
+
+import (
+	"slices"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// DefaultHotCalls is the number of calls after which a function of a decoded
+// program is compiled.
+const DefaultHotCalls = 100
+
+// closure is a compiled instruction.
+//
+// A closure runs its instruction and returns the index of the instruction
+// which runs next, or -1 if that depends on the state of the thread.
+type closure func(t *Thread) (int, error)
+
+// Compile compiles the function which starts at the given offset into a chain
+// of closures, one per instruction, with the operands of each instruction
+// bound ahead of time.
+//
+// Every instruction reachable from the entry without following a Call must
+// have been decoded, otherwise a CompileError is returned and nothing is
+// compiled. Compiling a function which is already compiled does nothing.
+func (p *Program) Compile(entry int) error {
+	start, ok := p.Lookup(entry)
+	if !ok {
+		return CompileError{Entry: entry, Offset: entry}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	closures := slices.Clone(p.compiled())
+	if closures == nil {
+		closures = make([]closure, len(p.code))
+	}
+
+	seen := make([]bool, len(p.code))
+	reached := []int{}
+	pending := []int{start}
+	for len(pending) > 0 {
+		idx := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[idx] || closures[idx] != nil {
+			continue
+		}
+
+		seen[idx] = true
+		reached = append(reached, idx)
+
+		for _, offset := range p.successors(&p.code[idx]) {
+			if offset == p.size {
+				continue
+			}
+
+			next, ok := p.Lookup(offset)
+			if !ok {
+				return CompileError{Entry: entry, Offset: offset}
+			}
+
+			pending = append(pending, next)
+		}
+	}
+
+	for _, idx := range reached {
+		closures[idx] = p.compile(idx)
+	}
+
+	p.closures.Store(&closures)
+
+	return nil
+}
+
+// Compiled returns if the instruction at the given offset has been compiled.
+func (p *Program) Compiled(offset int) bool {
+	idx, ok := p.Lookup(offset)
+	return ok && p.closure(idx) != nil
+}
+
+// called records a call to the function at the given offset, compiling it once
+// it has been called HotCalls times.
+func (p *Program) called(target int) {
+	if p == nil || p.HotCalls <= 0 {
+		return
+	}
+
+	idx, ok := p.Lookup(target)
+	if !ok {
+		return
+	}
+
+	if p.calls[idx].Add(1) == int64(p.HotCalls) {
+		// A function which can't be compiled keeps being interpreted.
+		_ = p.Compile(target)
+	}
+}
+
+// closure returns the compiled form of the instruction at the given index, or
+// nil if it has not been compiled.
+func (p *Program) closure(idx int) closure {
+	closures := p.compiled()
+	if closures == nil {
+		return nil
+	}
+
+	return closures[idx]
+}
+
+// compiled returns the compiled forms of the instructions of the program, or
+// nil if nothing has been compiled. The returned slice must not be modified.
+func (p *Program) compiled() []closure {
+	if closures := p.closures.Load(); closures != nil {
+		return *closures
+	}
+
+	return nil
+}
+
+// successors returns the offsets execution may continue from after the given
+// instruction, not counting the target of a Call or a Return.
+func (p *Program) successors(ins *Instruction) []int {
+	switch ins.Op {
+	case opcode.Jump:
+		return []int{ins.Target}
+	case opcode.Return, opcode.Throw:
+		return nil
+	case opcode.JumpIf, opcode.CompareJumpIf:
+		return []int{ins.Next, ins.Target}
+	default:
+		return []int{ins.Next}
+	}
+}
+
+// indexOf returns the index of the instruction at the given offset, or -1 if no
+// decoded instruction starts there.
+func (p *Program) indexOf(offset int) int {
+	if idx, ok := p.Lookup(offset); ok {
+		return idx
+	}
+
+	return -1
+}
+
+// compile returns the compiled form of the instruction at the given index.
+func (p *Program) compile(idx int) closure {
+	ins := p.code[idx]
+	pc, next := ins.Next, p.indexOf(ins.Next)
+
+	switch ins.Op {
+	case opcode.NoOp:
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+			return next, nil
+		}
+	case opcode.Push:
+		v := ins.Value
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+			if err := t.grow(); err != nil {
+				return next, err
+			}
+
+			t.push(v)
+
+			return next, nil
+		}
+	case opcode.Dupe:
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+			return next, t.opDupe(&ins)
+		}
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod:
+		op, mode, operands := ins.Op, ins.Control&arithModeMask, ins.Operands
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+
+			left, err := t.pop()
+			if err != nil {
+				return next, err
+			}
+
+			if operands != typeid.Void && t.typedArith(op, mode, operands, left) {
+				return next, nil
+			}
+
+			return next, t.arithWith(op, mode, left)
+		}
+	case opcode.PushArith:
+		// A fault of the Push is raised from the Push by Thread.fault, which
+		// also moves the program counter back to the arithmetic opcode.
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+			return next, t.opPushArith(&ins)
+		}
+	case opcode.Jump:
+		target, targetIdx := ins.Target, p.indexOf(ins.Target)
+		return func(t *Thread) (int, error) {
+			t.PC = target
+			return targetIdx, nil
+		}
+	case opcode.JumpIf, opcode.CompareJumpIf:
+		return p.compileBranch(ins, next)
+	default:
+		return func(t *Thread) (int, error) {
+			t.PC = pc
+			return -1, t.execute(&ins)
+		}
+	}
+}
+
+// compileBranch returns the compiled form of a JumpIf or CompareJumpIf
+// instruction.
+func (p *Program) compileBranch(ins Instruction, next int) closure {
+	pc, target, targetIdx := ins.Next, ins.Target, p.indexOf(ins.Target)
+	operands := ins.Operands
+
+	control := ins.Control
+	if ins.Op == opcode.CompareJumpIf {
+		control = ins.FusedControl
+	}
+
+	onZero := control&jumpIfZeroBit != 0
+
+	if ins.Op == opcode.CompareJumpIf {
+		relation, fusedOffset := ins.Control, ins.FusedOffset
+		return func(t *Thread) (int, error) {
+			result, err := t.compareTop(relation, operands)
+			if err != nil {
+				t.PC = fusedOffset
+				return next, err
+			}
+
+			if result != onZero {
+				t.PC = target
+				return targetIdx, nil
+			}
+
+			t.PC = pc
+
+			return next, nil
+		}
+	}
+
+	return func(t *Thread) (int, error) {
+		t.PC = pc
+
+		s, err := t.pop()
+		if err != nil {
+			return next, err
+		}
+
+		truth, err := typedTruth(operands, s)
+		if err != nil {
+			return next, err
+		}
+
+		if truth != onZero {
+			t.PC = target
+			return targetIdx, nil
+		}
+
+		return next, nil
+	}
+}
@@ -0,0 +1,69 @@
+package vm_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestProgramCompile(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		entry    int
+		compiled []int
+		errval   testerr.ExpectedError
+	}{
+		{"Countdown", countdown(3), 0, []int{0, 4, 9, 11}, testerr.Nil()},
+		{
+			"Function", bench.Fib(3), 8,
+			[]int{0x08, 0x0A, 0x0C, 0x11, 0x13, 0x15, 0x17, 0x1A, 0x1C, 0x1E, 0x20, 0x23, 0x25, 0x27}, testerr.Nil(),
+		},
+		{
+			"Undecoded", []uint8{opPush, 0x81, opJumpIf, 0x01, 0x07, opPush, 0x81, opPush, 0x1F}, 0, nil,
+			testerr.Is(vm.CompileError{Entry: 0, Offset: 7}),
+		},
+		{"Target", []uint8{opJump, 0x01, 0x04, opPush, 0x00, 0x81}, 0, nil, testerr.Is(vm.CompileError{Entry: 0, Offset: 4})},
+		{"Entry", countdown(3), 2, nil, testerr.Is(vm.CompileError{Entry: 2, Offset: 2})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := vm.Decode(test.data)
+			p.Fuse()
+			test.errval.Require(t, p.Compile(test.entry))
+
+			for _, ins := range p.Instructions {
+				require.Equal(t, slices.Contains(test.compiled, ins.Offset), p.Compiled(ins.Offset), "offset %d", ins.Offset)
+			}
+		})
+	}
+
+	t.Run("Hot", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: bench.Fib(5)}
+		th.Predecode()
+		th.Program.HotCalls = 3
+		errOverflow.Require(t, th.Run())
+		requireStack(t, vals(u64(5)), th.Stack)
+		require.True(t, th.Program.Compiled(8))
+		require.False(t, th.Program.Compiled(0))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: bench.Fib(5)}
+		th.Predecode()
+		th.Program.HotCalls = 0
+		errOverflow.Require(t, th.Run())
+		require.False(t, th.Program.Compiled(8))
+	})
+}
@@ -0,0 +1,368 @@
+package vm
+
+import (
+	"errors"
+	"iter"
+
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Status values pushed by CoResume along with the value the coroutine yielded
+// or returned.
+const (
+	// ResumeReturned indicates that the coroutine returned, and can not be
+	// resumed again.
+	ResumeReturned uint64 = iota
+
+	// ResumeYielded indicates that the coroutine yielded, and may be resumed.
+	ResumeYielded
+)
+
+// coState is the state of a coroutine.
+type coState uint8
+
+const (
+	coCreated coState = iota
+	coSuspended
+	coRunning
+	coDead
+)
+
+// coContext is the execution state of a thread which is swapped out while a
+// coroutine runs.
+type coContext struct {
+	stack  []Slot
+	frames []Frame
+	pc     int
+}
+
+// coroutine is a heap object running code on its own stack, which is
+// suspended each time it yields a value and continued when it is resumed.
+//
+// While a coroutine is suspended its stack, frames and program counter are
+// held in saved. While it runs they are those of the thread running it, and
+// the state of whatever resumed it is held in resumer.
+type coroutine struct {
+	state  coState
+	handle Handle
+	saved  coContext
+
+	resumer coContext
+	caller  *coroutine
+	offset  int
+}
+
+func (c *coroutine) ID() typeid.ID {
+	return typeid.Coroutine
+}
+
+func (c *coroutine) Size() int {
+	return 128
+}
+
+func (c *coroutine) Upcast() types.StackValue {
+	return nil
+}
+
+// Trace visits every heap object referred to by the stack of the suspended
+// coroutine.
+func (c *coroutine) Trace(visit func(Handle)) {
+	traceSlots(c.saved.stack, visit)
+}
+
+// NewCoroutine returns a slot referring to a new coroutine which starts
+// running at the given entry offset when it is first resumed.
+//
+// The coroutine is a heap object, accounted for as by New.
+func (t *Thread) NewCoroutine(entry int) (Slot, error) {
+	c := &coroutine{
+		state:   coCreated,
+		handle:  0,
+		saved:   coContext{stack: nil, frames: nil, pc: entry},
+		resumer: coContext{stack: nil, frames: nil, pc: 0},
+		caller:  nil,
+		offset:  0,
+	}
+
+	s, err := t.New(c)
+	if err != nil {
+		return Slot{}, err
+	}
+
+	c.handle, _ = s.Handle()
+
+	return s, nil
+}
+
+// coroutineOf returns the coroutine the given slot refers to.
+func (t *Thread) coroutineOf(s Slot) (*coroutine, error) {
+	if t.Machine == nil {
+		return nil, ErrNoMachine
+	}
+
+	handle, ok := s.Handle()
+	if !ok || s.Type != typeid.Coroutine {
+		return nil, UnexpectedTypeError{ID: s.Type}
+	}
+
+	v, ok := t.Machine.heap.Get(handle)
+	if !ok {
+		return nil, ErrNotReference
+	}
+
+	c, ok := v.(*coroutine)
+	if !ok {
+		return nil, UnexpectedTypeError{ID: v.ID()}
+	}
+
+	return c, nil
+}
+
+// Resume resumes the coroutine the given slot refers to, passing it the given
+// value, and runs the thread until the coroutine yields or returns.
+//
+// The value yielded or returned is returned along with true if the coroutine
+// yielded. If the coroutine raises an exception it does not catch, or running
+// it fails, the coroutine and any coroutine it resumed can not be resumed
+// again, the thread is left as it was before Resume was called, and the error
+// is returned.
+func (t *Thread) Resume(co, v Slot) (Slot, bool, error) {
+	c, err := t.coroutineOf(co)
+	if err != nil {
+		return Slot{}, false, err
+	}
+
+	if err := t.resume(c, v, -1); err != nil {
+		return Slot{}, false, err
+	}
+
+	for c.state == coRunning {
+		if err := t.Step(); err != nil {
+			for c.state == coRunning {
+				t.leave(coDead)
+			}
+
+			return Slot{}, false, err
+		}
+	}
+
+	n := len(t.Stack)
+	result, status := t.Stack[n-2], t.Stack[n-1]
+	t.Stack = t.Stack[:n-2]
+
+	return result, status.Unsigned() == ResumeYielded, nil
+}
+
+// Generator iterates over the values yielded by a coroutine from Go.
+type Generator struct {
+	thread *Thread
+	co     Slot
+	err    error
+}
+
+// Generator returns a Generator over the values yielded by the coroutine the
+// given slot refers to, which is run by the thread.
+func (t *Thread) Generator(co Slot) *Generator {
+	return &Generator{thread: t, co: co, err: nil}
+}
+
+// All returns an iterator which resumes the coroutine with a void value until
+// it returns, yielding each value it yields. The value it returns is not
+// yielded.
+//
+// Iteration stops early if resuming the coroutine fails; Err then returns the
+// error. Stopping the iteration leaves the coroutine suspended.
+func (g *Generator) All() iter.Seq[types.StackValue] {
+	return func(yield func(types.StackValue) bool) {
+		for {
+			v, yielded, err := g.thread.Resume(g.co, Slot{})
+			if err != nil {
+				g.err = err
+				return
+			}
+
+			if !yielded || !yield(v.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error which stopped the last iteration, if any.
+func (g *Generator) Err() error {
+	return g.err
+}
+
+// resume switches the thread to the given coroutine, pushing the given value
+// onto its stack. The offset is that of the CoResume which resumed it, or -1
+// if it was resumed from Go.
+//
+// The stack of the thread is kept counted against its stack limit while the
+// coroutine runs, so the stack of the coroutine must fit alongside it.
+func (t *Thread) resume(c *coroutine, v Slot, offset int) error {
+	if err := t.reserve(len(c.saved.stack) + 1); err != nil {
+		return err
+	}
+
+	if err := t.setState(c, coRunning); err != nil {
+		return err
+	}
+
+	c.resumer = coContext{stack: t.Stack, frames: t.Frames, pc: t.PC}
+	c.caller, c.offset = t.coro, offset
+	t.resumed += len(c.resumer.stack)
+
+	t.Stack, t.Frames, t.PC = append(c.saved.stack, v), c.saved.frames, c.saved.pc
+	c.saved = coContext{stack: nil, frames: nil, pc: 0}
+	t.coro = c
+
+	return nil
+}
+
+// leave switches the thread from the running coroutine back to what resumed
+// it, leaving the coroutine in the given state. A suspended coroutine keeps
+// its stack, frames and program counter.
+func (t *Thread) leave(state coState) {
+	c := t.coro
+	if state == coSuspended {
+		c.saved = coContext{stack: t.Stack, frames: t.Frames, pc: t.PC}
+	}
+
+	t.Stack, t.Frames, t.PC = c.resumer.stack, c.resumer.frames, c.resumer.pc
+	t.coro = c.caller
+	t.resumed -= len(c.resumer.stack)
+
+	c.resumer, c.caller = coContext{stack: nil, frames: nil, pc: 0}, nil
+	_ = t.setState(c, state)
+}
+
+// setState moves the given coroutine to the given state. A coroutine may only
+// start running if it was created or suspended, otherwise ErrNotResumable is
+// returned.
+func (t *Thread) setState(c *coroutine, state coState) error {
+	if m := t.Machine; m != nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	if state == coRunning && c.state != coCreated && c.state != coSuspended {
+		return ErrNotResumable
+	}
+
+	c.state = state
+
+	return nil
+}
+
+func (t *Thread) opCoCreate(ins *Instruction) error {
+	if err := t.grow(); err != nil {
+		return err
+	}
+
+	s, err := t.NewCoroutine(ins.Target)
+	if err != nil {
+		return err
+	}
+
+	t.push(s)
+
+	return nil
+}
+
+// opCoResume resumes the coroutine on top of the stack with the value below
+// it. Its result is pushed once it yields or returns.
+func (t *Thread) opCoResume(ins *Instruction) error {
+	n := len(t.Stack)
+	if n-2 < t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	c, err := t.coroutineOf(t.Stack[n-1])
+	if err != nil {
+		return err
+	}
+
+	v := t.Stack[n-2]
+	t.Stack = t.Stack[:n-2]
+
+	if err := t.resume(c, v, ins.Offset); err != nil {
+		t.Stack = t.Stack[:n]
+		return err
+	}
+
+	return nil
+}
+
+// opCoYield suspends the running coroutine, passing the value on top of its
+// stack to what resumed it.
+func (t *Thread) opCoYield() error {
+	if t.coro == nil {
+		return ErrNotCoroutine
+	}
+
+	v, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	t.leave(coSuspended)
+	t.push(v)
+	t.push(UnsignedSlot(ResumeYielded))
+
+	return nil
+}
+
+// returnCoroutine ends the running coroutine, which returned the given number
+// of values from its outermost frame. The top value returned, or void if none
+// were, is passed to what resumed it.
+func (t *Thread) returnCoroutine(count int) error {
+	if len(t.Stack)-count < 0 {
+		return ErrStackUnderflow
+	}
+
+	var v Slot
+	if count > 0 {
+		v = t.Stack[len(t.Stack)-1]
+	}
+
+	t.leave(coDead)
+	t.push(v)
+	t.push(UnsignedSlot(ResumeReturned))
+
+	return nil
+}
+
+// raiseFromCoroutine kills the running coroutine, which did not catch the
+// given value, and raises it again from the CoResume which resumed it. The
+// trace of the exception so far is kept.
+//
+// If the coroutine was resumed from Go the UncaughtExceptionError is returned.
+func (t *Thread) raiseFromCoroutine(v types.Value, trace []int) error {
+	offset := t.coro.offset
+	t.leave(coDead)
+
+	if offset < 0 {
+		return UncaughtExceptionError{Value: v, Trace: trace}
+	}
+
+	err := t.raise(offset, v)
+
+	var uncaught UncaughtExceptionError
+	if errors.As(err, &uncaught) {
+		uncaught.Trace = append(trace, uncaught.Trace...)
+		return uncaught
+	}
+
+	return err
+}
+
+// traceSlots visits the handle of every heap object referred to by the given
+// slots.
+func traceSlots(slots []Slot, visit func(Handle)) {
+	for _, s := range slots {
+		if handle, ok := s.Handle(); ok {
+			visit(handle)
+		}
+	}
+}
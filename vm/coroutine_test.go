@@ -0,0 +1,223 @@
+package vm_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opCoCreate = uint8(opcode.CoCreate)
+	opCoResume = uint8(opcode.CoResume)
+	opCoYield  = uint8(opcode.CoYield)
+)
+
+// counter yields 1, 2 and 3, then returns 4.
+var counter = []uint8{
+	opPop, 0x80,
+	opPush, 0x81, opCoYield, opPop, 0x80,
+	opPush, 0x82, opCoYield, opPop, 0x80,
+	opPush, 0x83, opCoYield, opPop, 0x80,
+	opPush, 0x84, opReturn, 0x01,
+}
+
+func TestThreadCoroutine(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: CoCreate 0x0B, Push 5, Dupe 1, CoResume, Jump 0xFF
+	resumer := []uint8{opCoCreate, 0x01, 0x0B, opPush, 0x85, opDupe, 0x01, opCoResume, opJump, 0x01, 0xFF}
+
+	for _, test := range []struct {
+		name     string
+		body     []uint8
+		handlers []vm.Handler
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Yield", []uint8{opCoYield}, nil, vals(u64(5), u64(vm.ResumeYielded)), testerr.Nil()},
+		{"Return", []uint8{opReturn, 0x01}, nil, vals(u64(5), u64(vm.ResumeReturned)), testerr.Nil()},
+		{"ReturnVoid", []uint8{opPop, 0x80, opReturn, 0x00}, nil, vals(nil, u64(vm.ResumeReturned)), testerr.Nil()},
+		{"Call", []uint8{opCall, 0x11, 0x10, opReturn, 0x01, opCoYield, opReturn, 0x01}, nil,
+			vals(u64(5), u64(vm.ResumeYielded)), testerr.Nil()},
+		{"Throw", []uint8{opThrow}, nil, vals(), testerr.Is(vm.ErrUncaughtException)},
+		{"Handled", []uint8{opThrow}, []vm.Handler{{Start: 0x07, End: 0x08, Target: 0x08, Depth: 1}},
+			vals(u64(5)), testerr.Nil()},
+		{"HandledInside", []uint8{opThrow, opCoYield}, []vm.Handler{{Start: 0x0B, End: 0x0C, Target: 0x0C, Depth: 0}},
+			vals(u64(5), u64(vm.ResumeYielded)), testerr.Nil()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: slices.Concat(resumer, test.body), Handlers: test.handlers}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run())
+			test.errval.Require(t, th.Err)
+			if th.Err == nil {
+				require.Equal(t, typeid.Coroutine, th.Stack[0].Type)
+				require.Equal(t, test.expected, vm.Values(th.Stack[1:]))
+			}
+		})
+	}
+
+	t.Run("Uncaught", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: slices.Concat(resumer, []uint8{opCall, 0x11, 0x0E, opThrow})}
+		th := m.Spawn(0)
+		require.NoError(t, m.Run())
+
+		var uncaught vm.UncaughtExceptionError
+		require.ErrorAs(t, th.Err, &uncaught)
+		require.Equal(t, u64(5), uncaught.Value)
+		require.Equal(t, []int{0x0E, 0x0B, 0x07}, uncaught.Trace)
+	})
+
+	t.Run("TwoWay", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: CoCreate 0x10, Push 5, Dupe 1, CoResume, Push 7, Dupe 3, CoResume, Jump 0xFF
+		// 0x10: Dupe 0, Add, CoYield, Jump 0x10
+		m := &vm.Machine{Data: []uint8{
+			opCoCreate, 0x01, 0x10, opPush, 0x85, opDupe, 0x01, opCoResume,
+			opPush, 0x87, opDupe, 0x03, opCoResume, opJump, 0x01, 0xFF,
+			opDupe, 0x00, opAdd, 0x00, opCoYield, opJump, 0x01, 0x10,
+		}}
+		th := m.Spawn(0)
+		require.NoError(t, m.Run())
+		require.NoError(t, th.Err)
+		require.Equal(t, vals(u64(10), u64(1), u64(14), u64(1)), vm.Values(th.Stack[1:]))
+	})
+
+	t.Run("Faults", func(t *testing.T) {
+		t.Parallel()
+
+		for _, test := range []struct {
+			name   string
+			data   []uint8
+			errval testerr.ExpectedError
+		}{
+			// 0x00: CoCreate 0x12, Push 0, Dupe 1, CoResume, Pop 2, Push 0, Dupe 1, CoResume
+			// 0x12: Return 0
+			{"Dead", []uint8{
+				opCoCreate, 0x01, 0x12, opPush, 0x80, opDupe, 0x01, opCoResume, opPop, 0x81,
+				opPush, 0x80, opDupe, 0x01, opCoResume, opJump, 0x01, 0xFF, opReturn, 0x00,
+			}, testerr.Is(vm.ErrNotResumable)},
+			{"YieldOutside", []uint8{opPush, 0x81, opCoYield}, testerr.Is(vm.ErrNotCoroutine)},
+			{"ResumeType", []uint8{opPush, 0x81, opPush, 0x81, opCoResume}, testerr.Is(vm.ErrUnexpectedType)},
+			{"ResumeUnderflow", []uint8{opCoCreate, 0x01, 0x00, opCoResume}, testerr.Is(vm.ErrStackUnderflow)},
+			{"Control", []uint8{opCoCreate, 0x11, 0x00},
+				testerr.Is(vm.InvalidControlError{Op: opcode.CoCreate, Control: 0x11})},
+		} {
+			m := &vm.Machine{Data: test.data}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run(), test.name)
+			test.errval.Require(t, th.Err)
+		}
+	})
+}
+
+func TestThreadResume(t *testing.T) {
+	t.Parallel()
+
+	m := &vm.Machine{Data: counter}
+	th := m.Spawn(0)
+	co, err := th.NewCoroutine(0)
+	require.NoError(t, err)
+
+	for i := range 3 {
+		v, yielded, err := th.Resume(co, vm.Slot{})
+		require.NoError(t, err)
+		require.True(t, yielded)
+		require.Equal(t, u64(uint64(i+1)), v.Value())
+	}
+
+	v, yielded, err := th.Resume(co, vm.Slot{})
+	require.NoError(t, err)
+	require.False(t, yielded)
+	require.Equal(t, u64(4), v.Value())
+	require.Empty(t, th.Stack)
+
+	_, _, err = th.Resume(co, vm.Slot{})
+	require.ErrorIs(t, err, vm.ErrNotResumable)
+
+	_, _, err = th.Resume(vm.UnsignedSlot(1), vm.Slot{})
+	require.ErrorIs(t, err, vm.ErrUnexpectedType)
+
+	t.Run("Collect", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: []uint8{opPush, 0x81, opCoYield}}
+		th := m.Spawn(0)
+		co, err := th.NewCoroutine(0)
+		require.NoError(t, err)
+
+		value, err := th.New(&cell{})
+		require.NoError(t, err)
+
+		_, yielded, err := th.Resume(co, value)
+		require.NoError(t, err)
+		require.True(t, yielded)
+
+		th.Stack = []vm.Slot{co}
+		require.Equal(t, 0, m.Collect())
+
+		th.Stack = nil
+		require.Equal(t, 2, m.Collect())
+	})
+}
+
+func TestGenerator(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.StackValue
+		errval   testerr.ExpectedError
+	}{
+		{"Counter", counter, []types.StackValue{u64(1), u64(2), u64(3)}, testerr.Nil()},
+		{"Empty", []uint8{opReturn, 0x00}, nil, testerr.Nil()},
+		{"Throw", []uint8{opPush, 0x81, opCoYield, opPush, 0x82, opThrow}, []types.StackValue{u64(1)},
+			testerr.Is(vm.ErrUncaughtException)},
+		{"Fault", []uint8{opPush, 0x81, opCoYield}, []types.StackValue{u64(1)},
+			testerr.Is(vm.ErrBytecodeOverflow)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data}
+			th := m.Spawn(0)
+			co, err := th.NewCoroutine(0)
+			require.NoError(t, err)
+
+			g := th.Generator(co)
+			require.Equal(t, test.expected, slices.Collect(g.All()))
+			test.errval.Require(t, g.Err())
+			require.Empty(t, th.Stack)
+		})
+	}
+
+	t.Run("Break", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: counter}
+		th := m.Spawn(0)
+		co, err := th.NewCoroutine(0)
+		require.NoError(t, err)
+
+		g := th.Generator(co)
+		for v := range g.All() {
+			require.Equal(t, u64(1), v)
+			break
+		}
+
+		require.Equal(t, []types.StackValue{u64(2), u64(3)}, slices.Collect(g.All()))
+		require.NoError(t, g.Err())
+	})
+}
@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	// canonicalNaN is the bits of the NaN which replaces every NaN result of
+	// float arithmetic on a deterministic machine.
+	canonicalNaN = 0x7FF8000000000000
+
+	// randomGamma is the increment of the SplitMix64 generators of threads.
+	randomGamma = 0x9E3779B97F4A7C15
+)
+
+// deterministic returns if the thread belongs to a deterministic machine.
+func (t *Thread) deterministic() bool {
+	return t.Machine != nil && t.Machine.Deterministic
+}
+
+// canonical returns the given result of arithmetic, replacing a NaN with the
+// canonical NaN if the machine is deterministic. The bits of a NaN otherwise
+// depend on the operands and on the host.
+func (t *Thread) canonical(s Slot) Slot {
+	if s.Type == typeid.Float64 && math.IsNaN(s.Float()) && t.deterministic() {
+		s.Bits = canonicalNaN
+	}
+
+	return s
+}
+
+// opRandom pushes a random uint64, taken from the generator of the thread if
+// the machine is deterministic.
+func (t *Thread) opRandom() error {
+	if err := t.grow(); err != nil {
+		return err
+	}
+
+	if t.deterministic() {
+		t.push(UnsignedSlot(t.nextRandom()))
+	} else {
+		t.push(UnsignedSlot(rand.Uint64()))
+	}
+
+	return nil
+}
+
+// opTime pushes the time as an int64 count of nanoseconds since the Unix
+// epoch, which is the virtual clock if the machine is deterministic.
+func (t *Thread) opTime() error {
+	if err := t.grow(); err != nil {
+		return err
+	}
+
+	if t.deterministic() {
+		t.push(SignedSlot(t.Machine.Clock))
+	} else {
+		t.push(SignedSlot(time.Now().UnixNano()))
+	}
+
+	return nil
+}
+
+// nextRandom returns the next number of the random generator of the thread.
+func (t *Thread) nextRandom() uint64 {
+	t.random = t.randomState() + randomGamma
+
+	return mixRandom(t.random)
+}
+
+// randomState returns the state of the random generator of the thread, which
+// is seeded from the seed of the machine and the ID of the thread when it is
+// first used.
+func (t *Thread) randomState() uint64 {
+	if !t.seeded {
+		var seed uint64
+		if t.Machine != nil {
+			seed = t.Machine.Seed
+		}
+
+		t.random, t.seeded = mixRandom(seed+uint64(t.ID)*randomGamma), true
+	}
+
+	return t.random
+}
+
+// mixRandom returns the output of a SplitMix64 generator with the given state.
+func mixRandom(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,104 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestMachineDeterministic(t *testing.T) {
+	t.Parallel()
+
+	// randoms runs two threads which each draw three random numbers on a
+	// machine with the given seed, returning the numbers drawn by each.
+	randoms := func(t *testing.T, seed uint64, workers int) [][]types.Value {
+		t.Helper()
+
+		m := &vm.Machine{
+			Data:          []uint8{opRandom, opYield, opRandom, opYield, opRandom},
+			Workers:       workers,
+			Deterministic: true,
+			Seed:          seed,
+		}
+		threads := []*vm.Thread{m.Spawn(0), m.Spawn(0)}
+		require.NoError(t, m.Run())
+
+		var drawn [][]types.Value
+		for _, th := range threads {
+			require.NoError(t, th.Err)
+			drawn = append(drawn, vm.Values(th.Stack))
+		}
+
+		return drawn
+	}
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		drawn := randoms(t, 42, 1)
+		require.Len(t, drawn[0], 3)
+		require.NotEqual(t, drawn[0], drawn[1])
+		require.Equal(t, drawn, randoms(t, 42, 4))
+		require.NotEqual(t, drawn, randoms(t, 43, 1))
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		data := []uint8{opRandom, opRandom}
+		m := &vm.Machine{Data: data, Deterministic: true, Seed: 42}
+		th := m.Spawn(0)
+		require.NoError(t, th.Step())
+
+		snapshot, err := th.Snapshot()
+		require.NoError(t, err)
+
+		restored := &vm.Machine{Data: data, Deterministic: true, Seed: 7}
+		copied, err := restored.RestoreThread(snapshot)
+		require.NoError(t, err)
+
+		require.NoError(t, m.Run())
+		require.NoError(t, restored.Run())
+		require.Equal(t, vm.Values(th.Stack), vm.Values(copied.Stack))
+	})
+
+	t.Run("Time", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: []uint8{opTime}, Deterministic: true, Clock: 1_000_000_007}
+		th := m.Spawn(0)
+		require.NoError(t, m.Run())
+		require.Equal(t, vals(i64(1_000_000_007)), vm.Values(th.Stack))
+
+		m = &vm.Machine{Data: []uint8{opTime}}
+		th = m.Spawn(0)
+		require.NoError(t, m.Run())
+		require.Equal(t, typeid.Int64, th.Stack[0].Type)
+		require.Positive(t, th.Stack[0].Signed())
+	})
+
+	t.Run("NaN", func(t *testing.T) {
+		t.Parallel()
+
+		// Push 0.0, Dupe 0, Div, Push 1.0, Mod
+		data := []uint8{opPush, 0x24, 0, 0, 0, 0, opDupe, 0x00, opDiv, 0x00, opPush, 0x24, 0x3F, 0x80, 0, 0, opMod, 0x00}
+		for _, specialize := range []bool{false, true} {
+			m := &vm.Machine{Data: data, Program: vm.Decode(data), Deterministic: true}
+			if specialize {
+				require.True(t, m.Program.Specialize(8, typeid.Float64))
+				require.True(t, m.Program.Specialize(16, typeid.Float64))
+			}
+
+			th := m.Spawn(0)
+			require.NoError(t, m.Run())
+			require.NoError(t, th.Err)
+			require.Len(t, th.Stack, 1)
+			require.Equal(t, uint64(0x7FF8000000000000), th.Stack[0].Bits)
+			require.True(t, math.IsNaN(th.Stack[0].Float()))
+		}
+	})
+}
@@ -4,6 +4,9 @@ import (
 	"strconv"
 
 	"github.com/tvarney/consterr"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
 )
 
 const (
@@ -47,3 +50,388 @@ func (e ImmediateFetchSizeError) Error() string {
 func (e ImmediateFetchSizeError) Unwrap() error {
 	return ErrInvalidImmediateSize
 }
+
+const (
+	// ErrStackUnderflow indicates that an opcode attempted to remove more
+	// values than the current stack frame holds.
+	ErrStackUnderflow consterr.Error = "stack underflow"
+
+	// ErrInvalidControl indicates that an opcode was given a control byte it
+	// does not define.
+	ErrInvalidControl consterr.Error = "invalid control byte"
+
+	// ErrFrameUnderflow indicates that a Return opcode was run without an
+	// active call frame.
+	ErrFrameUnderflow consterr.Error = "no frame to return from"
+
+	// ErrUnexpectedType indicates that an opcode was given a value of a type
+	// it can not operate on.
+	ErrUnexpectedType consterr.Error = "unexpected value type"
+
+	// ErrArithmeticOverflow indicates that the result of a trapping arithmetic
+	// opcode did not fit in its type.
+	ErrArithmeticOverflow consterr.Error = "arithmetic overflow"
+
+	// ErrInexactCast indicates that a checked Cast of a float to an integer
+	// type would have discarded its fractional part.
+	ErrInexactCast consterr.Error = "inexact cast"
+
+	// ErrUncaughtException indicates that a value was thrown and no handler
+	// covering the throw site was found.
+	ErrUncaughtException consterr.Error = "uncaught exception"
+
+	// ErrNotCompilable indicates that a function of a program could not be
+	// compiled.
+	ErrNotCompilable consterr.Error = "function can not be compiled"
+
+	// ErrOutOfFuel indicates that a metered thread did not have enough fuel
+	// left to run its next opcode.
+	ErrOutOfFuel consterr.Error = "out of fuel"
+
+	// ErrInterrupted indicates that a thread was stopped because the context
+	// it was run with was done.
+	ErrInterrupted consterr.Error = "interrupted"
+
+	// ErrStackOverflow indicates that an opcode would have pushed more slots
+	// than the stack limit of its thread allows.
+	ErrStackOverflow consterr.Error = "stack limit exceeded"
+
+	// ErrFrameOverflow indicates that a Call would have made more frames
+	// active than the frame limit of its thread allows.
+	ErrFrameOverflow consterr.Error = "frame limit exceeded"
+
+	// ErrHeapExhausted indicates that an allocation would have exceeded the
+	// heap limit of a thread or its machine.
+	ErrHeapExhausted consterr.Error = "heap limit exceeded"
+
+	// ErrNoMachine indicates that a thread without a machine attempted to use
+	// state held by the machine, such as its heap.
+	ErrNoMachine consterr.Error = "thread has no machine"
+
+	// ErrNotReference indicates that a slot which does not refer to a heap
+	// object was used where a reference was required.
+	ErrNotReference consterr.Error = "value is not a heap reference"
+
+	// ErrBlocked indicates that a thread stopped at a Join as the thread it
+	// joins is not done. Running the thread again retries the Join.
+	ErrBlocked consterr.Error = "thread is blocked"
+
+	// ErrNoThread indicates that a Join was given the ID of a thread which
+	// the machine did not spawn.
+	ErrNoThread consterr.Error = "no such thread"
+
+	// ErrDeadlock indicates that threads are blocked waiting for each other
+	// and none of them can run.
+	ErrDeadlock consterr.Error = "deadlock"
+
+	// ErrClosed indicates that a value was sent on a closed channel, or that
+	// a closed channel was closed again.
+	ErrClosed consterr.Error = "channel is closed"
+
+	// ErrInvalidCapacity indicates that a channel was created with a negative
+	// or too large capacity.
+	ErrInvalidCapacity consterr.Error = "invalid channel capacity"
+
+	// ErrNotResumable indicates that a coroutine which is running or has
+	// returned was resumed.
+	ErrNotResumable consterr.Error = "coroutine can not be resumed"
+
+	// ErrNotCoroutine indicates that a CoYield was run outside of a coroutine.
+	ErrNotCoroutine consterr.Error = "not in a coroutine"
+
+	// ErrNoGlobal indicates that a global variable which the machine does not
+	// declare was used.
+	ErrNoGlobal consterr.Error = "no such global"
+
+	// ErrNoLocal indicates that a local variable was used which is not a slot
+	// of the current frame.
+	ErrNoLocal consterr.Error = "no such local"
+
+	// ErrInvalidSnapshot indicates that a thread snapshot could not be read.
+	ErrInvalidSnapshot consterr.Error = "invalid snapshot"
+
+	// ErrSnapshotVersion indicates that a thread snapshot was written in a
+	// version of the format which can not be read.
+	ErrSnapshotVersion consterr.Error = "unsupported snapshot version"
+
+	// ErrModuleMismatch indicates that a thread snapshot was restored onto a
+	// machine whose bytecode, handlers, host functions or globals differ from
+	// those of the machine it was taken on.
+	ErrModuleMismatch consterr.Error = "snapshot is of a different module"
+
+	// ErrNotSerializable indicates that a thread snapshot could not be taken
+	// as the thread holds a value which can not be serialized.
+	ErrNotSerializable consterr.Error = "value can not be serialized"
+
+	// ErrNoFunction indicates that a host function which the machine does not
+	// declare was called.
+	ErrNoFunction consterr.Error = "no such host function"
+
+	// ErrNondeterministic indicates that a deterministic machine called a host
+	// function which is not deterministic.
+	ErrNondeterministic consterr.Error = "host function is not deterministic"
+)
+
+// LimitError is an error which indicates that a memory limit of a thread or
+// machine would have been exceeded.
+//
+// Err is ErrStackOverflow, ErrFrameOverflow or ErrHeapExhausted, and Limit is
+// the limit which would have been exceeded.
+type LimitError struct {
+	Err   error
+	Limit int
+}
+
+func (e LimitError) Error() string {
+	return e.Err.Error() + ": limit is " + strconv.FormatInt(int64(e.Limit), 10)
+}
+
+func (e LimitError) Unwrap() error {
+	return e.Err
+}
+
+// DeadlockError is an error which indicates that every spawned thread of a
+// machine which is not done is blocked. Blocked describes what each of them is
+// waiting for.
+type DeadlockError struct {
+	Blocked []Blocked
+}
+
+func (e DeadlockError) Error() string {
+	msg := string(ErrDeadlock) + ":"
+	for i, b := range e.Blocked {
+		if i > 0 {
+			msg += ";"
+		}
+
+		msg += " thread " + strconv.Itoa(b.Thread) + " blocked in " + b.Op.String() +
+			" at 0x" + strconv.FormatInt(int64(b.Offset), 16)
+		if b.Join != 0 {
+			msg += " on thread " + strconv.Itoa(b.Join)
+		}
+
+		for j, handle := range b.Channels {
+			if j == 0 {
+				msg += " on channel "
+			} else {
+				msg += ", "
+			}
+
+			msg += strconv.FormatUint(uint64(handle), 10)
+		}
+	}
+
+	return msg
+}
+
+func (e DeadlockError) Unwrap() error {
+	return ErrDeadlock
+}
+
+// InterruptedError is an error which indicates that a thread was stopped
+// before running the opcode at Offset because its context was done.
+//
+// Err is the error of the context.
+type InterruptedError struct {
+	Offset int
+	Err    error
+}
+
+func (e InterruptedError) Error() string {
+	return string(ErrInterrupted) + " at 0x" + strconv.FormatInt(int64(e.Offset), 16) + ": " + e.Err.Error()
+}
+
+func (e InterruptedError) Unwrap() []error {
+	return []error{ErrInterrupted, e.Err}
+}
+
+// OutOfFuelError is an error which indicates that the opcode at Offset costs
+// more than the Fuel a metered thread had left, and was not run.
+type OutOfFuelError struct {
+	Offset int
+	Fuel   uint64
+	Cost   uint64
+}
+
+func (e OutOfFuelError) Error() string {
+	return string(ErrOutOfFuel) + " at 0x" + strconv.FormatInt(int64(e.Offset), 16) + ": cost " +
+		strconv.FormatUint(e.Cost, 10) + ", " + strconv.FormatUint(e.Fuel, 10) + " left"
+}
+
+func (e OutOfFuelError) Unwrap() error {
+	return ErrOutOfFuel
+}
+
+// CompileError is an error which indicates that the function at Entry could
+// not be compiled as no decoded instruction starts at Offset.
+type CompileError struct {
+	Entry  int
+	Offset int
+}
+
+func (e CompileError) Error() string {
+	return string(ErrNotCompilable) + ": function at 0x" + strconv.FormatInt(int64(e.Entry), 16) +
+		" reaches undecoded offset 0x" + strconv.FormatInt(int64(e.Offset), 16)
+}
+
+func (e CompileError) Unwrap() error {
+	return ErrNotCompilable
+}
+
+// InvalidControlError is an error which indicates that an opcode was given a
+// control byte it does not define.
+type InvalidControlError struct {
+	Op      opcode.ID
+	Control uint8
+}
+
+func (e InvalidControlError) Error() string {
+	return string(ErrInvalidControl) + " 0x" + strconv.FormatUint(uint64(e.Control), 16) +
+		" for opcode 0x" + strconv.FormatUint(uint64(e.Op), 16)
+}
+
+func (e InvalidControlError) Unwrap() error {
+	return ErrInvalidControl
+}
+
+// UnexpectedTypeError is an error which indicates that an opcode was given a
+// value of a type it can not operate on.
+type UnexpectedTypeError struct {
+	ID typeid.ID
+}
+
+func (e UnexpectedTypeError) Error() string {
+	return string(ErrUnexpectedType) + ": got " + e.ID.String()
+}
+
+func (e UnexpectedTypeError) Unwrap() error {
+	return ErrUnexpectedType
+}
+
+// SnapshotVersionError is an error which indicates that a thread snapshot was
+// written in the given version of the format, which can not be read.
+type SnapshotVersionError struct {
+	Version int
+}
+
+func (e SnapshotVersionError) Error() string {
+	return string(ErrSnapshotVersion) + " " + strconv.Itoa(e.Version) + ": expected " +
+		strconv.Itoa(SnapshotVersion)
+}
+
+func (e SnapshotVersionError) Unwrap() error {
+	return ErrSnapshotVersion
+}
+
+// NotSerializableError is an error which indicates that a thread snapshot could
+// not be taken as the thread holds a value of the given type which can not be
+// serialized.
+type NotSerializableError struct {
+	ID typeid.ID
+}
+
+func (e NotSerializableError) Error() string {
+	return string(ErrNotSerializable) + ": " + e.ID.String()
+}
+
+func (e NotSerializableError) Unwrap() error {
+	return ErrNotSerializable
+}
+
+// NondeterministicError is an error which indicates that a deterministic
+// machine called the host function with the given name, which is not
+// deterministic.
+type NondeterministicError struct {
+	Name string
+}
+
+func (e NondeterministicError) Error() string {
+	return string(ErrNondeterministic) + ": " + e.Name
+}
+
+func (e NondeterministicError) Unwrap() error {
+	return ErrNondeterministic
+}
+
+// ArithmeticOverflowError is an error which indicates that the result of an
+// arithmetic opcode in trapping mode did not fit in its type.
+//
+// Left is the value which was on the top of the stack and Right the value
+// below it.
+type ArithmeticOverflowError struct {
+	Op    opcode.ID
+	Left  types.Value
+	Right types.Value
+}
+
+func (e ArithmeticOverflowError) Error() string {
+	return string(ErrArithmeticOverflow) + " in " + e.Op.String() + " of " +
+		formatValue(e.Left) + " and " + formatValue(e.Right)
+}
+
+func (e ArithmeticOverflowError) Unwrap() error {
+	return ErrArithmeticOverflow
+}
+
+// InexactCastError is an error which indicates that a checked Cast of a float
+// to an integer type would have discarded its fractional part.
+type InexactCastError struct {
+	Value types.Value
+	To    typeid.ID
+}
+
+func (e InexactCastError) Error() string {
+	return string(ErrInexactCast) + " of " + formatValue(e.Value) + " to " + e.To.String()
+}
+
+func (e InexactCastError) Unwrap() error {
+	return ErrInexactCast
+}
+
+// UncaughtExceptionError is an error which indicates that a value was thrown
+// and not caught by any handler.
+//
+// Trace holds the offset of the opcode which raised the exception followed by
+// the offset of the Call opcode for each active frame, innermost first. The
+// thread is left in the state it was in when the exception was raised.
+type UncaughtExceptionError struct {
+	Value types.Value
+	Trace []int
+}
+
+func (e UncaughtExceptionError) Error() string {
+	msg := string(ErrUncaughtException)
+	if len(e.Trace) > 0 {
+		msg += " at 0x" + strconv.FormatInt(int64(e.Trace[0]), 16)
+	}
+
+	if err, ok := e.Value.(types.Error); ok {
+		return msg + ": " + err.Error()
+	}
+
+	return msg + ": " + formatValue(e.Value)
+}
+
+func (e UncaughtExceptionError) Unwrap() []error {
+	if err, ok := e.Value.(types.Error); ok && err.Err != nil {
+		return []error{ErrUncaughtException, err.Err}
+	}
+
+	return []error{ErrUncaughtException}
+}
+
+// formatValue returns a description of the given value for use in an error.
+func formatValue(v types.Value) string {
+	switch n := v.(type) {
+	case types.Uint64:
+		return "uint64 " + strconv.FormatUint(uint64(n), 10)
+	case types.Int64:
+		return "int64 " + strconv.FormatInt(int64(n), 10)
+	case types.Float64:
+		return "float64 " + strconv.FormatFloat(float64(n), 'g', -1, 64)
+	case nil:
+		return "nil"
+	default:
+		return n.ID().String() + " value"
+	}
+}
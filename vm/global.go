@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	varSizeMask = 0x0F
+)
+
+// Global declares a global variable of a machine.
+//
+// A global holds values of one type, and starts as the zero value of that
+// type. A global with the void type holds values of any type.
+type Global struct {
+	Name string
+	Type typeid.ID
+}
+
+// Global returns the value of the global variable with the given name, or
+// false if the machine declares no such global.
+func (m *Machine) Global(name string) (Slot, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index, ok := m.globalIndex(name)
+	if !ok {
+		return Slot{}, false
+	}
+
+	return m.globalSlots()[index], true
+}
+
+// SetGlobal sets the value of the global variable with the given name.
+//
+// If the machine declares no such global ErrNoGlobal is returned, and if the
+// value does not have the type of the global an UnexpectedTypeError is
+// returned. A numeric value is narrowed to the type of the global, and if it
+// is out of its range a types.OverflowError is returned.
+func (m *Machine) SetGlobal(name string, v Slot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index, ok := m.globalIndex(name)
+	if !ok {
+		return ErrNoGlobal
+	}
+
+	return m.storeGlobal(index, v)
+}
+
+// TraceRoots visits the handle of every heap object referred to by a global
+// variable of the machine.
+func (m *Machine) TraceRoots(visit func(Handle)) {
+	traceSlots(m.globals, visit)
+}
+
+// globalIndex returns the index of the global with the given name.
+func (m *Machine) globalIndex(name string) (int, bool) {
+	for i, g := range m.Globals {
+		if g.Name == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// globalSlots returns the values of the globals of the machine, setting each
+// to its zero value the first time it is called. The machine must be locked.
+func (m *Machine) globalSlots() []Slot {
+	if len(m.globals) != len(m.Globals) {
+		m.globals = make([]Slot, len(m.Globals))
+		for i, g := range m.Globals {
+			m.globals[i] = zeroSlot(g.Type)
+		}
+	}
+
+	return m.globals
+}
+
+// storeGlobal sets the value of the global with the given index. The machine
+// must be locked.
+func (m *Machine) storeGlobal(index int, v Slot) error {
+	slots := m.globalSlots()
+	if index >= len(slots) {
+		return ErrNoGlobal
+	}
+
+	v, err := narrow(m.Globals[index].Type, v)
+	if err != nil {
+		return err
+	}
+
+	slots[index] = v
+
+	return nil
+}
+
+// decodeVar reads the control byte and slot index of a global or local
+// variable opcode, or the control byte and function index of a CallHost, whose
+// high nibble is the number of arguments rather than 0.
+func (t *Thread) decodeVar(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	size := control & varSizeMask
+	if (ins.Op != opcode.CallHost && control&^varSizeMask != 0) || size < 1 || size > 2 {
+		return InvalidControlError{Op: ins.Op, Control: control}
+	}
+
+	ins.Target, err = t.fetchTarget(ins.Op, control)
+
+	return err
+}
+
+// opGlobal loads or stores the global with the index of the instruction.
+func (t *Thread) opGlobal(ins *Instruction) error {
+	m := t.Machine
+	if m == nil {
+		return ErrNoMachine
+	}
+
+	if ins.Op == opcode.LoadGlobal {
+		if err := t.grow(); err != nil {
+			return err
+		}
+	} else if len(t.Stack) <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ins.Op == opcode.StoreGlobal {
+		if err := m.storeGlobal(ins.Target, t.Stack[len(t.Stack)-1]); err != nil {
+			return err
+		}
+
+		t.Stack = t.Stack[:len(t.Stack)-1]
+
+		return nil
+	}
+
+	slots := m.globalSlots()
+	if ins.Target >= len(slots) {
+		return ErrNoGlobal
+	}
+
+	t.push(slots[ins.Target])
+
+	return nil
+}
+
+// opLocal loads or stores the local with the index of the instruction, which
+// is the slot of the current frame at that index from its base.
+func (t *Thread) opLocal(ins *Instruction) error {
+	base := t.FrameBase()
+	if ins.Op == opcode.LoadLocal {
+		if base+ins.Target >= len(t.Stack) {
+			return ErrNoLocal
+		}
+
+		if err := t.grow(); err != nil {
+			return err
+		}
+
+		t.push(t.Stack[base+ins.Target])
+
+		return nil
+	}
+
+	if len(t.Stack) <= base {
+		return ErrStackUnderflow
+	}
+
+	if base+ins.Target >= len(t.Stack)-1 {
+		return ErrNoLocal
+	}
+
+	t.Stack[base+ins.Target] = t.Stack[len(t.Stack)-1]
+	t.Stack = t.Stack[:len(t.Stack)-1]
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opLoadGlobal  = uint8(opcode.LoadGlobal)
+	opStoreGlobal = uint8(opcode.StoreGlobal)
+	opLoadLocal   = uint8(opcode.LoadLocal)
+	opStoreLocal  = uint8(opcode.StoreLocal)
+)
+
+// globals are the globals declared by the machines of the global tests.
+var globals = []vm.Global{
+	{Name: "count", Type: typeid.Uint16},
+	{Name: "any", Type: typeid.Void},
+	{Name: "ratio", Type: typeid.Float64},
+}
+
+func TestThreadGlobal(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Zero", []uint8{opLoadGlobal, 0x01, 0x00, opLoadGlobal, 0x01, 0x01, opLoadGlobal, 0x01, 0x02},
+			vals(u64(0), nil, f64(0)), testerr.Nil()},
+		{"Store", []uint8{opPush, 0x85, opStoreGlobal, 0x01, 0x00, opLoadGlobal, 0x01, 0x00},
+			vals(u64(5)), testerr.Nil()},
+		{"StoreAny", []uint8{opPush, 0x11, 0xFF, opStoreGlobal, 0x01, 0x01, opLoadGlobal, 0x01, 0x01},
+			vals(i64(-1)), testerr.Nil()},
+		{"U16", []uint8{opLoadGlobal, 0x02, 0x00, 0x02}, vals(f64(0)), testerr.Nil()},
+		{"StoreType", []uint8{opPush, 0x11, 0xFF, opStoreGlobal, 0x01, 0x00}, vals(i64(-1)),
+			testerr.Is(vm.ErrUnexpectedType)},
+		{"StoreRange", []uint8{opPush, 0x03, 0x01, 0x00, 0x00, opStoreGlobal, 0x01, 0x00}, vals(u64(0x10000)),
+			testerr.Is(types.OverflowError{From: typeid.Uint64, To: typeid.Uint16})},
+		{"StoreUnderflow", []uint8{opStoreGlobal, 0x01, 0x00}, []types.Value{}, testerr.Is(vm.ErrStackUnderflow)},
+		{"NoGlobal", []uint8{opLoadGlobal, 0x01, 0x03}, []types.Value{}, testerr.Is(vm.ErrNoGlobal)},
+		{"StoreNoGlobal", []uint8{opPush, 0x81, opStoreGlobal, 0x02, 0x01, 0x00}, vals(u64(1)),
+			testerr.Is(vm.ErrNoGlobal)},
+		{"Control", []uint8{opLoadGlobal, 0x03, 0x00, 0x00, 0x00}, []types.Value{},
+			testerr.Is(vm.InvalidControlError{Op: opcode.LoadGlobal, Control: 0x03})},
+		{"ControlType", []uint8{opStoreGlobal, 0x11, 0x00}, []types.Value{},
+			testerr.Is(vm.InvalidControlError{Op: opcode.StoreGlobal, Control: 0x11})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data, Globals: globals}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run())
+			test.errval.Require(t, th.Err)
+			require.Equal(t, test.expected, vm.Values(th.Stack))
+		})
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 9, StoreGlobal 0, Jump 0xFF
+		// 0x08: Push 1, Join, LoadGlobal 0
+		m := &vm.Machine{Data: []uint8{
+			opPush, 0x89, opStoreGlobal, 0x01, 0x00, opJump, 0x01, 0xFF,
+			opPush, 0x81, opJoin, opLoadGlobal, 0x01, 0x00,
+		}, Globals: globals}
+		m.Spawn(0)
+		th := m.Spawn(8)
+		require.NoError(t, m.Run())
+		require.NoError(t, th.Err)
+		require.Equal(t, vals(u64(9)), vm.Values(th.Stack))
+
+		v, ok := m.Global("count")
+		require.True(t, ok)
+		require.Equal(t, u64(9), v.Value())
+	})
+
+	t.Run("NoMachine", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opLoadGlobal, 0x01, 0x00}}
+		require.ErrorIs(t, th.Run(), vm.ErrNoMachine)
+	})
+}
+
+func TestMachineGlobal(t *testing.T) {
+	t.Parallel()
+
+	m := &vm.Machine{Globals: globals}
+
+	v, ok := m.Global("ratio")
+	require.True(t, ok)
+	require.Equal(t, vm.FloatSlot(0), v)
+
+	_, ok = m.Global("missing")
+	require.False(t, ok)
+
+	require.NoError(t, m.SetGlobal("ratio", vm.FloatSlot(0.5)))
+	v, _ = m.Global("ratio")
+	require.Equal(t, vm.FloatSlot(0.5), v)
+
+	require.ErrorIs(t, m.SetGlobal("ratio", vm.UnsignedSlot(1)), vm.ErrUnexpectedType)
+	require.ErrorIs(t, m.SetGlobal("count", vm.UnsignedSlot(0x10000)),
+		types.OverflowError{From: typeid.Uint64, To: typeid.Uint16})
+	require.ErrorIs(t, m.SetGlobal("missing", vm.UnsignedSlot(1)), vm.ErrNoGlobal)
+
+	t.Run("Collect", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Globals: globals}
+		value, err := (&vm.Thread{Machine: m}).New(&cell{})
+		require.NoError(t, err)
+
+		require.NoError(t, m.SetGlobal("any", value))
+		require.Equal(t, 0, m.Collect())
+
+		require.NoError(t, m.SetGlobal("any", vm.Slot{}))
+		require.Equal(t, 1, m.Collect())
+	})
+}
+
+func TestThreadLocal(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Load", []uint8{opPush, 0x81, opPush, 0x82, opLoadLocal, 0x01, 0x00}, vals(u64(1), u64(2), u64(1)),
+			testerr.Nil()},
+		{"Store", []uint8{opPush, 0x81, opPush, 0x82, opStoreLocal, 0x01, 0x00}, vals(u64(2)), testerr.Nil()},
+		{
+			// 0x00: Push 7, Push 1, Push 2, Call 0x0C with 2 args, Jump 0xFF
+			// 0x0C: LoadLocal 1, Return 1
+			"Frame",
+			[]uint8{
+				opPush, 0x87, opPush, 0x81, opPush, 0x82, opCall, 0x21, 0x0C, opJump, 0x01, 0xFF,
+				opLoadLocal, 0x01, 0x01, opReturn, 0x01,
+			},
+			vals(u64(7), u64(2)),
+			testerr.Nil(),
+		},
+		{"NoLocal", []uint8{opPush, 0x81, opLoadLocal, 0x01, 0x01}, vals(u64(1)), testerr.Is(vm.ErrNoLocal)},
+		{"StoreNoLocal", []uint8{opPush, 0x81, opStoreLocal, 0x01, 0x00}, vals(u64(1)), testerr.Is(vm.ErrNoLocal)},
+		{"StoreUnderflow", []uint8{opStoreLocal, 0x01, 0x00}, []types.Value{}, testerr.Is(vm.ErrStackUnderflow)},
+		{"Control", []uint8{opLoadLocal, 0x00}, []types.Value{},
+			testerr.Is(vm.InvalidControlError{Op: opcode.LoadLocal, Control: 0x00})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run())
+			test.errval.Require(t, th.Err)
+			require.Equal(t, test.expected, vm.Values(th.Stack))
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package vm
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/tvarney/illvm/types"
+)
+
+// Handle refers to an object on the heap of a machine.
+//
+// A reference to a heap object is held on the stack as a slot with the ID of
+// the object's type and the handle in Bits. The zero Handle refers to no
+// object.
+type Handle uint32
+
+// Tracer is implemented by heap values which hold references to other heap
+// objects, so that the collector keeps them alive.
+type Tracer interface {
+	Trace(visit func(Handle))
+}
+
+// Roots is implemented by anything holding references to heap objects which
+// must be kept alive, such as the stack of a thread, module globals and
+// constants.
+type Roots interface {
+	TraceRoots(visit func(Handle))
+}
+
+// HeapStats holds statistics about a heap.
+type HeapStats struct {
+	// Objects is the number of objects on the heap.
+	Objects int
+
+	// LiveBytes is the size of the objects on the heap.
+	LiveBytes int
+
+	// Collections is the number of collections which have run.
+	Collections int
+
+	// Freed is the number of objects freed by all collections.
+	Freed int
+
+	// FreedBytes is the size of the objects freed by all collections.
+	FreedBytes int
+}
+
+// Heap holds the reference values of a machine, independent of the garbage
+// collector of Go.
+//
+// Objects are freed by a tracing mark-sweep collector run by Machine.Collect,
+// rooted in the Roots registered with the heap. Handles of freed objects are
+// reused, lowest first, so running the same program always assigns the same
+// handles.
+//
+// The zero Heap is empty and ready to use. A heap is safe for concurrent use.
+type Heap struct {
+	mu      sync.Mutex
+	objects []heapObject
+	free    []Handle
+	roots   []Roots
+	stats   HeapStats
+}
+
+// heapObject is a single entry of a heap. An entry with a nil value is free.
+type heapObject struct {
+	value     types.Value
+	owner     *Thread
+	finalizer Finalizer
+	marked    bool
+}
+
+// Finalizer is called with the value of a heap object once it has been
+// collected.
+type Finalizer func(v types.Value)
+
+// finalization is a finalizer waiting to be called after a collection.
+type finalization struct {
+	fn    Finalizer
+	value types.Value
+}
+
+// Heap returns the heap of the machine.
+func (m *Machine) Heap() *Heap {
+	return &m.heap
+}
+
+// New places the given value on the heap of the machine and returns its
+// handle.
+//
+// The size of the value is accounted against the heap limit of the machine; if
+// it would be exceeded a LimitError wrapping ErrHeapExhausted is returned.
+func (m *Machine) New(v types.Value) (Handle, error) {
+	size := int64(v.Size())
+	if used := m.heapUsed.Add(size); m.HeapLimit > 0 && used > int64(m.HeapLimit) {
+		m.heapUsed.Add(-size)
+		return 0, LimitError{Err: ErrHeapExhausted, Limit: m.HeapLimit}
+	}
+
+	return m.heap.alloc(v, nil), nil
+}
+
+// New places the given value on the heap of the thread's machine and returns
+// a slot referring to it.
+//
+// The size of the value is accounted against the heap limits of the thread
+// and machine as by Allocate. If the thread has no machine ErrNoMachine is
+// returned.
+func (t *Thread) New(v types.Value) (Slot, error) {
+	if t.Machine == nil {
+		return Slot{}, ErrNoMachine
+	}
+
+	if err := t.Allocate(v); err != nil {
+		return Slot{}, err
+	}
+
+	return RefSlot(t.Machine.heap.alloc(v, t), v.ID()), nil
+}
+
+// TraceRoots visits the handle of every heap object referred to by the stack
+// of the thread, including the running coroutines and the stacks they were
+// resumed from, and by a value handed to the thread which it has not yet
+// received along with its channel.
+func (t *Thread) TraceRoots(visit func(Handle)) {
+	traceSlots(t.Stack, visit)
+
+	if t.delivered != nil {
+		visit(t.delivered.ch.handle)
+		traceSlots([]Slot{t.delivered.value}, visit)
+	}
+
+	for c := t.coro; c != nil; c = c.caller {
+		visit(c.handle)
+		traceSlots(c.resumer.stack, visit)
+	}
+}
+
+// result roots the values a thread left on its stack when it was done.
+type result struct {
+	values []Slot
+}
+
+// TraceRoots visits the handle of every heap object referred to by the result.
+func (r *result) TraceRoots(visit func(Handle)) {
+	traceSlots(r.values, visit)
+}
+
+// Collect frees every object on the heap of the machine which can not be
+// reached from the roots registered with the heap, returning the number of
+// objects freed.
+//
+// Weak references to freed objects are cleared, then the finalizers of the
+// freed objects are called in handle order once the collection is complete.
+// The size of each freed object is released from the heap usage of the thread
+// which allocated it and of the machine. Collect must not be called while any
+// thread of the machine is running.
+func (m *Machine) Collect() int {
+	freed, finalizers := m.heap.collect(m)
+	for _, f := range finalizers {
+		f.fn(f.value)
+	}
+
+	return freed
+}
+
+// SetFinalizer sets the function called with the value of the heap object with
+// the given handle once it is collected, replacing any finalizer it already
+// has. A nil function removes the finalizer.
+//
+// If no such object is on the heap false is returned.
+func (m *Machine) SetFinalizer(handle Handle, fn Finalizer) bool {
+	h := &m.heap
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if handle == 0 || int(handle) > len(h.objects) || h.objects[handle-1].value == nil {
+		return false
+	}
+
+	h.objects[handle-1].finalizer = fn
+
+	return true
+}
+
+func (h *Heap) alloc(v types.Value, owner *Thread) Handle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stats.Objects++
+	h.stats.LiveBytes += v.Size()
+
+	obj := heapObject{value: v, owner: owner, finalizer: nil, marked: false}
+	if n := len(h.free); n > 0 {
+		handle := h.free[n-1]
+		h.free = h.free[:n-1]
+		h.objects[handle-1] = obj
+
+		return handle
+	}
+
+	h.objects = append(h.objects, obj)
+
+	return Handle(len(h.objects))
+}
+
+// Get returns the value of the object with the given handle, or false if no
+// such object is on the heap.
+func (h *Heap) Get(handle Handle) (types.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if handle == 0 || int(handle) > len(h.objects) {
+		return nil, false
+	}
+
+	v := h.objects[handle-1].value
+
+	return v, v != nil
+}
+
+// Each calls fn with every object on the heap in handle order.
+func (h *Heap) Each(fn func(Handle, types.Value)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, obj := range h.objects {
+		if obj.value != nil {
+			fn(Handle(i+1), obj.value)
+		}
+	}
+}
+
+// Stats returns statistics about the heap.
+func (h *Heap) Stats() HeapStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.stats
+}
+
+// AddRoots registers the given roots with the heap, keeping every object they
+// refer to alive.
+func (h *Heap) AddRoots(r Roots) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.roots = append(h.roots, r)
+}
+
+// RemoveRoots removes roots registered with AddRoots.
+func (h *Heap) RemoveRoots(r Roots) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, root := range h.roots {
+		if root == r {
+			h.roots = append(h.roots[:i], h.roots[i+1:]...)
+			return
+		}
+	}
+}
+
+// collect runs a collection of the heap of the given machine, returning the
+// number of objects freed and the finalizers to call, in handle order.
+func (h *Heap) collect(m *Machine) (int, []finalization) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pending := []Handle{}
+	mark := func(handle Handle) {
+		if handle == 0 || int(handle) > len(h.objects) {
+			return
+		}
+
+		obj := &h.objects[handle-1]
+		if obj.value != nil && !obj.marked {
+			obj.marked = true
+			pending = append(pending, handle)
+		}
+	}
+
+	for _, r := range h.roots {
+		r.TraceRoots(mark)
+	}
+
+	m.TraceRoots(mark)
+
+	for len(pending) > 0 {
+		handle := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if t, ok := h.objects[handle-1].value.(Tracer); ok {
+			t.Trace(mark)
+		}
+	}
+
+	for i := range h.objects {
+		if w, ok := h.objects[i].value.(*weakRef); ok && w.cleared(h) {
+			w.target = Slot{}
+		}
+	}
+
+	// The free list is rebuilt from the highest handle down, so the lowest
+	// free handle is reused first.
+	freed := 0
+	finalizers := []finalization{}
+	h.free = h.free[:0]
+	for i := len(h.objects) - 1; i >= 0; i-- {
+		obj := &h.objects[i]
+		switch {
+		case obj.value == nil:
+			h.free = append(h.free, Handle(i+1))
+		case obj.marked:
+			obj.marked = false
+		default:
+			size := obj.value.Size()
+			h.stats.Objects--
+			h.stats.LiveBytes -= size
+			h.stats.Freed++
+			h.stats.FreedBytes += size
+			freed++
+
+			if obj.owner != nil {
+				obj.owner.Free(obj.value)
+			} else if m != nil {
+				m.heapUsed.Add(-int64(size))
+			}
+
+			if obj.finalizer != nil {
+				finalizers = append(finalizers, finalization{fn: obj.finalizer, value: obj.value})
+			}
+
+			*obj = heapObject{value: nil, owner: nil, finalizer: nil, marked: false}
+			h.free = append(h.free, Handle(i+1))
+		}
+	}
+
+	h.stats.Collections++
+	slices.Reverse(finalizers)
+
+	return freed, finalizers
+}
@@ -0,0 +1,212 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+// cell is a heap value which refers to other heap objects.
+type cell struct {
+	refs []vm.Handle
+}
+
+func (c *cell) ID() typeid.ID {
+	return typeid.List
+}
+
+func (c *cell) Size() int {
+	return 8 * (len(c.refs) + 1)
+}
+
+func (c *cell) Upcast() types.StackValue {
+	return nil
+}
+
+func (c *cell) Trace(visit func(vm.Handle)) {
+	for _, h := range c.refs {
+		visit(h)
+	}
+}
+
+func TestMachineHeap(t *testing.T) {
+	t.Parallel()
+
+	t.Run("New", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{}
+		a, err := m.New(&cell{})
+		require.NoError(t, err)
+		b, err := m.New(&cell{refs: []vm.Handle{a}})
+		require.NoError(t, err)
+		require.Equal(t, []vm.Handle{1, 2}, []vm.Handle{a, b})
+
+		v, ok := m.Heap().Get(b)
+		require.True(t, ok)
+		require.Equal(t, &cell{refs: []vm.Handle{a}}, v)
+
+		for _, h := range []vm.Handle{0, 3} {
+			_, ok := m.Heap().Get(h)
+			require.False(t, ok)
+		}
+
+		require.Equal(t, vm.HeapStats{Objects: 2, LiveBytes: 24, Collections: 0, Freed: 0, FreedBytes: 0}, m.Heap().Stats())
+		require.Equal(t, 24, m.HeapUsed())
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{}
+		th := &vm.Thread{Machine: m}
+		m.Heap().AddRoots(th)
+
+		// leaf <- middle <- root is reachable from the stack, while the two
+		// cycle objects refer only to each other.
+		leaf, err := th.New(&cell{})
+		require.NoError(t, err)
+		middle, err := th.New(&cell{refs: []vm.Handle{handle(t, leaf)}})
+		require.NoError(t, err)
+		root, err := th.New(&cell{refs: []vm.Handle{handle(t, middle)}})
+		require.NoError(t, err)
+
+		cycle, err := m.New(&cell{refs: []vm.Handle{5}})
+		require.NoError(t, err)
+		_, err = m.New(&cell{refs: []vm.Handle{cycle}})
+		require.NoError(t, err)
+
+		th.Stack = append(th.Stack, vm.UnsignedSlot(4), root)
+		require.Equal(t, 2, m.Collect())
+		require.Equal(t, vm.HeapStats{Objects: 3, LiveBytes: 40, Collections: 1, Freed: 2, FreedBytes: 32}, m.Heap().Stats())
+		require.Equal(t, 40, m.HeapUsed())
+		require.Equal(t, 40, th.HeapUsed)
+
+		th.Stack = th.Stack[:1]
+		require.Equal(t, 3, m.Collect())
+		require.Equal(t, 0, m.HeapUsed())
+		require.Equal(t, 0, th.HeapUsed)
+
+		m.Heap().Each(func(vm.Handle, types.Value) {
+			t.Fatal("heap is not empty")
+		})
+	})
+
+	t.Run("Done", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Jump 0x05, Add
+		m := &vm.Machine{Data: []uint8{opJump, 0x01, 0x05, opAdd, 0x00}}
+
+		var refs []vm.Slot
+		for range 2 {
+			h, err := m.New(&cell{})
+			require.NoError(t, err)
+			refs = append(refs, vm.RefSlot(h, typeid.List))
+		}
+
+		done := m.Spawn(0, refs[0])
+		failed := m.Spawn(3, refs[1])
+		require.NoError(t, m.Run())
+		require.NoError(t, done.Err)
+		require.ErrorIs(t, failed.Err, vm.ErrStackUnderflow)
+
+		require.Equal(t, 1, m.Collect())
+		_, ok := m.Heap().Get(handle(t, refs[0]))
+		require.True(t, ok)
+		_, ok = m.Heap().Get(handle(t, refs[1]))
+		require.False(t, ok)
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{}
+		roots := &vm.Thread{Machine: m}
+		m.Heap().AddRoots(roots)
+
+		for range 4 {
+			_, err := m.New(&cell{})
+			require.NoError(t, err)
+		}
+
+		roots.Stack = []vm.Slot{vm.RefSlot(2, typeid.List), vm.RefSlot(4, typeid.List)}
+		require.Equal(t, 2, m.Collect())
+
+		for _, expected := range []vm.Handle{1, 3, 5} {
+			h, err := m.New(&cell{})
+			require.NoError(t, err)
+			require.Equal(t, expected, h)
+		}
+
+		m.Heap().RemoveRoots(roots)
+		require.Equal(t, 5, m.Collect())
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{HeapLimit: 16}
+		th := &vm.Thread{Machine: m, Limits: vm.Limits{Stack: 0, Frames: 0, Heap: 8}}
+
+		_, err := th.New(&cell{})
+		require.NoError(t, err)
+		_, err = th.New(&cell{})
+		require.Equal(t, vm.LimitError{Err: vm.ErrHeapExhausted, Limit: 8}, err)
+
+		_, err = m.New(&cell{})
+		require.NoError(t, err)
+		_, err = m.New(&cell{})
+		require.Equal(t, vm.LimitError{Err: vm.ErrHeapExhausted, Limit: 16}, err)
+
+		require.Equal(t, 2, m.Collect())
+		_, err = m.New(&cell{refs: []vm.Handle{1}})
+		require.NoError(t, err)
+	})
+
+	t.Run("NoMachine", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := (&vm.Thread{}).New(&cell{})
+		testerr.Is(vm.ErrNoMachine).Require(t, err)
+	})
+}
+
+func TestSlotHandle(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		slot     vm.Slot
+		expected vm.Handle
+		ok       bool
+	}{
+		{"Ref", vm.RefSlot(7, typeid.String), 7, true},
+		{"Void", vm.Slot{}, 0, false},
+		{"Numeric", vm.UnsignedSlot(7), 0, false},
+		{"Boxed", vm.SlotOf(types.Error{}), 0, false},
+		{"Zero", vm.RefSlot(0, typeid.String), 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, ok := test.slot.Handle()
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.expected, h)
+		})
+	}
+}
+
+// handle returns the handle of the heap object the given slot refers to.
+func handle(t *testing.T, s vm.Slot) vm.Handle {
+	t.Helper()
+
+	h, ok := s.Handle()
+	require.True(t, ok)
+
+	return h
+}
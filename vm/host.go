@@ -0,0 +1,66 @@
+package vm
+
+import "slices"
+
+// Function declares a host function, a Go function which the threads of a
+// machine may call with the CallHost opcode.
+//
+// Fn is given the arguments popped from the stack of the calling thread in the
+// order they were pushed, and returns the value pushed in their place. An
+// error returned by Fn is raised as a fault of the thread, leaving the
+// arguments on its stack. Calling a function whose Fn is nil is a fault, as if
+// the machine did not declare it.
+//
+// A function which returns the same result and has the same effects whenever
+// it is given the same arguments should be marked Deterministic. Other
+// functions, such as those which read the time or do I/O, can not be called by
+// a deterministic machine.
+type Function struct {
+	Name          string
+	Fn            func(t *Thread, args []Slot) (Slot, error)
+	Deterministic bool
+}
+
+// opCallHost calls the host function with the index of the instruction,
+// passing it the number of arguments given by the high nibble of the control
+// byte.
+func (t *Thread) opCallHost(ins *Instruction) error {
+	m := t.Machine
+	if m == nil {
+		return ErrNoMachine
+	}
+
+	if ins.Target >= len(m.Functions) {
+		return ErrNoFunction
+	}
+
+	f := m.Functions[ins.Target]
+	if f.Fn == nil {
+		return ErrNoFunction
+	}
+
+	if m.Deterministic && !f.Deterministic {
+		return NondeterministicError{Name: f.Name}
+	}
+
+	base := len(t.Stack) - int(ins.Control>>callArgsShift)
+	if base < t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	if base == len(t.Stack) {
+		if err := t.grow(); err != nil {
+			return err
+		}
+	}
+
+	result, err := f.Fn(t, slices.Clone(t.Stack[base:]))
+	if err != nil {
+		return err
+	}
+
+	t.Stack = t.Stack[:base]
+	t.push(result)
+
+	return nil
+}
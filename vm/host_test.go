@@ -0,0 +1,92 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/consterr"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opCallHost = uint8(opcode.CallHost)
+	opRandom   = uint8(opcode.Random)
+	opTime     = uint8(opcode.Time)
+)
+
+const errHost consterr.Error = "host function failed"
+
+// functions are the host functions declared by the machines of the host
+// tests.
+var functions = []vm.Function{
+	{Name: "sum", Fn: func(_ *vm.Thread, args []vm.Slot) (vm.Slot, error) {
+		var sum uint64
+		for _, arg := range args {
+			sum += arg.Unsigned()
+		}
+
+		return vm.UnsignedSlot(sum), nil
+	}, Deterministic: true},
+	{Name: "now", Fn: func(*vm.Thread, []vm.Slot) (vm.Slot, error) {
+		return vm.UnsignedSlot(7), nil
+	}, Deterministic: false},
+	{Name: "fail", Fn: func(*vm.Thread, []vm.Slot) (vm.Slot, error) {
+		return vm.Slot{}, errHost
+	}, Deterministic: true},
+}
+
+func TestThreadCallHost(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name          string
+		data          []uint8
+		deterministic bool
+		expected      []types.Value
+		errval        testerr.ExpectedError
+	}{
+		{"Args", []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83, opCallHost, 0x21, 0x00}, false,
+			vals(u64(1), u64(5)), testerr.Nil()},
+		{"NoArgs", []uint8{opCallHost, 0x01, 0x00}, true, vals(u64(0)), testerr.Nil()},
+		{"U16", []uint8{opCallHost, 0x02, 0x00, 0x01}, false, vals(u64(7)), testerr.Nil()},
+		{"Nondeterministic", []uint8{opCallHost, 0x01, 0x01}, true, []types.Value{},
+			testerr.Is(vm.NondeterministicError{Name: "now"})},
+		{"Error", []uint8{opPush, 0x81, opCallHost, 0x11, 0x02}, false, vals(u64(1)), testerr.Is(errHost)},
+		{"NoFunction", []uint8{opCallHost, 0x01, 0x03}, false, []types.Value{}, testerr.Is(vm.ErrNoFunction)},
+		{"Underflow", []uint8{opPush, 0x81, opCallHost, 0x21, 0x00}, false, vals(u64(1)),
+			testerr.Is(vm.ErrStackUnderflow)},
+		{"Control", []uint8{opCallHost, 0x03, 0x00, 0x00, 0x00}, false, []types.Value{},
+			testerr.Is(vm.InvalidControlError{Op: opcode.CallHost, Control: 0x03})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data, Functions: functions, Deterministic: test.deterministic}
+			th := m.Spawn(0)
+			require.NoError(t, m.Run())
+			test.errval.Require(t, th.Err)
+			require.Equal(t, test.expected, vm.Values(th.Stack))
+		})
+	}
+
+	t.Run("NilFunction", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: []uint8{opCallHost, 0x01, 0x00}, Functions: []vm.Function{
+			{Name: "nil", Fn: nil, Deterministic: true},
+		}}
+		th := m.Spawn(0)
+		require.NoError(t, m.Run())
+		require.ErrorIs(t, th.Err, vm.ErrNoFunction)
+	})
+
+	t.Run("NoMachine", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opCallHost, 0x01, 0x00}}
+		require.ErrorIs(t, th.Run(), vm.ErrNoMachine)
+	})
+}
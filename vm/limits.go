@@ -0,0 +1,66 @@
+package vm
+
+import "github.com/tvarney/illvm/types"
+
+// Limits bounds the memory a thread may use. A limit of 0 is not enforced.
+type Limits struct {
+	// Stack is the number of slots the stack of the thread may hold, along
+	// with the stacks of whatever resumed the coroutines it is running. See
+	// Thread.StackUsed.
+	Stack int
+
+	// Frames is the number of call frames the thread may have active.
+	Frames int
+
+	// Heap is the number of bytes of heap objects the thread may hold, as
+	// given by types.Value.Size.
+	Heap int
+}
+
+// Allocate accounts for a heap object holding the given value against the heap
+// limits of the thread and of its machine.
+//
+// If either limit would be exceeded nothing is accounted and a LimitError
+// wrapping ErrHeapExhausted is returned.
+func (t *Thread) Allocate(v types.Value) error {
+	size := v.Size()
+	if t.Limits.Heap > 0 && t.HeapUsed+size > t.Limits.Heap {
+		return LimitError{Err: ErrHeapExhausted, Limit: t.Limits.Heap}
+	}
+
+	if m := t.Machine; m != nil {
+		used := m.heapUsed.Add(int64(size))
+		if m.HeapLimit > 0 && used > int64(m.HeapLimit) {
+			m.heapUsed.Add(-int64(size))
+			return LimitError{Err: ErrHeapExhausted, Limit: m.HeapLimit}
+		}
+	}
+
+	t.HeapUsed += size
+
+	return nil
+}
+
+// Free releases a heap object holding the given value which was accounted for
+// by Allocate.
+func (t *Thread) Free(v types.Value) {
+	size := v.Size()
+	t.HeapUsed -= size
+	if m := t.Machine; m != nil {
+		m.heapUsed.Add(-int64(size))
+	}
+}
+
+// grow checks that another slot may be pushed onto the stack.
+func (t *Thread) grow() error {
+	return t.reserve(1)
+}
+
+// reserve checks that the given number of slots may be pushed onto the stack.
+func (t *Thread) reserve(count int) error {
+	if t.Limits.Stack > 0 && t.StackUsed()+count > t.Limits.Stack {
+		return LimitError{Err: ErrStackOverflow, Limit: t.Limits.Stack}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestThreadLimits(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		limits   vm.Limits
+		errval   testerr.ExpectedError
+		expected []types.Value
+	}{
+		{"Push", []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83}, vm.Limits{Stack: 2, Frames: 0, Heap: 0},
+			testerr.Is(vm.ErrStackOverflow), vals(u64(1), u64(2))},
+		{"Dupe", []uint8{opPush, 0x81, opDupe, 0x00, opDupe, 0x00}, vm.Limits{Stack: 2, Frames: 0, Heap: 0},
+			testerr.Is(vm.ErrStackOverflow), vals(u64(1), u64(1))},
+		{"Within", []uint8{opPush, 0x81, opPush, 0x82, opAdd, modeWrap, opPush, 0x83},
+			vm.Limits{Stack: 2, Frames: 1, Heap: 0}, errOverflow, vals(u64(3), u64(3))},
+		{"PushArith", []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83, opAdd, modeWrap},
+			vm.Limits{Stack: 2, Frames: 0, Heap: 0}, testerr.Is(vm.ErrStackOverflow), vals(u64(1), u64(2))},
+		{"Frames", bench.Fib(10), vm.Limits{Stack: 0, Frames: 4, Heap: 0},
+			testerr.Is(vm.ErrFrameOverflow), nil},
+		{"Unlimited", bench.Fib(10), vm.Limits{}, errOverflow, vals(u64(55))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, predecode := range []bool{false, true} {
+				th := &vm.Thread{Data: test.data, Limits: test.limits}
+				if predecode {
+					th.Predecode()
+					require.NoError(t, th.Program.Compile(0))
+				}
+
+				test.errval.Require(t, th.Run())
+				if test.expected != nil {
+					requireStack(t, test.expected, th.Stack)
+				}
+			}
+		})
+	}
+
+	t.Run("Caught", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 2, Push 3 (overflows) then 0x06: Pop 1
+		data := []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83, opPop, 0x80}
+		th := &vm.Thread{
+			Data: data, Limits: vm.Limits{Stack: 2, Frames: 0, Heap: 0},
+			Handlers: []vm.Handler{{Start: 0, End: 6, Target: 6, Depth: 1}},
+		}
+
+		errOverflow.Require(t, th.Run())
+		requireStack(t, vals(u64(1)), th.Stack)
+	})
+
+	t.Run("Fused", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 2, Push 3 (overflows), Add then 0x08: Pop 1
+		data := []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83, opAdd, modeWrap, opPop, 0x80}
+		limits := vm.Limits{Stack: 2, Frames: 0, Heap: 0}
+
+		// run runs the bytecode with the given handlers, returning the program
+		// counter and error of the thread.
+		run := func(mode string, handlers []vm.Handler) (int, error) {
+			th := &vm.Thread{Data: data, Limits: limits, Handlers: handlers}
+			if mode != "Raw" {
+				th.Predecode()
+			}
+
+			if mode == "Compiled" {
+				require.NoError(t, th.Program.Compile(0))
+			}
+
+			err := th.Run()
+
+			return th.PC, err
+		}
+
+		for _, handlers := range [][]vm.Handler{
+			nil,
+			{{Start: 4, End: 6, Target: 8, Depth: 1}},
+			{{Start: 6, End: 8, Target: 8, Depth: 1}},
+		} {
+			rawPC, rawErr := run("Raw", handlers)
+			for _, mode := range []string{"Predecoded", "Compiled"} {
+				pc, err := run(mode, handlers)
+				require.Equal(t, rawErr.Error(), err.Error(), mode)
+				require.Equal(t, rawPC, pc, mode)
+			}
+		}
+	})
+
+	t.Run("Coroutine", func(t *testing.T) {
+		t.Parallel()
+
+		for _, test := range []struct {
+			name  string
+			data  []uint8
+			limit int
+		}{
+			// 0x00: Push 1, Push 2, CoCreate 0x0F, Push 5, Dupe 1, CoResume,
+			//       Jump 0xFF
+			// 0x0F: Push 1, Push 2 (overflows), CoYield
+			{"Push", []uint8{
+				opPush, 0x81, opPush, 0x82, opCoCreate, 0x01, 0x0F, opPush, 0x85, opDupe, 0x01, opCoResume,
+				opJump, 0x01, 0xFF,
+				opPush, 0x81, opPush, 0x82, opCoYield,
+			}, 5},
+			// 0x00: CoCreate 0x0E, Push 0, Dupe 1, CoResume, Dupe 2,
+			//       CoResume (overflows), Jump 0xFF
+			// 0x0E: Push 1, Push 2, CoYield, CoYield
+			{"Resume", []uint8{
+				opCoCreate, 0x01, 0x0E, opPush, 0x80, opDupe, 0x01, opCoResume, opDupe, 0x02, opCoResume,
+				opJump, 0x01, 0xFF,
+				opPush, 0x81, opPush, 0x82, opCoYield, opCoYield,
+			}, 4},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				t.Parallel()
+
+				// The stack of the coroutine fits within the limit by itself,
+				// but not along with the stack it was resumed from.
+				for _, predecode := range []bool{false, true} {
+					m := &vm.Machine{Data: test.data}
+					limits := vm.Limits{Stack: test.limit, Frames: 0, Heap: 0}
+					th := &vm.Thread{Machine: m, Data: test.data, Limits: limits}
+					if predecode {
+						th.Predecode()
+					}
+
+					testerr.Is(vm.ErrStackOverflow).Require(t, th.Run())
+				}
+			})
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		err := vm.LimitError{Err: vm.ErrFrameOverflow, Limit: 4}
+		require.EqualError(t, err, "frame limit exceeded: limit is 4")
+		require.ErrorIs(t, err, vm.ErrFrameOverflow)
+	})
+}
+
+func TestThreadAllocate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Thread", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Limits: vm.Limits{Stack: 0, Frames: 0, Heap: 12}}
+		require.NoError(t, th.Allocate(types.Uint64(1)))
+		testerr.Is(vm.ErrHeapExhausted).Require(t, th.Allocate(types.Uint64(2)))
+		require.NoError(t, th.Allocate(types.Int32(3)))
+		require.Equal(t, 12, th.HeapUsed)
+
+		th.Free(types.Uint64(1))
+		require.Equal(t, 4, th.HeapUsed)
+	})
+
+	t.Run("Machine", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{HeapLimit: 16}
+		a, b := &vm.Thread{Machine: m}, &vm.Thread{Machine: m}
+		require.NoError(t, a.Allocate(types.Uint64(1)))
+		require.NoError(t, b.Allocate(types.Float64(1)))
+
+		err := a.Allocate(types.Uint8(1))
+		require.Equal(t, vm.LimitError{Err: vm.ErrHeapExhausted, Limit: 16}, err)
+		require.Equal(t, 16, m.HeapUsed())
+		require.Equal(t, 8, a.HeapUsed)
+
+		b.Free(types.Float64(1))
+		require.NoError(t, a.Allocate(types.Uint8(1)))
+		require.Equal(t, 9, m.HeapUsed())
+	})
+}
@@ -1,3 +1,389 @@
 package vm
 
-type Machine struct{}
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tvarney/illvm/opcode"
+)
+
+// DefaultSlice is the number of opcodes a machine runs a thread for before
+// switching to the next runnable thread, if the machine does not set Slice.
+const DefaultSlice = 1000
+
+// Machine holds the state shared by the threads of a virtual machine.
+//
+// Threads created with Spawn are run together by Run, which takes turns
+// running each runnable thread for a time slice of Slice opcodes. With more
+// than one of Workers the threads are run in parallel, each by one goroutine
+// at a time.
+//
+// The bytecode, program and handlers of a machine are shared by its threads
+// and must not be changed while they run; compiling functions of the program
+// is the exception, and is synchronized by the program itself. The stack,
+// frames, program counter, fuel and limits of a thread are its own. The heap,
+// the channels on it, the values of the globals and the scheduling state of
+// the threads are shared and mutable, and are guarded by the machine, so
+// opcodes which use them are safe to run in parallel. Go code reading or
+// changing the state of a thread must not do so while the thread is running.
+type Machine struct {
+	// Data, Program and Handlers are the bytecode, decoded program and
+	// exception handlers given to each spawned thread.
+	Data     []uint8
+	Program  *Program
+	Handlers []Handler
+
+	// Globals declares the global variables of the machine, indexed by the
+	// global variable opcodes in declaration order.
+	Globals []Global
+
+	// Functions are the host functions the machine lets its threads call,
+	// indexed by the CallHost opcode in declaration order.
+	Functions []Function
+
+	// Slice is the number of opcodes a thread is run for before the next
+	// runnable thread is run. If Slice is 0, DefaultSlice is used.
+	Slice int
+
+	// Workers is the number of goroutines Run runs threads on. If Workers is
+	// less than 2 every thread is run on the goroutine calling Run.
+	Workers int
+
+	// HeapLimit is the number of bytes of heap objects the machine and its
+	// threads may hold together, or 0 if it is not limited.
+	HeapLimit int
+
+	// Deterministic makes runs of the machine reproducible, so that running
+	// the same threads gives the same results on every host. See Run.
+	Deterministic bool
+
+	// Seed seeds the random number generators of the threads of a
+	// deterministic machine.
+	Seed uint64
+
+	// Clock is the virtual clock of a deterministic machine, read by the Time
+	// opcode, in nanoseconds since the Unix epoch. It only changes when it is
+	// set from Go, which must not be done while the machine is running.
+	Clock int64
+
+	heap     Heap
+	heapUsed atomic.Int64
+
+	// mu guards the scheduling state of the machine and its threads, the
+	// channels on the heap and the values of the globals.
+	mu      sync.Mutex
+	idle    sync.Cond
+	running int
+	threads []*Thread
+	queue   []*Thread
+	globals []Slot
+}
+
+// ThreadState is the scheduling state of a thread.
+type ThreadState uint8
+
+const (
+	// ThreadRunnable is the state of a thread which may be run.
+	ThreadRunnable ThreadState = iota
+
+	// ThreadWaiting is the state of a thread blocked in a Join or a channel
+	// opcode.
+	ThreadWaiting
+
+	// ThreadDone is the state of a spawned thread which has stopped.
+	ThreadDone
+)
+
+// HeapUsed returns the number of bytes of heap objects held by the machine and
+// its threads.
+func (m *Machine) HeapUsed() int {
+	return int(m.heapUsed.Load())
+}
+
+// Spawn creates a thread of the machine which starts running at the given
+// entry offset with the given arguments on its stack, and schedules it to be
+// run by Run.
+//
+// Spawned threads are numbered from 1 in the order they are created, and the
+// stack of each is a root of the heap of the machine until the thread is done.
+// The stack of a done thread is then only a root if the thread ran to the end
+// of its bytecode, as the values it holds are the result of the thread.
+func (m *Machine) Spawn(entry int, args ...Slot) *Thread {
+	t := m.newThread()
+	t.Stack = append(t.Stack, args...)
+	t.PC = entry
+	m.spawn(t)
+
+	return t
+}
+
+// newThread returns a thread of the machine which has not been spawned.
+func (m *Machine) newThread() *Thread {
+	return &Thread{
+		Machine:  m,
+		Stack:    []Slot{},
+		Data:     m.Data,
+		Program:  m.Program,
+		Handlers: m.Handlers,
+	}
+}
+
+// spawn numbers the given thread, makes its stack a root of the heap and
+// schedules it to be run.
+func (m *Machine) spawn(t *Thread) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = len(m.threads) + 1
+	m.threads = append(m.threads, t)
+	m.heap.AddRoots(t)
+	m.enqueue(t)
+}
+
+// Thread returns the spawned thread with the given ID.
+func (m *Machine) Thread(id int) (*Thread, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.thread(id)
+}
+
+// thread returns the spawned thread with the given ID. The machine must be
+// locked.
+func (m *Machine) thread(id int) (*Thread, bool) {
+	if id < 1 || id > len(m.threads) {
+		return nil, false
+	}
+
+	return m.threads[id-1], true
+}
+
+// Run runs the spawned threads until every one of them is done.
+//
+// Runnable threads are run in turn for a time slice of Slice opcodes, or until
+// they yield or block, by Workers goroutines. A thread is done once an opcode
+// of it returns an error other than ErrBlocked; the error is kept in the Err
+// field of the thread unless the thread ran past the end of its bytecode.
+//
+// If every thread which is not done is blocked, a DeadlockError describing
+// what each of them is blocked on is returned.
+//
+// A deterministic machine runs every thread on the goroutine calling Run,
+// whatever Workers is, so that threads take turns in the same order each
+// time. Float arithmetic never fuses operations and gives the same NaN for
+// every NaN result, Random draws from a generator seeded by Seed and the ID
+// of the thread, and Time reads Clock. Calling a host function which is not
+// Deterministic results in a VM fault. The machine has no values whose order
+// depends on a Go map, as its heap, threads and channel waiters are kept in
+// the order they were created.
+func (m *Machine) Run() error {
+	m.mu.Lock()
+	m.idle.L = &m.mu
+	m.mu.Unlock()
+
+	workers := m.Workers
+	if m.Deterministic {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for range workers - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work()
+		}()
+	}
+
+	m.work()
+	wg.Wait()
+
+	if blocked := m.Blocked(); len(blocked) > 0 {
+		return DeadlockError{Blocked: blocked}
+	}
+
+	return nil
+}
+
+// Blocked returns what each blocked spawned thread is waiting for, in the
+// order the threads were spawned.
+func (m *Machine) Blocked() []Blocked {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var blocked []Blocked
+	for _, t := range m.threads {
+		if t.State != ThreadWaiting || t.wait == nil {
+			continue
+		}
+
+		b := Blocked{Thread: t.ID, Op: t.wait.op, Offset: t.wait.offset, Join: 0, Channels: nil}
+		if t.wait.join != nil {
+			b.Join = t.wait.join.ID
+		}
+
+		for _, ch := range t.wait.channels {
+			b.Channels = append(b.Channels, ch.handle)
+		}
+
+		blocked = append(blocked, b)
+	}
+
+	return blocked
+}
+
+// work runs threads from the run queue until no thread is runnable and none
+// is running.
+func (m *Machine) work() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		for len(m.queue) == 0 && m.running > 0 {
+			m.idle.Wait()
+		}
+
+		if len(m.queue) == 0 {
+			return
+		}
+
+		t := m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+		m.running++
+
+		m.mu.Unlock()
+		err := m.runSlice(t)
+		m.mu.Lock()
+
+		m.running--
+		switch {
+		case errors.Is(err, ErrBlocked):
+		case err != nil:
+			m.finish(t, err)
+		default:
+			m.enqueue(t)
+		}
+
+		if m.running == 0 {
+			m.idle.Broadcast()
+		}
+	}
+}
+
+// runSlice runs the given thread for one time slice, returning the error which
+// stopped it early, if any.
+func (m *Machine) runSlice(t *Thread) error {
+	slice := m.Slice
+	if slice <= 0 {
+		slice = DefaultSlice
+	}
+
+	t.yielded = false
+	for range slice {
+		if err := t.Step(); err != nil {
+			return err
+		}
+
+		if t.yielded {
+			break
+		}
+	}
+
+	return nil
+}
+
+// enqueue adds the given thread to the run queue. The machine must be locked.
+func (m *Machine) enqueue(t *Thread) {
+	m.queue = append(m.queue, t)
+	m.idle.Signal()
+}
+
+// block marks the given thread as waiting until it is woken by the thread or
+// channels it waits for, unless it is already waiting. The machine must be
+// locked.
+//
+// A thread waiting on channels is woken as a receiver if recv is set, and as
+// a sender otherwise.
+func (m *Machine) block(t *Thread, w *wait, recv bool) {
+	if t.State == ThreadWaiting {
+		return
+	}
+
+	t.State, t.wait = ThreadWaiting, w
+	if w.join != nil {
+		w.join.waiters = append(w.join.waiters, t)
+	}
+
+	for _, ch := range w.channels {
+		ch.waiters = append(ch.waiters, chanWaiter{thread: t, recv: recv})
+	}
+}
+
+// wake makes the given waiting thread runnable again, removing it from the
+// waiters of everything it was waiting for. The machine must be locked.
+func (m *Machine) wake(t *Thread) {
+	if t.State != ThreadWaiting {
+		return
+	}
+
+	for _, ch := range t.wait.channels {
+		ch.waiters = slices.DeleteFunc(ch.waiters, func(w chanWaiter) bool {
+			return w.thread == t
+		})
+	}
+
+	t.State, t.wait = ThreadRunnable, nil
+	if t.ID != 0 {
+		m.enqueue(t)
+	}
+}
+
+// finish marks the given thread as done with the given error, and wakes the
+// threads waiting for it. The machine must be locked.
+func (m *Machine) finish(t *Thread, err error) {
+	t.State = ThreadDone
+	if !errors.Is(err, ErrBytecodeOverflow) {
+		t.Err = err
+	}
+
+	// Only the values a thread which ran to the end of its bytecode left on
+	// its stack are kept alive once it is done, as they are its result.
+	m.heap.RemoveRoots(t)
+	if t.Err == nil && len(t.Stack) > 0 {
+		m.heap.AddRoots(&result{values: t.Stack})
+	}
+
+	waiters := t.waiters
+	t.waiters = nil
+
+	for _, w := range waiters {
+		m.wake(w)
+	}
+}
+
+// wait is what a blocked thread is waiting for.
+type wait struct {
+	op       opcode.ID
+	offset   int
+	join     *Thread
+	channels []*channel
+}
+
+// Blocked describes a spawned thread which is blocked by the opcode at Offset,
+// and what it is waiting for.
+type Blocked struct {
+	// Thread is the ID of the blocked thread.
+	Thread int
+
+	Op     opcode.ID
+	Offset int
+
+	// Join is the ID of the thread a Join waits for, or 0.
+	Join int
+
+	// Channels holds the handles of the channels a channel opcode waits on.
+	Channels []Handle
+}
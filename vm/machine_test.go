@@ -0,0 +1,235 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opYield = uint8(opcode.Yield)
+	opJoin  = uint8(opcode.Join)
+)
+
+func TestMachineRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Interleaved", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: countdown 50, Jump 0xFF
+		// 0x11: Yield, Push 2, Join, Push 7
+		data := append(countdown(50), opJump, 0x01, 0xFF, opYield, opPush, 0x82, opJoin, opPush, 0x87)
+		m := &vm.Machine{Data: data, Slice: 3}
+
+		first := m.Spawn(0x11)
+		second := m.Spawn(0x00)
+		require.NoError(t, m.Run())
+
+		require.Equal(t, 1, first.ID)
+		require.Equal(t, 2, second.ID)
+		for _, th := range []*vm.Thread{first, second} {
+			require.Equal(t, vm.ThreadDone, th.State)
+			require.NoError(t, th.Err)
+		}
+
+		requireStack(t, vals(u64(7)), first.Stack)
+		requireStack(t, vals(i64(0)), second.Stack)
+	})
+
+	t.Run("Predecoded", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: countdown 20, Jump 0xFF
+		// 0x11: Push 2, Join
+		th := &vm.Thread{Data: append(countdown(20), opJump, 0x01, 0xFF, opPush, 0x82, opJoin)}
+		th.Predecode()
+
+		m := &vm.Machine{Data: th.Data, Program: th.Program}
+		waiting := m.Spawn(0x11)
+		done := m.Spawn(0, vm.UnsignedSlot(9))
+		require.NoError(t, m.Run())
+
+		require.NoError(t, waiting.Err)
+		require.Empty(t, waiting.Stack)
+		requireStack(t, vals(u64(9), i64(0)), done.Stack)
+	})
+
+	t.Run("Args", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{Data: []uint8{opPush, 0x81}}
+		th := m.Spawn(0, vm.SignedSlot(-1))
+		require.NoError(t, m.Run())
+		requireStack(t, vals(i64(-1), u64(1)), th.Stack)
+
+		found, ok := m.Thread(1)
+		require.True(t, ok)
+		require.Same(t, th, found)
+
+		_, ok = m.Thread(2)
+		require.False(t, ok)
+	})
+
+	t.Run("Deadlock", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 2, Join
+		// 0x03: Push 1, Join
+		m := &vm.Machine{Data: []uint8{opPush, 0x82, opJoin, opPush, 0x81, opJoin}}
+		first := m.Spawn(0x00)
+		second := m.Spawn(0x03)
+		testerr.Is(vm.ErrDeadlock).Require(t, m.Run())
+
+		require.Equal(t, vm.ThreadWaiting, first.State)
+		require.Equal(t, vm.ThreadWaiting, second.State)
+		require.Equal(t, 0x02, first.PC)
+		require.Equal(t, 0x05, second.PC)
+	})
+
+	t.Run("Faults", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Join
+		// 0x03: Push 9, Join
+		// 0x06: Push -1, Join
+		// 0x0A: Join
+		m := &vm.Machine{Data: []uint8{
+			opPush, 0x81, opJoin, opPush, 0x89, opJoin, opPush, 0x11, 0xFF, opJoin, opJoin,
+		}}
+		self := m.Spawn(0x00)
+		unknown := m.Spawn(0x03)
+		negative := m.Spawn(0x06)
+		empty := m.Spawn(0x0A)
+		require.NoError(t, m.Run())
+
+		for _, test := range []struct {
+			name   string
+			th     *vm.Thread
+			errval testerr.ExpectedError
+		}{
+			{"Self", self, testerr.Is(vm.ErrDeadlock)},
+			{"Unknown", unknown, testerr.Is(vm.ErrNoThread)},
+			{"Negative", negative, testerr.Is(vm.ErrNoThread)},
+			{"Empty", empty, testerr.Is(vm.ErrStackUnderflow)},
+		} {
+			require.Equal(t, vm.ThreadDone, test.th.State, test.name)
+			require.ErrorIs(t, test.th.Err, vm.ErrUncaughtException, test.name)
+			test.errval.Require(t, test.th.Err)
+		}
+	})
+}
+
+func TestThreadJoin(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoMachine", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opJoin}}
+		err := th.Run()
+		require.ErrorIs(t, err, vm.ErrUncaughtException)
+		require.ErrorIs(t, err, vm.ErrNoMachine)
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 2, Join, Push 3
+		// 0x05: Yield, Push 4
+		m := &vm.Machine{Data: []uint8{opPush, 0x82, opJoin, opPush, 0x83, opYield, opPush, 0x84}}
+		first := m.Spawn(0x00)
+		second := m.Spawn(0x05)
+
+		testerr.Is(vm.ErrBlocked).Require(t, first.Run())
+		testerr.Is(vm.ErrBlocked).Require(t, first.Run())
+		require.Equal(t, vm.ThreadWaiting, first.State)
+		require.Equal(t, 0x02, first.PC)
+		requireStack(t, vals(u64(2)), first.Stack)
+
+		require.NoError(t, m.Run())
+		require.Equal(t, vm.ThreadDone, first.State)
+		requireStack(t, vals(u64(3), u64(4)), first.Stack)
+		requireStack(t, vals(u64(4)), second.Stack)
+	})
+}
+
+func TestThreadYield(t *testing.T) {
+	t.Parallel()
+
+	th := &vm.Thread{Data: []uint8{opYield, opPush, 0x81}}
+	errOverflow.Require(t, th.Run())
+	requireStack(t, vals(u64(1)), th.Stack)
+}
+
+func TestMachineParallel(t *testing.T) {
+	t.Parallel()
+
+	const threads = 64
+
+	for _, test := range []struct {
+		name     string
+		capacity int
+		workers  int
+	}{
+		{"Buffered", threads, 8},
+		{"Unbuffered", 0, 8},
+		{"SingleWorker", 4, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Each fib thread sends fib(12) on the channel below its
+			// arguments, and the collector adds up the values it receives.
+			data := append(bench.Fib(12), opDupe, 0x01, opSend, opJump, 0x02, 0xFF, 0xFF)
+			collect := len(data)
+			for range threads {
+				data = append(data, opDupe, 0x01, opRecv, opPop, 0x80, opAdd, modeWrap)
+			}
+
+			m := &vm.Machine{Data: data, Slice: 50, Workers: test.workers}
+			m.Program = vm.Decode(data)
+			m.Program.Fuse()
+			m.Program.HotCalls = 3
+
+			ch, err := (&vm.Thread{Machine: m}).NewChannel(typeid.Uint64, test.capacity)
+			require.NoError(t, err)
+
+			collector := m.Spawn(collect, ch, vm.UnsignedSlot(0))
+			for range threads {
+				m.Spawn(0, ch)
+			}
+
+			require.NoError(t, m.Run())
+			require.NoError(t, collector.Err)
+			require.Equal(t, vals(u64(threads*144)), vm.Values(collector.Stack[1:]))
+
+			for id := range threads + 1 {
+				th, ok := m.Thread(id + 1)
+				require.True(t, ok)
+				require.Equal(t, vm.ThreadDone, th.State)
+				require.NoError(t, th.Err)
+			}
+		})
+	}
+
+	t.Run("Deadlock", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: countdown 100, Push 2, Join
+		// 0x11: Push 1, Join
+		data := append(countdown(100), opPush, 0x82, opJoin, opPush, 0x81, opJoin)
+		m := &vm.Machine{Data: data, Slice: 10, Workers: 4}
+		m.Spawn(0x00)
+		m.Spawn(0x11)
+
+		err := m.Run()
+		require.ErrorIs(t, err, vm.ErrDeadlock)
+		require.Len(t, m.Blocked(), 2)
+	})
+}
@@ -0,0 +1,249 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Instruction is a single decoded opcode.
+//
+// The control byte has been validated and any immediate has been widened, so
+// executing an instruction does not read the bytecode again.
+type Instruction struct {
+	// Op is the opcode of the instruction.
+	Op opcode.ID
+
+	// Control is the control byte of the instruction, or 0 if the opcode does
+	// not take one.
+	Control uint8
+
+	// Offset is the offset in the bytecode of the opcode.
+	Offset int
+
+	// Next is the offset in the bytecode of the following opcode.
+	Next int
+
+	// Value is the value pushed by a Push instruction.
+	Value Slot
+
+	// Target is the offset jumped to by a Jump, JumpIf or Call instruction,
+	// the entry of a CoCreate instruction, the index of the variable used by a
+	// global or local variable instruction, or the index of the host function
+	// called by a CallHost instruction.
+	Target int
+
+	// Fused is the second opcode of a superinstruction.
+	Fused opcode.ID
+
+	// FusedControl is the control byte of the second opcode of a
+	// superinstruction.
+	FusedControl uint8
+
+	// FusedOffset is the offset in the bytecode of the second opcode of a
+	// superinstruction.
+	FusedOffset int
+
+	// Operands is the stack type every operand of the instruction is proven to
+	// have, or typeid.Void if it is not known. See Program.Specialize.
+	Operands typeid.ID
+}
+
+// Program is bytecode which has been decoded ahead of time.
+//
+// A program is built by walking the bytecode from the first opcode and
+// decoding each instruction in turn. Decoding stops at the first opcode which
+// can't be decoded; a thread falls back to reading the bytecode directly for
+// any offset which isn't the start of a decoded instruction, so running a
+// program always behaves exactly like running the bytecode it was built from.
+//
+// Instructions always holds the decoded opcodes as they are in the bytecode.
+// Once Fuse has been called the program is executed using superinstructions
+// where possible, but Instructions is left as it was.
+//
+// Functions of the program which are called HotCalls times are compiled, as if
+// by Compile. Setting HotCalls to 0 disables this.
+//
+// A program may be run by several threads at once. Compiling functions is safe
+// while threads run the program, but Fuse and Specialize are not, and must be
+// done before the program is shared.
+type Program struct {
+	Instructions []Instruction
+	HotCalls     int
+
+	code     []Instruction
+	closures atomic.Pointer[[]closure]
+	calls    []atomic.Int64
+	index    []int
+	size     int
+
+	// mu serializes the updates of closures.
+	mu sync.Mutex
+}
+
+// Decode decodes the given bytecode into a program.
+func Decode(data []uint8) *Program {
+	p := &Program{
+		Instructions: nil,
+		HotCalls:     DefaultHotCalls,
+		code:         nil,
+		closures:     atomic.Pointer[[]closure]{},
+		calls:        nil,
+		index:        make([]int, len(data)),
+		size:         len(data),
+		mu:           sync.Mutex{},
+	}
+	for i := range p.index {
+		p.index[i] = -1
+	}
+
+	for offset := 0; offset < len(data); {
+		ins, err := DecodeAt(data, offset)
+		if err != nil {
+			break
+		}
+
+		p.index[ins.Offset] = len(p.Instructions)
+		p.Instructions = append(p.Instructions, ins)
+		offset = ins.Next
+	}
+
+	p.code = p.Instructions
+	p.calls = make([]atomic.Int64, len(p.code))
+
+	return p
+}
+
+// DecodeAt decodes the instruction which starts at the given offset of the
+// bytecode.
+//
+// The error returned is the fault running the instruction would raise for
+// malformed bytecode, or ErrBytecodeOverflow if the offset is outside the
+// bytecode.
+func DecodeAt(data []uint8, offset int) (Instruction, error) {
+	var ins Instruction
+	if offset < 0 || offset >= len(data) {
+		return ins, ErrBytecodeOverflow
+	}
+
+	scratch := &Thread{Data: data, PC: offset}
+	err := scratch.decode(&ins)
+
+	return ins, err
+}
+
+// Lookup returns the index of the instruction which starts at the given offset
+// of the bytecode.
+//
+// If no decoded instruction starts at the offset, false is returned.
+func (p *Program) Lookup(offset int) (int, bool) {
+	if p == nil || offset < 0 || offset >= len(p.index) || p.index[offset] < 0 {
+		return 0, false
+	}
+
+	return p.index[offset], true
+}
+
+// Fuse runs a peephole pass over the program, replacing common sequences of
+// opcodes with superinstructions.
+//
+// The second opcode of a fused sequence is kept as its own instruction, so
+// jumping to it still works, and faults are raised from the opcode which
+// caused them as if the sequence had not been fused.
+//
+// Fusing discards any compiled functions of the program.
+func (p *Program) Fuse() {
+	p.code = slices.Clone(p.Instructions)
+	p.closures.Store(nil)
+	p.calls = make([]atomic.Int64, len(p.code))
+	for i := range len(p.Instructions) - 1 {
+		first, second := p.Instructions[i], p.Instructions[i+1]
+
+		var op opcode.ID
+		switch {
+		case first.Op == opcode.Push && isArith(second.Op):
+			op = opcode.PushArith
+		case first.Op == opcode.Compare && second.Op == opcode.JumpIf:
+			op = opcode.CompareJumpIf
+		default:
+			continue
+		}
+
+		fused := first
+		fused.Op = op
+		fused.Next = second.Next
+		fused.Target = second.Target
+		fused.Fused = second.Op
+		fused.FusedControl = second.Control
+		fused.FusedOffset = second.Offset
+		if op == opcode.PushArith {
+			fused.Operands = second.Operands
+		}
+		p.code[i] = fused
+	}
+}
+
+// Disassemble writes a listing of the instructions of the program.
+//
+// Each line holds the offset and a single opcode as it appears in the
+// bytecode. The first opcode of a sequence which was fused is annotated with
+// the superinstruction it is executed as.
+func (p *Program) Disassemble(w io.Writer) error {
+	for i, ins := range p.Instructions {
+		line := fmt.Sprintf("0x%04X  %s", ins.Offset, ins)
+		if fused := p.code[i]; fused.Fused != opcode.NoOp {
+			line += "  ; " + fused.Op.String()
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	end := 0
+	if n := len(p.Instructions); n > 0 {
+		end = p.Instructions[n-1].Next
+	}
+
+	if end < p.size {
+		if _, err := fmt.Fprintf(w, "0x%04X  ; %d bytes not decoded\n", end, p.size-end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String returns the instruction in the form used by Program.Disassemble.
+func (ins Instruction) String() string {
+	switch ins.Op {
+	case opcode.NoOp, opcode.Throw, opcode.Yield, opcode.Join,
+		opcode.Send, opcode.Recv, opcode.TryRecv, opcode.Close,
+		opcode.CoResume, opcode.CoYield, opcode.Random, opcode.Time,
+		opcode.Weak, opcode.Deref:
+		return ins.Op.String()
+	case opcode.Push:
+		return fmt.Sprintf("%s 0x%02X %s", ins.Op, ins.Control, formatValue(ins.Value.Value()))
+	case opcode.Jump, opcode.JumpIf, opcode.Call, opcode.CoCreate,
+		opcode.LoadGlobal, opcode.StoreGlobal, opcode.LoadLocal, opcode.StoreLocal, opcode.CallHost:
+		return fmt.Sprintf("%s 0x%02X 0x%04X", ins.Op, ins.Control, ins.Target)
+	default:
+		return fmt.Sprintf("%s 0x%02X", ins.Op, ins.Control)
+	}
+}
+
+// isArith returns if the given opcode is one of the binary math opcodes.
+func isArith(op opcode.ID) bool {
+	switch op {
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod:
+		return true
+	default:
+		return false
+	}
+}
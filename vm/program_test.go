@@ -0,0 +1,163 @@
+package vm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: Push i16 -2, Jump 0x08, NoOp, Throw, Push 0x1F (invalid), NoOp
+	data := []uint8{opPush, 0x12, 0xFF, 0xFE, opJump, 0x01, 0x08, 0x00, opThrow, opPush, 0x1F, 0x00}
+	p := vm.Decode(data)
+
+	require.Equal(t, []vm.Instruction{
+		{Op: opcode.Push, Control: 0x12, Offset: 0, Next: 4, Value: vm.SignedSlot(-2), Target: 0},
+		{Op: opcode.Jump, Control: 0x01, Offset: 4, Next: 7, Value: vm.Slot{}, Target: 8},
+		{Op: opcode.NoOp, Control: 0, Offset: 7, Next: 8, Value: vm.Slot{}, Target: 0},
+		{Op: opcode.Throw, Control: 0, Offset: 8, Next: 9, Value: vm.Slot{}, Target: 0},
+	}, p.Instructions)
+
+	for _, test := range []struct {
+		name   string
+		offset int
+		index  int
+		ok     bool
+	}{
+		{"First", 0, 0, true},
+		{"Last", 8, 3, true},
+		{"Immediate", 2, 0, false},
+		{"Undecoded", 9, 0, false},
+		{"AfterUndecoded", 11, 0, false},
+		{"Negative", -1, 0, false},
+		{"PastEnd", 12, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			idx, ok := p.Lookup(test.offset)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.index, idx)
+		})
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok := (*vm.Program)(nil).Lookup(0)
+		require.False(t, ok)
+	})
+}
+
+func TestThreadPredecode(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name string
+		data []uint8
+	}{
+		{"Countdown", countdown(10)},
+		{"Call", []uint8{
+			opPush, 0x81, opPush, 0x85, opPush, 0x86, opCall, 0x21, 0x0C, opJump, 0x01, 0x12,
+			opPush, 0x87, opPop, 0x80, opReturn, 0x01, opPush, 0x88,
+		}},
+		{"IntoImmediate", []uint8{opJump, 0x01, 0x04, opPush, 0x00, 0x81}},
+		{"Invalid", []uint8{opPush, 0x81, opPush, 0x1F, opPush, 0x82}},
+		{"Fault", []uint8{opPush, 0x80, opPush, 0x81, opDiv, modeWrap}},
+		{"Undefined", []uint8{opPush, 0x81, 0xFF}},
+		{"Fib", bench.Fib(10)},
+		{"FusedTrap", []uint8{
+			opPush, 0x18, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, opPush, 0x81, opAdd, modeTrap,
+		}},
+		{"FusedUnderflow", []uint8{opPush, 0x81, opAdd, modeWrap}},
+		{"FusedCompare", []uint8{opPush, 0x81, opCompare, cmpEq, opJumpIf, 0x01, 0x00}},
+		{"IntoFused", []uint8{opPush, 0x85, opPush, 0x85, opJump, 0x01, 0x09, opPush, 0x81, opAdd, modeWrap}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			requireSameRun(t, test.data, nil)
+		})
+	}
+
+	t.Run("Handled", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 0, Div, Push 1, Add (faults) then 0x0A: Push 7
+		data := []uint8{opPush, 0x81, opPush, 0x80, opDiv, modeWrap, opPush, 0x81, opAdd, modeWrap, opPush, 0x87}
+		requireSameRun(t, data, []vm.Handler{{Start: 0, End: 6, Target: 6, Depth: 0}, {Start: 6, End: 10, Target: 10, Depth: 0}})
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: countdown(3)}
+		th.Predecode()
+		errOverflow.Require(t, th.Run())
+		requireStack(t, vals(types.Int64(0)), th.Stack)
+	})
+}
+
+func TestProgramDisassemble(t *testing.T) {
+	t.Parallel()
+
+	data := append(bench.Countdown(3, modeWrap), opCompare, cmpEq, opJumpIf, 0x11, 0x00, opPush, 0x1F)
+	p := vm.Decode(data)
+
+	var sb strings.Builder
+	require.NoError(t, p.Disassemble(&sb))
+	require.Equal(t, `0x0000  Push 0x12 int64 3
+0x0004  Push 0x11 int64 -1
+0x0007  Add 0x00
+0x0009  Dupe 0x00
+0x000B  JumpIf 0x01 0x0004
+0x000E  Compare 0x00
+0x0010  JumpIf 0x11 0x0000
+0x0013  ; 2 bytes not decoded
+`, sb.String())
+
+	p.Fuse()
+	sb.Reset()
+	require.NoError(t, p.Disassemble(&sb))
+	require.Equal(t, `0x0000  Push 0x12 int64 3
+0x0004  Push 0x11 int64 -1  ; PushArith
+0x0007  Add 0x00
+0x0009  Dupe 0x00
+0x000B  JumpIf 0x01 0x0004
+0x000E  Compare 0x00  ; CompareJumpIf
+0x0010  JumpIf 0x11 0x0000
+0x0013  ; 2 bytes not decoded
+`, sb.String())
+	require.Len(t, p.Instructions, 7)
+}
+
+// requireSameRun runs the given bytecode raw, predecoded and compiled, and
+// requires that each run ends in the same state.
+func requireSameRun(t *testing.T, data []uint8, handlers []vm.Handler) {
+	t.Helper()
+
+	raw := &vm.Thread{Data: data, Handlers: handlers}
+	expected := raw.Run()
+
+	for _, compile := range []bool{false, true} {
+		th := &vm.Thread{Data: data, Handlers: handlers}
+		th.Predecode()
+
+		if compile {
+			if err := th.Program.Compile(0); err != nil {
+				require.ErrorIs(t, err, vm.ErrNotCompilable)
+			}
+		}
+
+		require.Equal(t, expected, th.Run())
+		require.Equal(t, raw.PC, th.PC)
+		require.Equal(t, vm.Values(raw.Stack), vm.Values(th.Stack))
+		require.Equal(t, raw.Frames, th.Frames)
+	}
+}
@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Slot is a single value on the stack of a thread.
+//
+// Numeric values are stored unboxed in Bits, tagged by Type; floats are stored
+// as their IEEE-754 bit pattern. Any other value is stored in Ref, with Type
+// holding its ID, or is a reference to a heap object whose handle is stored in
+// Bits. The zero Slot is a void value.
+type Slot struct {
+	Ref  types.Value
+	Bits uint64
+	Type typeid.ID
+}
+
+// SlotOf returns a slot holding the given value upcast to its stack type.
+//
+// A nil value results in a void slot.
+func SlotOf(v types.Value) Slot {
+	if v == nil {
+		return Slot{}
+	}
+
+	switch n := v.Upcast().(type) {
+	case types.Uint64:
+		return UnsignedSlot(uint64(n))
+	case types.Int64:
+		return SignedSlot(int64(n))
+	case types.Float64:
+		return FloatSlot(float64(n))
+	default:
+		return Slot{Ref: n, Type: n.ID()}
+	}
+}
+
+// RefSlot returns a slot referring to the heap object with the given handle,
+// whose value has the given type.
+func RefSlot(handle Handle, id typeid.ID) Slot {
+	return Slot{Bits: uint64(handle), Type: id}
+}
+
+// UnsignedSlot returns a slot holding a u64 value.
+func UnsignedSlot(u uint64) Slot {
+	return Slot{Bits: u, Type: typeid.Uint64}
+}
+
+// SignedSlot returns a slot holding an i64 value.
+func SignedSlot(i int64) Slot {
+	return Slot{Bits: uint64(i), Type: typeid.Int64}
+}
+
+// FloatSlot returns a slot holding an f64 value.
+func FloatSlot(f float64) Slot {
+	return Slot{Bits: math.Float64bits(f), Type: typeid.Float64}
+}
+
+// Value returns the value held by the slot.
+//
+// A void slot results in a nil value.
+func (s Slot) Value() types.StackValue {
+	switch s.Type {
+	case typeid.Void:
+		return nil
+	case typeid.Uint64:
+		return types.Uint64(s.Bits)
+	case typeid.Int64:
+		return types.Int64(s.Signed())
+	case typeid.Float64:
+		return types.Float64(s.Float())
+	default:
+		v, _ := s.Ref.(types.StackValue)
+		return v
+	}
+}
+
+// Unsigned returns the payload of the slot as a u64.
+func (s Slot) Unsigned() uint64 {
+	return s.Bits
+}
+
+// Signed returns the payload of the slot as an i64.
+func (s Slot) Signed() int64 {
+	return int64(s.Bits)
+}
+
+// Float returns the payload of the slot as an f64.
+func (s Slot) Float() float64 {
+	return math.Float64frombits(s.Bits)
+}
+
+// Handle returns the handle of the heap object the slot refers to, or false if
+// it does not refer to one.
+func (s Slot) Handle() (Handle, bool) {
+	if s.Ref != nil || s.Type == typeid.Void || s.IsNumeric() || s.Bits == 0 {
+		return 0, false
+	}
+
+	return Handle(s.Bits), true
+}
+
+// IsNumeric returns if the slot holds a u64, i64, or f64 value.
+func (s Slot) IsNumeric() bool {
+	return s.Type == typeid.Uint64 || s.Type == typeid.Int64 || s.Type == typeid.Float64
+}
+
+// Slots converts the given values to slots.
+func Slots(values ...types.Value) []Slot {
+	slots := make([]Slot, len(values))
+	for i, v := range values {
+		slots[i] = SlotOf(v)
+	}
+
+	return slots
+}
+
+// Values converts the given slots to values.
+func Values(slots []Slot) []types.Value {
+	values := make([]types.Value, len(slots))
+	for i, s := range slots {
+		values[i] = s.Value()
+	}
+
+	return values
+}
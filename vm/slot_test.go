@@ -0,0 +1,63 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestSlot(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		value    types.Value
+		id       typeid.ID
+		expected types.Value
+	}{
+		{"Nil", nil, typeid.Void, nil},
+		{"Uint64", types.Uint64(math.MaxUint64), typeid.Uint64, types.Uint64(math.MaxUint64)},
+		{"Uint8", types.Uint8(7), typeid.Uint64, types.Uint64(7)},
+		{"Int64", types.Int64(math.MinInt64), typeid.Int64, types.Int64(math.MinInt64)},
+		{"Int16", types.Int16(-3), typeid.Int64, types.Int64(-3)},
+		{"Float64", types.Float64(-2.5), typeid.Float64, types.Float64(-2.5)},
+		{"Float32", types.Float32(1.5), typeid.Float64, types.Float64(1.5)},
+		{"Error", types.Error{Err: vm.ErrStackUnderflow}, typeid.Error, types.Error{Err: vm.ErrStackUnderflow}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := vm.SlotOf(test.value)
+			require.Equal(t, test.id, s.Type)
+			require.Equal(t, test.id == typeid.Uint64 || test.id == typeid.Int64 || test.id == typeid.Float64, s.IsNumeric())
+
+			if test.expected == nil {
+				require.Nil(t, s.Value())
+				return
+			}
+
+			require.Equal(t, test.expected, s.Value())
+		})
+	}
+
+	t.Run("Payload", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, uint64(5), vm.UnsignedSlot(5).Unsigned())
+		require.Equal(t, int64(-5), vm.SignedSlot(-5).Signed())
+		require.InDelta(t, 0.25, vm.FloatSlot(0.25).Float(), 0)
+		require.Nil(t, vm.SignedSlot(-5).Ref)
+	})
+
+	t.Run("Convert", func(t *testing.T) {
+		t.Parallel()
+
+		values := []types.Value{types.Uint64(1), types.Int64(-1), types.Float64(0.5)}
+		require.Equal(t, values, vm.Values(vm.Slots(values...)))
+		require.Empty(t, vm.Values(vm.Slots()))
+	})
+}
@@ -0,0 +1,749 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"slices"
+
+	"github.com/tvarney/illvm/bytecode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// SnapshotVersion is the version of the format written by Thread.Snapshot.
+// Snapshots of any other version are rejected by Machine.RestoreThread.
+const SnapshotVersion = 1
+
+// snapshotMagic starts every snapshot.
+const snapshotMagic = "ILVS"
+
+// The kinds of slot in a snapshot.
+const (
+	slotVoid uint8 = iota
+	slotNumber
+	slotValue
+	slotRef
+)
+
+// Snapshot serializes the state of the thread, so that it may be continued
+// later by Machine.RestoreThread, possibly on another host.
+//
+// The snapshot holds the program counter, fuel, limits, stack and frames of
+// the thread, the state of its random number generator, its running
+// coroutines, a value handed to it by a send which it has yet to receive, and
+// every heap object reachable from them. It also holds a digest of the
+// bytecode, handlers, host function and global declarations of the machine,
+// which the machine restoring it must match. The values of globals, the cost table of a
+// metered thread and any finalizers are not part of the snapshot.
+//
+// Every numeric value and error may be serialized, as may channels,
+// coroutines and weak references; an error is restored holding only its
+// message. Other values, such as host values, result in a
+// NotSerializableError. The thread must not be running.
+func (t *Thread) Snapshot() ([]byte, error) {
+	if t.Machine == nil {
+		return nil, ErrNoMachine
+	}
+
+	var buf bytes.Buffer
+	e := &encoder{w: bytecode.NewWriter(&buf), err: nil}
+
+	digest := t.Machine.digest()
+	e.bytes([]byte(snapshotMagic))
+	e.u16(SnapshotVersion)
+	e.bytes(digest[:])
+
+	e.u64(t.Fuel)
+	e.u64(uint64(t.Limits.Stack))
+	e.u64(uint64(t.Limits.Frames))
+	e.u64(uint64(t.Limits.Heap))
+	e.u64(t.randomState())
+	e.context(coContext{stack: t.Stack, frames: t.Frames, pc: t.PC})
+	e.coroutine(t.coro)
+	e.delivery(t.delivered)
+
+	objects := t.reachable()
+	e.u32(uint32(len(objects)))
+	for _, handle := range objects {
+		v, _ := t.Machine.heap.Get(handle)
+		e.u32(uint32(handle))
+		e.object(v)
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// RestoreThread creates a thread of the machine from a snapshot written by
+// Thread.Snapshot, and schedules it to be run by Run as Spawn does.
+//
+// The heap objects of the snapshot are placed on the heap of the machine and
+// accounted to the restored thread. A weak reference whose target is not in
+// the snapshot is restored cleared.
+//
+// If the snapshot was not written by a machine with the same bytecode,
+// handlers, host function and global declarations ErrModuleMismatch is returned, and if it is of another
+// version of the format a SnapshotVersionError. A snapshot which can not be
+// read results in ErrInvalidSnapshot.
+func (m *Machine) RestoreThread(data []byte) (*Thread, error) {
+	d := &decoder{reader: bytes.NewReader(data), err: nil}
+	d.r = bytecode.NewReader(d.reader)
+
+	if magic := d.bytes(len(snapshotMagic)); d.err == nil && string(magic) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if version := d.u16(); d.err == nil && version != SnapshotVersion {
+		return nil, SnapshotVersionError{Version: int(version)}
+	}
+
+	digest := m.digest()
+	if sum := d.bytes(len(digest)); d.err == nil && !bytes.Equal(sum, digest[:]) {
+		return nil, ErrModuleMismatch
+	}
+
+	t := m.newThread()
+	t.Fuel = d.u64()
+	t.Limits = Limits{Stack: d.int(), Frames: d.int(), Heap: d.int()}
+	t.random, t.seeded = d.u64(), true
+	ctx := d.context()
+	coro := Handle(d.u32())
+	ch := Handle(d.u32())
+	var delivered Slot
+	if ch != 0 {
+		delivered = d.slot()
+	}
+
+	count := d.count(5)
+	handles := make([]Handle, count)
+	objects := make([]types.Value, count)
+	for i := range count {
+		handles[i] = Handle(d.u32())
+		objects[i] = d.object()
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if d.reader.Len() != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	r, err := t.restore(handles, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Stack, t.Frames, t.PC = r.slots(ctx.stack), ctx.frames, ctx.pc
+	if coro != 0 {
+		t.coro = r.coroutine(coro)
+		if t.coro == nil {
+			r.err = ErrInvalidSnapshot
+		}
+
+		for c := t.coro; c != nil; c = c.caller {
+			t.resumed += len(c.resumer.stack)
+		}
+	}
+
+	if ch != 0 {
+		c, ok := r.objects[ch].(*channel)
+		if !ok {
+			r.err = ErrInvalidSnapshot
+		}
+
+		t.delivered = &delivery{ch: c, value: r.slot(delivered)}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	m.spawn(t)
+
+	return t, nil
+}
+
+// digest returns a digest of the bytecode, handlers, host function and global
+// declarations of the machine, identifying the module a snapshot belongs to.
+func (m *Machine) digest() [sha256.Size]byte {
+	h := sha256.New()
+	e := &encoder{w: bytecode.NewWriter(h), err: nil}
+	e.u32(uint32(len(m.Data)))
+	e.bytes(m.Data)
+
+	e.u32(uint32(len(m.Handlers)))
+	for _, handler := range m.Handlers {
+		e.u64(uint64(handler.Start))
+		e.u64(uint64(handler.End))
+		e.u64(uint64(handler.Target))
+		e.u64(uint64(handler.Depth))
+	}
+
+	e.u32(uint32(len(m.Functions)))
+	for _, f := range m.Functions {
+		e.u8(boolByte(f.Deterministic))
+		e.string(f.Name)
+	}
+
+	e.u32(uint32(len(m.Globals)))
+	for _, g := range m.Globals {
+		e.u8(uint8(g.Type))
+		e.string(g.Name)
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+
+	return sum
+}
+
+// reachable returns the handles of every heap object reachable from the thread
+// in ascending order.
+func (t *Thread) reachable() []Handle {
+	seen := map[Handle]bool{}
+	pending := []Handle{}
+	visit := func(handle Handle) {
+		if !seen[handle] {
+			seen[handle] = true
+			pending = append(pending, handle)
+		}
+	}
+
+	t.TraceRoots(visit)
+	for len(pending) > 0 {
+		handle := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		v, ok := t.Machine.heap.Get(handle)
+		if !ok {
+			delete(seen, handle)
+			continue
+		}
+
+		if tracer, ok := v.(Tracer); ok {
+			tracer.Trace(visit)
+		}
+	}
+
+	handles := make([]Handle, 0, len(seen))
+	for handle := range seen {
+		handles = append(handles, handle)
+	}
+
+	slices.Sort(handles)
+
+	return handles
+}
+
+// encoder writes a snapshot. The first error is kept in err, and every write
+// after it is skipped.
+type encoder struct {
+	w   *bytecode.Writer
+	err error
+}
+
+func (e *encoder) u8(v uint8) {
+	if e.err == nil {
+		_, e.err = e.w.WriteU8(v)
+	}
+}
+
+func (e *encoder) u16(v uint16) {
+	if e.err == nil {
+		_, e.err = e.w.WriteU16(v)
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	if e.err == nil {
+		_, e.err = e.w.WriteU32(v)
+	}
+}
+
+func (e *encoder) u64(v uint64) {
+	if e.err == nil {
+		_, e.err = e.w.WriteU64(v)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.WriteBytes(b)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.u32(uint32(len(s)))
+	e.bytes([]byte(s))
+}
+
+// context writes a stack, its frames and a program counter.
+func (e *encoder) context(ctx coContext) {
+	e.u32(uint32(len(ctx.stack)))
+	for _, s := range ctx.stack {
+		e.slot(s)
+	}
+
+	e.u32(uint32(len(ctx.frames)))
+	for _, f := range ctx.frames {
+		e.u64(uint64(f.Base))
+		e.u64(uint64(f.Caller))
+		e.u64(uint64(f.Return))
+	}
+
+	e.u64(uint64(ctx.pc))
+}
+
+// coroutine writes the handle of the given coroutine, or 0 if it is nil.
+func (e *encoder) coroutine(c *coroutine) {
+	if c == nil {
+		e.u32(0)
+		return
+	}
+
+	e.u32(uint32(c.handle))
+}
+
+// delivery writes the handle of the channel of the given delivery followed by
+// the value delivered, or 0 if it is nil.
+func (e *encoder) delivery(d *delivery) {
+	if d == nil {
+		e.u32(0)
+		return
+	}
+
+	e.u32(uint32(d.ch.handle))
+	e.slot(d.value)
+}
+
+func (e *encoder) slot(s Slot) {
+	switch {
+	case s.Ref != nil:
+		e.u8(slotValue)
+		e.value(s.Ref)
+	case s.Type == typeid.Void:
+		e.u8(slotVoid)
+	case s.IsNumeric():
+		e.u8(slotNumber)
+		e.u8(uint8(s.Type))
+		e.u64(s.Bits)
+	default:
+		e.u8(slotRef)
+		e.u8(uint8(s.Type))
+		e.u32(uint32(s.Bits))
+	}
+}
+
+// value writes a value which is not a heap object of the machine.
+func (e *encoder) value(v types.Value) {
+	e.u8(uint8(v.ID()))
+
+	switch n := v.(type) {
+	case types.Uint8:
+		e.u8(uint8(n))
+	case types.Uint16:
+		e.u16(uint16(n))
+	case types.Uint32:
+		e.u32(uint32(n))
+	case types.Uint64:
+		e.u64(uint64(n))
+	case types.Int8:
+		e.u8(uint8(n))
+	case types.Int16:
+		e.u16(uint16(n))
+	case types.Int32:
+		e.u32(uint32(n))
+	case types.Int64:
+		e.u64(uint64(n))
+	case types.Float32:
+		e.u32(math.Float32bits(float32(n)))
+	case types.Float64:
+		e.u64(math.Float64bits(float64(n)))
+	case types.Error:
+		e.string(n.Error())
+	default:
+		if e.err == nil {
+			e.err = NotSerializableError{ID: v.ID()}
+		}
+	}
+}
+
+// object writes a heap object.
+func (e *encoder) object(v types.Value) {
+	switch obj := v.(type) {
+	case *channel:
+		e.u8(uint8(typeid.Channel))
+		e.u8(uint8(obj.elem))
+		e.u32(uint32(obj.capacity))
+		e.u8(boolByte(obj.closed))
+		e.u32(uint32(len(obj.buf)))
+		for _, s := range obj.buf {
+			e.slot(s)
+		}
+	case *coroutine:
+		e.u8(uint8(typeid.Coroutine))
+		e.u8(uint8(obj.state))
+		e.context(obj.saved)
+		e.context(obj.resumer)
+		e.coroutine(obj.caller)
+		e.u64(uint64(int64(obj.offset)))
+	case *weakRef:
+		e.u8(uint8(typeid.Weak))
+		e.slot(obj.target)
+	default:
+		e.value(v)
+	}
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// decoder reads a snapshot. The first error is kept in err, and every read
+// after it returns a zero value.
+type decoder struct {
+	reader *bytes.Reader
+	r      *bytecode.Reader
+	err    error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// check records ErrInvalidSnapshot if the given read error is not nil.
+func (d *decoder) check(err error) {
+	if err != nil {
+		d.fail(ErrInvalidSnapshot)
+	}
+}
+
+func (d *decoder) u8() uint8 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := d.r.ReadU8()
+	d.check(err)
+
+	return v
+}
+
+func (d *decoder) u16() uint16 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := d.r.ReadU16()
+	d.check(err)
+
+	return v
+}
+
+func (d *decoder) u32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := d.r.ReadU32()
+	d.check(err)
+
+	return v
+}
+
+func (d *decoder) u64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := d.r.ReadU64()
+	d.check(err)
+
+	return v
+}
+
+// int reads a non-negative int written as a u64.
+func (d *decoder) int() int {
+	v := d.u64()
+	if v > math.MaxInt32 {
+		d.fail(ErrInvalidSnapshot)
+		return 0
+	}
+
+	return int(v)
+}
+
+// count reads the number of items which follow, each at least size bytes
+// long, failing if there are not enough bytes left for them.
+func (d *decoder) count(size int) int {
+	n := int(d.u32())
+	if n*size > d.reader.Len() {
+		d.fail(ErrInvalidSnapshot)
+		return 0
+	}
+
+	return n
+}
+
+func (d *decoder) bytes(count int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	b, err := d.r.ReadBytes(count)
+	d.check(err)
+
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.count(1)))
+}
+
+// context reads a stack, its frames and a program counter.
+func (d *decoder) context() coContext {
+	stack := make([]Slot, d.count(1))
+	for i := range stack {
+		stack[i] = d.slot()
+	}
+
+	var frames []Frame
+	if n := d.count(24); n > 0 {
+		frames = make([]Frame, n)
+	}
+
+	// The base of each frame is at or above the base of the frame it was
+	// called from, and within the stack.
+	base := 0
+	for i := range frames {
+		frames[i] = Frame{Base: d.int(), Caller: d.int(), Return: d.int()}
+		if frames[i].Base < base || frames[i].Base > len(stack) {
+			d.fail(ErrInvalidSnapshot)
+		}
+
+		base = frames[i].Base
+	}
+
+	return coContext{stack: stack, frames: frames, pc: d.int()}
+}
+
+func (d *decoder) slot() Slot {
+	switch kind := d.u8(); kind {
+	case slotVoid:
+		return Slot{}
+	case slotNumber:
+		id, bits := typeid.ID(d.u8()), d.u64()
+		if id != typeid.Uint64 && id != typeid.Int64 && id != typeid.Float64 {
+			d.fail(ErrInvalidSnapshot)
+		}
+
+		return Slot{Ref: nil, Bits: bits, Type: id}
+	case slotValue:
+		return SlotOf(d.value(typeid.ID(d.u8())))
+	case slotRef:
+		id := typeid.ID(d.u8())
+		return RefSlot(Handle(d.u32()), id)
+	default:
+		d.fail(ErrInvalidSnapshot)
+		return Slot{}
+	}
+}
+
+// value reads a value with the given type which is not a heap object of the
+// machine.
+func (d *decoder) value(id typeid.ID) types.Value {
+	switch id {
+	case typeid.Uint8:
+		return types.Uint8(d.u8())
+	case typeid.Uint16:
+		return types.Uint16(d.u16())
+	case typeid.Uint32:
+		return types.Uint32(d.u32())
+	case typeid.Uint64:
+		return types.Uint64(d.u64())
+	case typeid.Int8:
+		return types.Int8(d.u8())
+	case typeid.Int16:
+		return types.Int16(d.u16())
+	case typeid.Int32:
+		return types.Int32(d.u32())
+	case typeid.Int64:
+		return types.Int64(d.u64())
+	case typeid.Float32:
+		return types.Float32(math.Float32frombits(d.u32()))
+	case typeid.Float64:
+		return types.Float64(math.Float64frombits(d.u64()))
+	case typeid.Error:
+		return types.Error{Err: errors.New(d.string())}
+	default:
+		d.fail(ErrInvalidSnapshot)
+		return types.Uint64(0)
+	}
+}
+
+// object reads a heap object. Slots of the object refer to the heap objects of
+// the snapshot by the handles they had when it was written.
+func (d *decoder) object() types.Value {
+	switch id := typeid.ID(d.u8()); id {
+	case typeid.Channel:
+		elem, capacity, closed := typeid.ID(d.u8()), int(d.u32()), d.u8() != 0
+		if capacity > math.MaxInt32 {
+			d.fail(ErrInvalidSnapshot)
+		}
+
+		buf := make([]Slot, d.count(1))
+		for i := range buf {
+			buf[i] = d.slot()
+		}
+
+		return &channel{elem: elem, capacity: capacity, buf: buf, closed: closed, handle: 0, waiters: nil}
+	case typeid.Coroutine:
+		state, saved, resumer := coState(d.u8()), d.context(), d.context()
+		if state > coDead {
+			d.fail(ErrInvalidSnapshot)
+		}
+
+		// The caller is held by its handle until the heap objects are
+		// restored.
+		caller := &coroutine{
+			state:   coDead,
+			handle:  Handle(d.u32()),
+			saved:   coContext{stack: nil, frames: nil, pc: 0},
+			resumer: coContext{stack: nil, frames: nil, pc: 0},
+			caller:  nil,
+			offset:  0,
+		}
+
+		return &coroutine{
+			state:   state,
+			handle:  0,
+			saved:   saved,
+			resumer: resumer,
+			caller:  caller,
+			offset:  int(int64(d.u64())),
+		}
+	case typeid.Weak:
+		return &weakRef{target: d.slot()}
+	default:
+		return d.value(id)
+	}
+}
+
+// restorer maps the handles of the heap objects of a snapshot to the handles
+// they were restored with. The first error is kept in err.
+type restorer struct {
+	handles map[Handle]Slot
+	objects map[Handle]types.Value
+	err     error
+}
+
+// restore places the given heap objects of a snapshot, which had the given
+// handles, on the heap of the machine of the thread, then updates the slots of
+// each to refer to the restored objects.
+func (t *Thread) restore(handles []Handle, objects []types.Value) (*restorer, error) {
+	r := &restorer{handles: map[Handle]Slot{}, objects: map[Handle]types.Value{}, err: nil}
+	for i, v := range objects {
+		if _, ok := r.handles[handles[i]]; ok || handles[i] == 0 {
+			return nil, ErrInvalidSnapshot
+		}
+
+		s, err := t.New(v)
+		if err != nil {
+			return nil, err
+		}
+
+		r.handles[handles[i]] = s
+		r.objects[handles[i]] = v
+	}
+
+	for old, v := range r.objects {
+		switch obj := v.(type) {
+		case *channel:
+			obj.handle, _ = r.handles[old].Handle()
+			obj.buf = r.slots(obj.buf)
+		case *coroutine:
+			obj.handle, _ = r.handles[old].Handle()
+			obj.saved.stack = r.slots(obj.saved.stack)
+			obj.resumer.stack = r.slots(obj.resumer.stack)
+			obj.caller = r.coroutine(obj.caller.handle)
+		case *weakRef:
+			if handle, ok := obj.target.Handle(); ok {
+				if _, found := r.handles[handle]; !found {
+					obj.target = Slot{}
+					continue
+				}
+			}
+
+			obj.target = r.slot(obj.target)
+		}
+	}
+
+	// The callers of a coroutine are the coroutines it was resumed from, which
+	// can not include itself.
+	for _, v := range r.objects {
+		c, _ := v.(*coroutine)
+		for steps := 0; c != nil; steps++ {
+			if steps > len(r.objects) {
+				r.err = ErrInvalidSnapshot
+				break
+			}
+
+			c = c.caller
+		}
+	}
+
+	return r, r.err
+}
+
+// slots returns the given slots of a snapshot referring to the restored heap
+// objects.
+func (r *restorer) slots(slots []Slot) []Slot {
+	for i, s := range slots {
+		slots[i] = r.slot(s)
+	}
+
+	return slots
+}
+
+func (r *restorer) slot(s Slot) Slot {
+	handle, ok := s.Handle()
+	if !ok {
+		return s
+	}
+
+	restored, found := r.handles[handle]
+	if !found || restored.Type != s.Type {
+		r.err = ErrInvalidSnapshot
+		return Slot{}
+	}
+
+	return restored
+}
+
+// coroutine returns the restored coroutine which had the given handle, or nil
+// if the handle is 0.
+func (r *restorer) coroutine(handle Handle) *coroutine {
+	if handle == 0 {
+		return nil
+	}
+
+	c, ok := r.objects[handle].(*coroutine)
+	if !ok {
+		r.err = ErrInvalidSnapshot
+	}
+
+	return c
+}
@@ -0,0 +1,246 @@
+package vm_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestThreadSnapshot(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: CoCreate 0x0B, Push 5, Dupe 1, CoResume, Jump 0xFF
+	// 0x0B: Call 0x11 with 1 arg, Return 1
+	// 0x10: Push 1, Add, CoYield, Push 2, Add, Return 1
+	coroutines := []uint8{
+		opCoCreate, 0x01, 0x0B, opPush, 0x85, opDupe, 0x01, opCoResume, opJump, 0x01, 0xFF,
+		opCall, 0x11, 0x10, opReturn, 0x01,
+		opPush, 0x81, opAdd, 0x00, opCoYield, opPush, 0x82, opAdd, 0x00, opReturn, 0x01,
+	}
+
+	for _, test := range []struct {
+		name  string
+		data  []uint8
+		steps int
+	}{
+		{"Start", bench.Fib(10), 0},
+		{"Frames", bench.Fib(10), 200},
+		{"Coroutine", coroutines, 6},
+		{"Yielded", coroutines, 8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &vm.Machine{Data: test.data}
+			th := m.Spawn(0)
+			for range test.steps {
+				require.NoError(t, th.Step())
+			}
+
+			data, err := th.Snapshot()
+			require.NoError(t, err)
+
+			restored := &vm.Machine{Data: slices.Clone(test.data)}
+			copied, err := restored.RestoreThread(data)
+			require.NoError(t, err)
+			require.Equal(t, th.PC, copied.PC)
+			require.Equal(t, th.Frames, copied.Frames)
+
+			require.NoError(t, m.Run())
+			require.NoError(t, restored.Run())
+			require.NoError(t, th.Err)
+			require.NoError(t, copied.Err)
+			require.Equal(t, vm.Values(th.Stack), vm.Values(copied.Stack))
+		})
+	}
+
+	t.Run("Values", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Send, Jump 0xFF
+		// 0x04: Recv
+		data := []uint8{opSend, opJump, 0x01, 0xFF, opRecv}
+		m := &vm.Machine{Data: data}
+		th := &vm.Thread{Machine: m}
+
+		dropped, err := th.New(types.Int8(-1))
+		require.NoError(t, err)
+
+		target, err := th.New(types.Uint8(7))
+		require.NoError(t, err)
+
+		cleared, err := th.NewWeak(dropped)
+		require.NoError(t, err)
+
+		weak, err := th.NewWeak(target)
+		require.NoError(t, err)
+
+		ch, err := th.NewChannel(typeid.Void, 2)
+		require.NoError(t, err)
+
+		th = m.Spawn(0, target, ch)
+		require.NoError(t, m.Run())
+
+		th.Stack = []vm.Slot{
+			vm.UnsignedSlot(1), vm.SignedSlot(-2), vm.FloatSlot(0.5), {},
+			vm.SlotOf(types.Error{Err: errors.New("boom")}), vm.SlotOf(types.Float32(1.5)),
+			cleared, weak, ch,
+		}
+		th.PC = 4
+		th.Fuel = 42
+		th.Limits = vm.Limits{Stack: 64, Frames: 8, Heap: 1024}
+
+		snapshot, err := th.Snapshot()
+		require.NoError(t, err)
+
+		restored := &vm.Machine{Data: data}
+		copied, err := restored.RestoreThread(snapshot)
+		require.NoError(t, err)
+		require.Equal(t, 1, copied.ID)
+		require.Equal(t, uint64(42), copied.Fuel)
+		require.Equal(t, th.Limits, copied.Limits)
+		require.Equal(t, vals(u64(1), i64(-2), f64(0.5), nil, types.Error{Err: errors.New("boom")}, f64(1.5)),
+			vm.Values(copied.Stack[:6]))
+
+		_, ok := restored.Deref(copied.Stack[6])
+		require.False(t, ok)
+
+		restoredTarget, ok := restored.Deref(copied.Stack[7])
+		require.True(t, ok)
+
+		v, ok := restored.Heap().Get(handle(t, restoredTarget))
+		require.True(t, ok)
+		require.Equal(t, types.Uint8(7), v)
+
+		require.NoError(t, restored.Run())
+		require.NoError(t, copied.Err)
+		require.Equal(t, restoredTarget, copied.Stack[len(copied.Stack)-2])
+	})
+
+	t.Run("Delivered", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Recv, Jump 0xFF
+		// 0x04: Push 8, Dupe 1, Send
+		data := []uint8{opRecv, opJump, 0x01, 0xFF, opPush, 0x88, opDupe, 0x01, opSend}
+		m := &vm.Machine{Data: data}
+		th := &vm.Thread{Machine: m}
+		ch, err := th.NewChannel(typeid.Uint64, 0)
+		require.NoError(t, err)
+
+		// The value sent is handed to the receiver, which is snapshot before it
+		// runs again to receive it.
+		recv := m.Spawn(0, ch)
+		send := m.Spawn(4, ch)
+		require.ErrorIs(t, recv.Step(), vm.ErrBlocked)
+		for range 3 {
+			require.NoError(t, send.Step())
+		}
+
+		snapshot, err := recv.Snapshot()
+		require.NoError(t, err)
+
+		restored := &vm.Machine{Data: data}
+		copied, err := restored.RestoreThread(snapshot)
+		require.NoError(t, err)
+		require.NoError(t, restored.Run())
+		require.NoError(t, copied.Err)
+		require.Equal(t, vals(u64(8), u64(1)), vm.Values(copied.Stack))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		data := []uint8{opPush, 0x81}
+		m := &vm.Machine{Data: data, Globals: globals}
+		snapshot, err := m.Spawn(0).Snapshot()
+		require.NoError(t, err)
+
+		version := slices.Clone(snapshot)
+		version[5] = 2
+
+		for _, test := range []struct {
+			name     string
+			machine  *vm.Machine
+			snapshot []byte
+			errval   testerr.ExpectedError
+		}{
+			{"Data", &vm.Machine{Data: []uint8{opPush, 0x82}, Globals: globals}, snapshot,
+				testerr.Is(vm.ErrModuleMismatch)},
+			{"Globals", &vm.Machine{Data: data, Globals: globals[:1]}, snapshot, testerr.Is(vm.ErrModuleMismatch)},
+			{"Handlers", &vm.Machine{Data: data, Globals: globals, Handlers: []vm.Handler{
+				{Start: 0, End: 2, Target: 0, Depth: 0},
+			}}, snapshot, testerr.Is(vm.ErrModuleMismatch)},
+			{"Functions", &vm.Machine{Data: data, Globals: globals, Functions: []vm.Function{
+				{Name: "now", Fn: nil, Deterministic: false},
+			}}, snapshot, testerr.Is(vm.ErrModuleMismatch)},
+			{"Version", &vm.Machine{Data: data, Globals: globals}, version,
+				testerr.Is(vm.SnapshotVersionError{Version: 2})},
+			{"Magic", &vm.Machine{Data: data, Globals: globals}, []byte("ILVM"), testerr.Is(vm.ErrInvalidSnapshot)},
+			{"Truncated", &vm.Machine{Data: data, Globals: globals}, snapshot[:len(snapshot)-1],
+				testerr.Is(vm.ErrInvalidSnapshot)},
+			{"Trailing", &vm.Machine{Data: data, Globals: globals}, append(slices.Clone(snapshot), 0),
+				testerr.Is(vm.ErrInvalidSnapshot)},
+		} {
+			_, err := test.machine.RestoreThread(test.snapshot)
+			test.errval.Require(t, err)
+		}
+
+		// Whether a host function is deterministic is part of the module.
+		functions := []vm.Function{{Name: "now", Fn: nil, Deterministic: false}}
+		deterministic := []vm.Function{{Name: "now", Fn: nil, Deterministic: true}}
+		snapshot, err = (&vm.Machine{Data: data, Functions: functions}).Spawn(0).Snapshot()
+		require.NoError(t, err)
+
+		_, err = (&vm.Machine{Data: data, Functions: deterministic}).RestoreThread(snapshot)
+		require.ErrorIs(t, err, vm.ErrModuleMismatch)
+
+		// The base of a frame may not be below that of the frame it was called
+		// from or above the top of the stack.
+		for _, frames := range [][]vm.Frame{
+			{{Base: 2, Caller: 0, Return: 0}, {Base: 1, Caller: 0, Return: 0}},
+			{{Base: 3, Caller: 0, Return: 0}},
+		} {
+			th := m.Spawn(0, vm.UnsignedSlot(1), vm.UnsignedSlot(2))
+			th.Frames = frames
+
+			snapshot, err := th.Snapshot()
+			require.NoError(t, err)
+
+			_, err = (&vm.Machine{Data: data, Globals: globals}).RestoreThread(snapshot)
+			require.ErrorIs(t, err, vm.ErrInvalidSnapshot)
+		}
+
+		// A running coroutine which is its own caller. The handle of its
+		// caller is the last but 8 bytes of the snapshot.
+		co := &vm.Machine{Data: coroutines}
+		th := co.Spawn(0)
+		for range 6 {
+			require.NoError(t, th.Step())
+		}
+
+		cycle, err := th.Snapshot()
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0}, cycle[len(cycle)-12:len(cycle)-8])
+
+		cycle[len(cycle)-9] = 1
+		_, err = (&vm.Machine{Data: coroutines}).RestoreThread(cycle)
+		require.ErrorIs(t, err, vm.ErrInvalidSnapshot)
+
+		host, err := (&vm.Thread{Machine: m}).NewHost(types.Host{Tag: 1, Value: nil}, nil)
+		require.NoError(t, err)
+
+		_, err = m.Spawn(0, host).Snapshot()
+		require.ErrorIs(t, err, vm.NotSerializableError{ID: typeid.Host})
+
+		_, err = (&vm.Thread{}).Snapshot()
+		require.ErrorIs(t, err, vm.ErrNoMachine)
+	})
+}
@@ -1,6 +1,9 @@
 package vm
 
 import (
+	"context"
+	"errors"
+
 	"github.com/tvarney/consterr"
 	"github.com/tvarney/illvm/opcode"
 	"github.com/tvarney/illvm/types"
@@ -16,49 +19,416 @@ const (
 	ErrOperationUndefined consterr.Error = "operation undefined"
 )
 
+// ContextCheckInterval is the number of opcodes Thread.RunContext runs between
+// checks of its context.
+const ContextCheckInterval = 1024
+
 // Thread is a single execution context of a illvm virtual machine.
 type Thread struct {
-	Machine *Machine
-	Stack   []types.Value
-	Data    []uint8
+	Machine  *Machine
+	Stack    []Slot
+	Frames   []Frame
+	Handlers []Handler
+	Data     []uint8
+	Program  *Program
 
 	PC int
+
+	// Costs is the fuel cost of each opcode. If Costs is nil the thread is not
+	// metered and Fuel is ignored.
+	Costs *opcode.Costs
+
+	// Fuel is the fuel left to a metered thread. Each opcode is charged its
+	// cost before it runs; an opcode which costs more than the fuel left is
+	// not run, and ErrOutOfFuel is returned. Refilling Fuel and running the
+	// thread again resumes it from that opcode.
+	Fuel uint64
+
+	// Limits bounds the memory used by the thread. Exceeding a limit raises a
+	// LimitError fault.
+	Limits Limits
+
+	// HeapUsed is the number of bytes of heap objects held by the thread. See
+	// Allocate.
+	HeapUsed int
+
+	// ID is the ID of a thread created by Machine.Spawn, or 0.
+	ID int
+
+	// State is the scheduling state of the thread, and Err the error which
+	// stopped it once it is done. See Machine.Run.
+	State ThreadState
+	Err   error
+
+	yielded   bool
+	wait      *wait
+	waiters   []*Thread
+	delivered *delivery
+	coro      *coroutine
+	resumed   int
+	random    uint64
+	seeded    bool
 }
 
 // Run runs the opcodes in the given bytecode data until an error occurs.
+//
+// Compiled functions of the thread's Program are run by following their
+// closures directly rather than one Step at a time, unless the thread is
+// metered.
 func (t *Thread) Run() error {
-	err := t.Step()
-	for err == nil {
-		err = t.Step()
+	for {
+		if _, err := t.runCompiled(-1); err != nil {
+			return err
+		}
+
+		if err := t.Step(); err != nil {
+			return err
+		}
 	}
+}
 
-	return err
+// RunContext runs the thread as Run does until an error occurs or the given
+// context is done.
+//
+// The context is checked before running and then every ContextCheckInterval
+// opcodes. Once it is done an InterruptedError holding the error of the
+// context is returned, and the thread is left ready to run the next opcode.
+func (t *Thread) RunContext(ctx context.Context) error {
+	done := ctx.Done()
+	if done == nil {
+		return t.Run()
+	}
+
+	for {
+		select {
+		case <-done:
+			return InterruptedError{Offset: t.PC, Err: ctx.Err()}
+		default:
+		}
+
+		if err := t.RunFor(ContextCheckInterval); err != nil {
+			return err
+		}
+	}
 }
 
-// RunFor runs the next `step` opcodes.
+// RunFor runs the next `steps` opcodes, following the closures of compiled
+// functions as Run does.
 func (t *Thread) RunFor(steps int) error {
-	for range steps {
+	for steps > 0 {
+		ran, err := t.runCompiled(steps)
+		if err != nil {
+			return err
+		}
+
+		steps -= ran
+		if steps == 0 {
+			break
+		}
+
 		if err := t.Step(); err != nil {
 			return err
 		}
+
+		steps--
 	}
 
 	return nil
 }
 
 // Step fetches the next opcode and any associated data and executes it.
+//
+// If the thread has a Program and an instruction of it starts at the program
+// counter, the pre-decoded instruction is executed instead of reading the
+// bytecode, using its compiled form if it has one.
+//
+// If executing the opcode results in a VM fault, the fault is raised as an
+// exception holding a types.Error value.
 func (t *Thread) Step() error {
 	if t.PC < 0 || t.PC >= len(t.Data) {
 		return ErrBytecodeOverflow
 	}
 
-	op := t.Data[t.PC]
+	start := t.PC
+
+	idx, ok := t.Program.Lookup(start)
+	if !ok {
+		if t.Costs != nil {
+			if err := t.spend(t.Costs[t.Data[start]]); err != nil {
+				return err
+			}
+		}
+
+		var ins Instruction
+		if err := t.decode(&ins); err != nil {
+			return t.fault(start, &ins, err)
+		}
+
+		if err := t.execute(&ins); err != nil {
+			return t.fault(start, &ins, err)
+		}
+
+		return nil
+	}
+
+	ins := &t.Program.code[idx]
+	if t.Costs != nil {
+		// Only the first opcode of a superinstruction is run if the fuel left
+		// does not cover both, so fuel runs out at the same opcode as it
+		// would if the program were not fused.
+		if ins.Fused != opcode.NoOp && t.Fuel < t.cost(ins) {
+			ins = &t.Program.Instructions[idx]
+		}
+
+		return t.Exec(ins)
+	}
+
+	fn := t.Program.closure(idx)
+	if fn == nil {
+		return t.Exec(ins)
+	}
+
+	if _, err := fn(t); err != nil {
+		return t.fault(start, ins, err)
+	}
+
+	return nil
+}
+
+// Exec runs the given decoded instruction as Step would. The instruction must
+// start at the program counter.
+//
+// Exec is used by code translated from bytecode ahead of time, so that it
+// behaves exactly like the interpreter.
+func (t *Thread) Exec(ins *Instruction) error {
+	if t.Costs != nil {
+		if err := t.spend(t.cost(ins)); err != nil {
+			return err
+		}
+	}
+
+	start := t.PC
+	t.PC = ins.Next
+
+	if err := t.execute(ins); err != nil {
+		return t.fault(start, ins, err)
+	}
+
+	return nil
+}
+
+// runCompiled runs compiled instructions from the program counter until it
+// reaches an instruction which has not been compiled or has run limit of
+// them, returning the number run. A negative limit runs any number.
+func (t *Thread) runCompiled(limit int) (int, error) {
+	p := t.Program
+	if p == nil || t.Costs != nil {
+		return 0, nil
+	}
+
+	closures := p.compiled()
+	if closures == nil {
+		return 0, nil
+	}
+
+	ran := 0
+
+	idx, ok := p.Lookup(t.PC)
+	for ok && ran != limit {
+		fn := closures[idx]
+		if fn == nil {
+			return ran, nil
+		}
+
+		start := t.PC
+		next, err := fn(t)
+		ran++
+
+		if err != nil {
+			if err := t.fault(start, &p.code[idx], err); err != nil {
+				return ran, err
+			}
+
+			next = -1
+		}
+
+		if next >= 0 {
+			idx = next
+		} else {
+			idx, ok = p.Lookup(t.PC)
+		}
+	}
+
+	return ran, nil
+}
+
+// fault handles an error returned by the instruction which started at the
+// given offset, raising it as an exception if it is catchable.
+//
+// A fault of a PushArith is raised from the arithmetic opcode, unless the Push
+// fused into it faulted. The Push is then the instruction which faulted, and
+// the program counter is left after it, as if the opcodes were not fused.
+func (t *Thread) fault(start int, ins *Instruction, err error) error {
+	if ins.Op == opcode.PushArith {
+		var push pushFault
+		if errors.As(err, &push) {
+			err, t.PC = push.err, ins.FusedOffset
+		} else {
+			start = ins.FusedOffset
+		}
+	}
+
+	if catchable(err) {
+		return t.raise(start, types.Error{Err: err})
+	}
+
+	return err
+}
+
+// Predecode decodes the bytecode of the thread into a Program used by Step,
+// fusing common sequences of opcodes into superinstructions.
+//
+// The program must be rebuilt if the bytecode of the thread is changed.
+func (t *Thread) Predecode() {
+	t.Program = Decode(t.Data)
+	t.Program.Fuse()
+}
+
+// decode reads the opcode at the program counter along with its control byte
+// and immediates.
+func (t *Thread) decode(ins *Instruction) error {
+	ins.Op, ins.Offset = opcode.ID(t.Data[t.PC]), t.PC
 	t.PC++
 
-	switch opcode.ID(op) {
+	var err error
+	switch ins.Op {
+	case opcode.NoOp, opcode.Throw, opcode.Yield, opcode.Join,
+		opcode.Send, opcode.Recv, opcode.TryRecv, opcode.Close,
+		opcode.CoResume, opcode.CoYield, opcode.Random, opcode.Time,
+		opcode.Weak, opcode.Deref:
+	case opcode.Push:
+		err = t.decodePush(ins)
+	case opcode.Dupe:
+		err = t.decodeDupe(ins)
+	case opcode.Pop, opcode.Return:
+		ins.Control, err = t.fetchControl()
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod:
+		err = t.decodeArith(ins)
+	case opcode.Jump, opcode.JumpIf, opcode.Call, opcode.CoCreate:
+		err = t.decodeJump(ins)
+	case opcode.Cast:
+		err = t.decodeCast(ins)
+	case opcode.Compare:
+		err = t.decodeCompare(ins)
+	case opcode.Chan:
+		err = t.decodeChan(ins)
+	case opcode.Select:
+		err = t.decodeSelect(ins)
+	case opcode.LoadGlobal, opcode.StoreGlobal, opcode.LoadLocal, opcode.StoreLocal,
+		opcode.CallHost:
+		err = t.decodeVar(ins)
+	default:
+		err = ErrOperationUndefined
+	}
+
+	ins.Next = t.PC
+
+	return err
+}
+
+func (t *Thread) execute(ins *Instruction) error {
+	switch ins.Op {
 	case opcode.NoOp:
 		return nil
+	case opcode.Push:
+		if err := t.grow(); err != nil {
+			return err
+		}
+
+		t.push(ins.Value)
+
+		return nil
+	case opcode.Dupe:
+		return t.opDupe(ins)
+	case opcode.Pop:
+		return t.opPop(ins)
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.FDiv,
+		opcode.Mod, opcode.DivMod:
+		return t.opArith(ins)
+	case opcode.PushArith:
+		return t.opPushArith(ins)
+	case opcode.CompareJumpIf:
+		return t.opCompareJumpIf(ins)
+	case opcode.Jump:
+		t.PC = ins.Target
+		return nil
+	case opcode.JumpIf:
+		return t.opJumpIf(ins)
+	case opcode.Call:
+		return t.opCall(ins)
+	case opcode.Return:
+		return t.opReturn(ins)
+	case opcode.Throw:
+		return t.opThrow(ins)
+	case opcode.Cast:
+		return t.opCast(ins)
+	case opcode.Compare:
+		return t.opCompare(ins)
+	case opcode.Yield:
+		return t.opYield()
+	case opcode.Join:
+		return t.opJoin(ins)
+	case opcode.Chan:
+		return t.opChan(ins)
+	case opcode.Send:
+		return t.opSend(ins)
+	case opcode.Recv, opcode.TryRecv:
+		return t.opRecv(ins)
+	case opcode.Close:
+		return t.opClose()
+	case opcode.Select:
+		return t.opSelect(ins)
+	case opcode.CoCreate:
+		return t.opCoCreate(ins)
+	case opcode.CoResume:
+		return t.opCoResume(ins)
+	case opcode.CoYield:
+		return t.opCoYield()
+	case opcode.LoadGlobal, opcode.StoreGlobal:
+		return t.opGlobal(ins)
+	case opcode.LoadLocal, opcode.StoreLocal:
+		return t.opLocal(ins)
+	case opcode.CallHost:
+		return t.opCallHost(ins)
+	case opcode.Random:
+		return t.opRandom()
+	case opcode.Time:
+		return t.opTime()
+	case opcode.Weak:
+		return t.opWeak()
+	case opcode.Deref:
+		return t.opDeref()
 	default:
 		return ErrOperationUndefined
 	}
 }
+
+// catchable returns if the given error may be handled by an exception
+// handler.
+//
+// Errors which indicate malformed bytecode, and exceptions which were already
+// found to be uncaught, are never catchable.
+func catchable(err error) bool {
+	for _, target := range []error{
+		ErrBytecodeOverflow, ErrOperationUndefined, ErrNotEnoughBytes,
+		ErrInvalidImmediateSize, ErrInvalidControl, ErrUncaughtException,
+		ErrBlocked,
+	} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,370 @@
+package vm
+
+import (
+	"errors"
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm/vmath"
+)
+
+const (
+	arithModeMask     = 0xC0
+	arithModeWrap     = 0x00
+	arithModeTrap     = 0x40
+	arithModeSaturate = 0x80
+	arithReservedMask = 0x3F
+)
+
+// decodeArith reads the control byte of one of the binary arithmetic opcodes.
+func (t *Thread) decodeArith(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control&arithReservedMask != 0 || control&arithModeMask == arithModeMask {
+		return InvalidControlError{Op: ins.Op, Control: control}
+	}
+
+	return nil
+}
+
+// opArith runs one of the binary arithmetic opcodes.
+//
+// The operands are promoted to a common type before the operation; if either
+// is a float both are floats, otherwise if either is signed both are signed.
+// The mode of the control byte decides what happens when an integer result
+// does not fit in its type.
+func (t *Thread) opArith(ins *Instruction) error {
+	left, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	mode := ins.Control & arithModeMask
+	if ins.Operands != typeid.Void && t.typedArith(ins.Op, mode, ins.Operands, left) {
+		return nil
+	}
+
+	return t.arithWith(ins.Op, mode, left)
+}
+
+// opPushArith runs a Push fused with the arithmetic opcode which follows it.
+//
+// The value pushed is never placed on the stack, but the stack limit is still
+// checked for it. A fault of the Push is returned as a pushFault, which fault
+// raises from the Push rather than from the arithmetic opcode.
+func (t *Thread) opPushArith(ins *Instruction) error {
+	if err := t.grow(); err != nil {
+		return pushFault{err: err}
+	}
+
+	mode := ins.FusedControl & arithModeMask
+	if ins.Operands != typeid.Void && t.typedArith(ins.Fused, mode, ins.Operands, ins.Value) {
+		return nil
+	}
+
+	return t.arithWith(ins.Fused, mode, ins.Value)
+}
+
+// pushFault is an error of the Push half of a PushArith.
+type pushFault struct {
+	err error
+}
+
+func (e pushFault) Error() string {
+	return e.err.Error()
+}
+
+func (e pushFault) Unwrap() error {
+	return e.err
+}
+
+// arithWith runs the arithmetic opcode op with the given left operand, taking
+// the right operand from the stack.
+func (t *Thread) arithWith(op opcode.ID, mode uint8, left Slot) error {
+	right, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	if op == opcode.DivMod {
+		q, r, err := divMod(mode, left, right)
+		if err != nil {
+			return err
+		}
+
+		t.push(t.canonical(q))
+		t.push(t.canonical(r))
+
+		return nil
+	}
+
+	result, err := arith(op, mode, left, right)
+	if err != nil {
+		return err
+	}
+
+	t.push(t.canonical(result))
+
+	return nil
+}
+
+// arith returns the result of the arithmetic opcode op applied to the given
+// operands.
+func arith(op opcode.ID, mode uint8, left, right Slot) (Slot, error) {
+	kind, err := commonKind(left, right)
+	if err != nil {
+		return Slot{}, err
+	}
+
+	switch kind {
+	case typeid.Float64:
+		return FloatSlot(floatArith(op, toFloat(left), toFloat(right))), nil
+	case typeid.Int64:
+		a, aOverflow := toSigned(mode, left)
+		b, bOverflow := toSigned(mode, right)
+		if (aOverflow || bOverflow) && mode == arithModeTrap {
+			return Slot{}, overflowError(op, left, right)
+		}
+
+		result, overflow, err := signedArith(op, mode, a, b)
+		if err != nil {
+			return Slot{}, err
+		}
+
+		if overflow && mode == arithModeTrap {
+			return Slot{}, overflowError(op, left, right)
+		}
+
+		return SignedSlot(result), nil
+	default:
+		result, overflow, err := unsignedArith(op, mode, left.Unsigned(), right.Unsigned())
+		if err != nil {
+			return Slot{}, err
+		}
+
+		if overflow && mode == arithModeTrap {
+			return Slot{}, overflowError(op, left, right)
+		}
+
+		return UnsignedSlot(result), nil
+	}
+}
+
+// divMod returns the floored quotient and remainder of the given operands.
+func divMod(mode uint8, left, right Slot) (Slot, Slot, error) {
+	kind, err := commonKind(left, right)
+	if err != nil {
+		return Slot{}, Slot{}, err
+	}
+
+	switch kind {
+	case typeid.Float64:
+		a, b := toFloat(left), toFloat(right)
+		return FloatSlot(math.Floor(a / b)), FloatSlot(floorMod(a, b)), nil
+	case typeid.Int64:
+		a, aOverflow := toSigned(mode, left)
+		b, bOverflow := toSigned(mode, right)
+		if (aOverflow || bOverflow) && mode == arithModeTrap {
+			return Slot{}, Slot{}, overflowError(opcode.DivMod, left, right)
+		}
+
+		q, r, err := vmath.FloorDivI64(a, b)
+		q, overflow, err := signedQuotient(q, mode == arithModeSaturate, err)
+		if err != nil {
+			return Slot{}, Slot{}, err
+		}
+
+		if overflow && mode == arithModeTrap {
+			return Slot{}, Slot{}, overflowError(opcode.DivMod, left, right)
+		}
+
+		return SignedSlot(q), SignedSlot(r), nil
+	default:
+		q, r, err := vmath.DivU64(left.Unsigned(), right.Unsigned())
+		if err != nil {
+			return Slot{}, Slot{}, err
+		}
+
+		return UnsignedSlot(q), UnsignedSlot(r), nil
+	}
+}
+
+// overflowError returns the error for an arithmetic opcode which overflowed.
+func overflowError(op opcode.ID, left, right Slot) error {
+	return ArithmeticOverflowError{Op: op, Left: left.Value(), Right: right.Value()}
+}
+
+// commonKind returns the stack type both operands are promoted to.
+func commonKind(left, right Slot) (typeid.ID, error) {
+	switch {
+	case !left.IsNumeric():
+		return typeid.Void, UnexpectedTypeError{ID: left.Type}
+	case !right.IsNumeric():
+		return typeid.Void, UnexpectedTypeError{ID: right.Type}
+	case left.Type == typeid.Float64 || right.Type == typeid.Float64:
+		return typeid.Float64, nil
+	case left.Type == typeid.Int64 || right.Type == typeid.Int64:
+		return typeid.Int64, nil
+	default:
+		return typeid.Uint64, nil
+	}
+}
+
+// toFloat converts a numeric slot to a float64.
+func toFloat(s Slot) float64 {
+	switch s.Type {
+	case typeid.Uint64:
+		return float64(s.Unsigned())
+	case typeid.Int64:
+		return float64(s.Signed())
+	default:
+		return s.Float()
+	}
+}
+
+// toSigned converts an integer slot to an int64.
+//
+// An unsigned value too large for an int64 wraps, or is clamped when the mode
+// is saturating, and is reported as an overflow.
+func toSigned(mode uint8, s Slot) (int64, bool) {
+	if s.Type != typeid.Uint64 || s.Unsigned() <= math.MaxInt64 {
+		return s.Signed(), false
+	}
+
+	if mode == arithModeSaturate {
+		return math.MaxInt64, true
+	}
+
+	return s.Signed(), true
+}
+
+// floatArith returns the result of the float arithmetic opcode op. Each result
+// is rounded once from a single operation, so a multiply and add are never
+// fused into one whatever the host.
+func floatArith(op opcode.ID, a, b float64) float64 {
+	switch op {
+	case opcode.Add:
+		return a + b
+	case opcode.Sub:
+		return a - b
+	case opcode.Mul:
+		return a * b
+	case opcode.Div:
+		return a / b
+	case opcode.FDiv:
+		return math.Floor(a / b)
+	default:
+		return math.Mod(a, b)
+	}
+}
+
+// floorMod returns the remainder of a / b with the sign of b.
+func floorMod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+	}
+
+	return r
+}
+
+func signedArith(op opcode.ID, mode uint8, a, b int64) (int64, bool, error) {
+	saturate := mode == arithModeSaturate
+
+	switch op {
+	case opcode.Add:
+		if saturate {
+			return vmath.AddSatI64(a, b), false, nil
+		}
+
+		r, overflow := vmath.AddI64(a, b)
+
+		return r, overflow, nil
+	case opcode.Sub:
+		if saturate {
+			return vmath.SubSatI64(a, b), false, nil
+		}
+
+		r, overflow := vmath.SubI64(a, b)
+
+		return r, overflow, nil
+	case opcode.Mul:
+		if saturate {
+			return vmath.MulSatI64(a, b), false, nil
+		}
+
+		r, overflow := vmath.MulI64(a, b)
+
+		return r, overflow, nil
+	case opcode.Div:
+		q, _, err := vmath.DivI64(a, b)
+		return signedQuotient(q, saturate, err)
+	case opcode.FDiv:
+		q, _, err := vmath.FloorDivI64(a, b)
+		return signedQuotient(q, saturate, err)
+	default:
+		// The remainder of the overflowing division is 0, which is correct.
+		_, r, err := vmath.DivI64(a, b)
+		if errors.Is(err, vmath.ErrOverflow) {
+			err = nil
+		}
+
+		return r, false, err
+	}
+}
+
+// signedQuotient converts the result of a signed division into the result of
+// an arithmetic opcode.
+func signedQuotient(q int64, saturate bool, err error) (int64, bool, error) {
+	if !errors.Is(err, vmath.ErrOverflow) {
+		return q, false, err
+	}
+
+	if saturate {
+		return math.MaxInt64, false, nil
+	}
+
+	return q, true, nil
+}
+
+func unsignedArith(op opcode.ID, mode uint8, a, b uint64) (uint64, bool, error) {
+	saturate := mode == arithModeSaturate
+
+	switch op {
+	case opcode.Add:
+		if saturate {
+			return vmath.AddSatU64(a, b), false, nil
+		}
+
+		r, overflow := vmath.AddU64(a, b)
+
+		return r, overflow, nil
+	case opcode.Sub:
+		if saturate {
+			return vmath.SubSatU64(a, b), false, nil
+		}
+
+		r, overflow := vmath.SubU64(a, b)
+
+		return r, overflow, nil
+	case opcode.Mul:
+		if saturate {
+			return vmath.MulSatU64(a, b), false, nil
+		}
+
+		r, overflow := vmath.MulU64(a, b)
+
+		return r, overflow, nil
+	case opcode.Mod:
+		_, r, err := vmath.DivU64(a, b)
+		return r, false, err
+	default:
+		q, _, err := vmath.DivU64(a, b)
+		return q, false, err
+	}
+}
@@ -0,0 +1,144 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/illvm/vm/vmath"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opAdd    = uint8(opcode.Add)
+	opSub    = uint8(opcode.Sub)
+	opMul    = uint8(opcode.Mul)
+	opDiv    = uint8(opcode.Div)
+	opFDiv   = uint8(opcode.FDiv)
+	opMod    = uint8(opcode.Mod)
+	opDivMod = uint8(opcode.DivMod)
+
+	modeWrap     = 0x00
+	modeTrap     = 0x40
+	modeSaturate = 0x80
+)
+
+func TestThreadArith(t *testing.T) {
+	t.Parallel()
+
+	overflow := func(op opcode.ID, left, right types.Value) testerr.ExpectedError {
+		return testerr.Is(vm.ArithmeticOverflowError{Op: op, Left: left, Right: right})
+	}
+
+	errZero := testerr.Is(vmath.ErrDivideByZero)
+
+	for _, test := range []struct {
+		name     string
+		op       uint8
+		control  uint8
+		left     types.Value
+		right    types.Value
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		// Operand order and promotion
+		{"Sub/Order", opSub, modeWrap, u64(10), u64(3), vals(u64(7)), errOverflow},
+		{"Add/Unsigned", opAdd, modeWrap, u64(1), u64(2), vals(u64(3)), errOverflow},
+		{"Add/Mixed", opAdd, modeWrap, u64(1), i64(-2), vals(i64(-1)), errOverflow},
+		{"Add/Float", opAdd, modeWrap, i64(1), f64(0.5), vals(f64(1.5)), errOverflow},
+		{"Add/Error", opAdd, modeWrap, types.Error{}, i64(1), vals(), testerr.Is(vm.ErrUnexpectedType)},
+		// Wrapping
+		{"Add/Wrap/Signed", opAdd, modeWrap, i64(math.MaxInt64), i64(1), vals(i64(math.MinInt64)), errOverflow},
+		{"Sub/Wrap/Unsigned", opSub, modeWrap, u64(0), u64(1), vals(u64(math.MaxUint64)), errOverflow},
+		{"Mul/Wrap/Signed", opMul, modeWrap, i64(math.MinInt64), i64(-1), vals(i64(math.MinInt64)), errOverflow},
+		{"Add/Wrap/Mixed", opAdd, modeWrap, u64(math.MaxUint64), i64(1), vals(i64(0)), errOverflow},
+		// Trapping
+		{"Add/Trap", opAdd, modeTrap, i64(1), i64(2), vals(i64(3)), errOverflow},
+		{
+			"Add/Trap/Signed", opAdd, modeTrap, i64(math.MaxInt64), i64(1), vals(),
+			overflow(opcode.Add, i64(math.MaxInt64), i64(1)),
+		},
+		{"Sub/Trap/Unsigned", opSub, modeTrap, u64(0), u64(1), vals(), overflow(opcode.Sub, u64(0), u64(1))},
+		{"Mul/Trap/Unsigned", opMul, modeTrap, u64(1 << 32), u64(1 << 32), vals(), overflow(opcode.Mul, u64(1<<32), u64(1<<32))},
+		{"Add/Trap/Mixed", opAdd, modeTrap, u64(math.MaxUint64), i64(0), vals(), overflow(opcode.Add, u64(math.MaxUint64), i64(0))},
+		{"Div/Trap", opDiv, modeTrap, i64(math.MinInt64), i64(-1), vals(), overflow(opcode.Div, i64(math.MinInt64), i64(-1))},
+		{"Add/Trap/Float", opAdd, modeTrap, f64(math.MaxFloat64), f64(math.MaxFloat64), vals(f64(math.Inf(1))), errOverflow},
+		// Saturating
+		{"Add/Saturate/Signed", opAdd, modeSaturate, i64(math.MaxInt64), i64(1), vals(i64(math.MaxInt64)), errOverflow},
+		{"Sub/Saturate/Signed", opSub, modeSaturate, i64(math.MinInt64), i64(1), vals(i64(math.MinInt64)), errOverflow},
+		{"Sub/Saturate/Unsigned", opSub, modeSaturate, u64(1), u64(2), vals(u64(0)), errOverflow},
+		{"Mul/Saturate/Signed", opMul, modeSaturate, i64(-(1 << 40)), i64(1 << 40), vals(i64(math.MinInt64)), errOverflow},
+		{"Add/Saturate/Mixed", opAdd, modeSaturate, u64(math.MaxUint64), i64(-1), vals(i64(math.MaxInt64 - 1)), errOverflow},
+		{"Div/Saturate", opDiv, modeSaturate, i64(math.MinInt64), i64(-1), vals(i64(math.MaxInt64)), errOverflow},
+		// Division
+		{"Div/Signed", opDiv, modeWrap, i64(-7), i64(2), vals(i64(-3)), errOverflow},
+		{"Div/Unsigned", opDiv, modeWrap, u64(7), u64(2), vals(u64(3)), errOverflow},
+		{"Div/Float", opDiv, modeWrap, f64(-7), f64(2), vals(f64(-3.5)), errOverflow},
+		{"Div/Float/Zero", opDiv, modeWrap, f64(1), f64(0), vals(f64(math.Inf(1))), errOverflow},
+		{"Div/Zero", opDiv, modeWrap, i64(1), i64(0), vals(), errZero},
+		{"FDiv/Signed", opFDiv, modeWrap, i64(-7), i64(2), vals(i64(-4)), errOverflow},
+		{"FDiv/Unsigned", opFDiv, modeWrap, u64(7), u64(2), vals(u64(3)), errOverflow},
+		{"FDiv/Float", opFDiv, modeWrap, f64(-7), f64(2), vals(f64(-4)), errOverflow},
+		{"FDiv/Zero", opFDiv, modeTrap, u64(7), u64(0), vals(), errZero},
+		{"Mod/Signed", opMod, modeWrap, i64(-7), i64(2), vals(i64(-1)), errOverflow},
+		{"Mod/Unsigned", opMod, modeWrap, u64(7), u64(2), vals(u64(1)), errOverflow},
+		{"Mod/Float", opMod, modeWrap, f64(-7), f64(2), vals(f64(-1)), errOverflow},
+		{"Mod/MinInt", opMod, modeTrap, i64(math.MinInt64), i64(-1), vals(i64(0)), errOverflow},
+		{"Mod/Zero", opMod, modeWrap, i64(7), i64(0), vals(), errZero},
+		{"DivMod/Signed", opDivMod, modeWrap, i64(-7), i64(2), vals(i64(-4), i64(1)), errOverflow},
+		{"DivMod/Unsigned", opDivMod, modeWrap, u64(7), u64(2), vals(u64(3), u64(1)), errOverflow},
+		{"DivMod/Float", opDivMod, modeWrap, f64(7), f64(-2), vals(f64(-4), f64(-1)), errOverflow},
+		{"DivMod/Zero", opDivMod, modeWrap, u64(7), u64(0), vals(), errZero},
+		{
+			"DivMod/Trap", opDivMod, modeTrap, i64(math.MinInt64), i64(-1), vals(),
+			overflow(opcode.DivMod, i64(math.MinInt64), i64(-1)),
+		},
+		{"DivMod/Saturate", opDivMod, modeSaturate, i64(math.MinInt64), i64(-1), vals(i64(math.MaxInt64), i64(0)), errOverflow},
+		// Control bytes
+		{"BadMode", opAdd, 0xC0, u64(1), u64(1), vals(u64(1), u64(1)), testerr.Is(vm.ErrInvalidControl)},
+		{"Reserved", opAdd, 0x01, u64(1), u64(1), vals(u64(1), u64(1)), testerr.Is(vm.ErrInvalidControl)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{
+				Stack: vm.Slots(test.right, test.left),
+				Data:  []uint8{test.op, test.control},
+			}
+
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+
+	t.Run("Underflow", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Stack: vm.Slots(u64(1)), Data: []uint8{opAdd, modeWrap}}
+		testerr.Is(vm.ErrStackUnderflow).Require(t, th.Run())
+	})
+
+	t.Run("CaughtDivideByZero", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{
+			Stack:    vm.Slots(u64(0), u64(1)),
+			Data:     []uint8{opDiv, modeWrap},
+			Handlers: []vm.Handler{{Start: 0, End: 2, Target: 2, Depth: 0}},
+		}
+
+		errOverflow.Require(t, th.Run())
+		requireStack(t, vals(types.Error{Err: vmath.ErrDivideByZero}), th.Stack)
+	})
+}
+
+func TestArithmeticOverflowError(t *testing.T) {
+	t.Parallel()
+
+	err := vm.ArithmeticOverflowError{Op: opcode.Add, Left: i64(math.MaxInt64), Right: u64(1)}
+	require.Equal(t, "arithmetic overflow in Add of int64 9223372036854775807 and uint64 1", err.Error())
+	require.ErrorIs(t, err, vm.ErrArithmeticOverflow)
+}
@@ -0,0 +1,100 @@
+package vm_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/tvarney/illvm/vm"
+)
+
+// countdown returns bytecode which decrements an i16 counter from n to 0.
+//
+//	0x00: Push i16 n
+//	0x04: Push i8 -1, Add, Dupe 0, JumpIf 0x04
+func countdown(n int16) []uint8 {
+	return []uint8{
+		opPush, 0x12, uint8(n >> 8), uint8(n),
+		opPush, 0x11, 0xFF, opAdd, modeWrap, opDupe, 0x00, opJumpIf, 0x01, 0x04,
+	}
+}
+
+func BenchmarkThreadPushPop(b *testing.B) {
+	th := &vm.Thread{Data: []uint8{opPush, 0x81, opPush, 0x11, 0xFE, opPop, 0x81}}
+
+	b.ReportAllocs()
+
+	for range b.N {
+		th.PC = 0
+		if err := th.RunFor(3); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThreadAddLoop(b *testing.B) {
+	th := &vm.Thread{Data: countdown(1000)}
+
+	b.ReportAllocs()
+
+	for range b.N {
+		th.PC = 0
+		th.Stack = th.Stack[:0]
+
+		if err := th.Run(); !errors.Is(err, vm.ErrBytecodeOverflow) {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThreadAddLoopPredecoded(b *testing.B) {
+	th := &vm.Thread{Data: countdown(1000)}
+	th.Predecode()
+
+	b.ReportAllocs()
+
+	for range b.N {
+		th.PC = 0
+		th.Stack = th.Stack[:0]
+
+		if err := th.Run(); !errors.Is(err, vm.ErrBytecodeOverflow) {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFetchUnsigned(b *testing.B) {
+	data := []uint8{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	for size := 1; size <= 8; size++ {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			th := &vm.Thread{Data: data}
+
+			b.ReportAllocs()
+
+			for range b.N {
+				th.PC = 0
+				if _, err := th.FetchUnsigned(size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFetchSigned(b *testing.B) {
+	data := []uint8{0xFF, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	for size := 1; size <= 8; size++ {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			th := &vm.Thread{Data: data}
+
+			b.ReportAllocs()
+
+			for range b.N {
+				th.PC = 0
+				if _, err := th.FetchSigned(size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	castModeMask       = 0xC0
+	castModeWrap       = 0x00
+	castModeChecked    = 0x40
+	castModeSaturating = 0x80
+	castTypeMask       = 0x1F
+)
+
+// decodeCast reads the control byte of a Cast opcode.
+func (t *Thread) decodeCast(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	mode := control & castModeMask
+	if mode != castModeWrap && mode != castModeChecked && mode != castModeSaturating {
+		return InvalidControlError{Op: opcode.Cast, Control: control}
+	}
+
+	return nil
+}
+
+func (t *Thread) opCast(ins *Instruction) error {
+	control := ins.Control
+	mode := control & castModeMask
+
+	s, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	sv := s.Value()
+	if sv == nil {
+		return UnexpectedTypeError{ID: s.Type}
+	}
+
+	var r types.Value
+	to := typeid.ID(control & castTypeMask)
+	switch mode {
+	case castModeChecked:
+		r, err = sv.DowncastChecked(to)
+		if err == nil && s.Type == typeid.Float64 && stackType(to) != typeid.Float64 {
+			// A float cast to an integer must not have a fractional part.
+			if f := s.Float(); math.Trunc(f) != f {
+				return InexactCastError{Value: sv, To: to}
+			}
+		}
+	case castModeSaturating:
+		r, err = sv.DowncastSaturating(to)
+	default:
+		r, err = sv.Downcast(to)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.push(SlotOf(r))
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const opCast = uint8(opcode.Cast)
+
+func TestThreadCast(t *testing.T) {
+	t.Parallel()
+
+	const (
+		wrap       = 0x00
+		checked    = 0x40
+		saturating = 0x80
+	)
+
+	overflow := func(from, to typeid.ID) testerr.ExpectedError {
+		return testerr.Is(types.OverflowError{From: from, To: to})
+	}
+
+	for _, test := range []struct {
+		name     string
+		value    types.Value
+		control  uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Wrap/Uint64/Uint8", u64(257), wrap | uint8(typeid.Uint8), vals(u64(1)), errOverflow},
+		{"Wrap/Uint64/Int8", u64(255), wrap | uint8(typeid.Int8), vals(i64(-1)), errOverflow},
+		{"Wrap/Int64/Uint64", i64(-1), wrap | uint8(typeid.Uint64), vals(u64(math.MaxUint64)), errOverflow},
+		{"Wrap/Int64/Float32", i64(3), wrap | uint8(typeid.Float32), vals(f64(3)), errOverflow},
+		{"Wrap/Float64/Int16", f64(-2.5), wrap | uint8(typeid.Int16), vals(i64(-2)), errOverflow},
+		{"Wrap/Float64/Float32", f64(0.1), wrap | uint8(typeid.Float32), vals(f64(float64(float32(0.1)))), errOverflow},
+		{"Checked/Uint64/Uint8", u64(255), checked | uint8(typeid.Uint8), vals(u64(255)), errOverflow},
+		{"Checked/Uint64/Uint8/Overflow", u64(256), checked | uint8(typeid.Uint8), vals(), overflow(typeid.Uint64, typeid.Uint8)},
+		{"Checked/Int64/Int8", i64(-128), checked | uint8(typeid.Int8), vals(i64(-128)), errOverflow},
+		{"Checked/Int64/Uint32/Overflow", i64(-1), checked | uint8(typeid.Uint32), vals(), overflow(typeid.Int64, typeid.Uint32)},
+		{"Checked/Float64/Int64", f64(-4), checked | uint8(typeid.Int64), vals(i64(-4)), errOverflow},
+		{"Checked/Float64/Int64/Inexact", f64(1.5), checked | uint8(typeid.Int64), vals(),
+			testerr.Is(vm.InexactCastError{Value: f64(1.5), To: typeid.Int64})},
+		{"Checked/Float64/Float32", f64(0.1), checked | uint8(typeid.Float32), vals(f64(float64(float32(0.1)))),
+			errOverflow},
+		{"Checked/Float64/Uint64/NaN", f64(math.NaN()), checked | uint8(typeid.Uint64), vals(), overflow(typeid.Float64, typeid.Uint64)},
+		{"Saturating/Uint64/Int8", u64(1000), saturating | uint8(typeid.Int8), vals(i64(127)), errOverflow},
+		{"Saturating/Int64/Uint16", i64(-1000), saturating | uint8(typeid.Uint16), vals(u64(0)), errOverflow},
+		{"Saturating/Float64/Int32", f64(-1e12), saturating | uint8(typeid.Int32), vals(i64(math.MinInt32)), errOverflow},
+		{"Saturating/Float64/Uint8/NaN", f64(math.NaN()), saturating | uint8(typeid.Uint8), vals(u64(0)), errOverflow},
+		{"Cast/String", u64(0), wrap | uint8(typeid.String), vals(), testerr.Is(types.CastError{From: typeid.Uint64, To: typeid.String})},
+		{"BadMode", u64(0), 0xC0 | uint8(typeid.Int64), vals(u64(0)), testerr.Is(vm.ErrInvalidControl)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{
+				Stack: vm.Slots(test.value),
+				Data:  []uint8{opCast, test.control},
+			}
+
+			err := th.Run()
+			test.errval.Require(t, err)
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+
+	t.Run("Caught", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{
+			Stack:    vm.Slots(u64(256)),
+			Data:     []uint8{opCast, checked | uint8(typeid.Uint8), opPush, 0x81},
+			Handlers: []vm.Handler{{Start: 0, End: 2, Target: 2, Depth: 0}},
+		}
+
+		errOverflow.Require(t, th.Run())
+		requireStack(t, vals(
+			types.Error{Err: types.OverflowError{From: typeid.Uint64, To: typeid.Uint8}},
+			u64(1),
+		), th.Stack)
+	})
+}
@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"cmp"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	compareEqual        = 0x00
+	compareNotEqual     = 0x01
+	compareLess         = 0x02
+	compareLessEqual    = 0x03
+	compareGreater      = 0x04
+	compareGreaterEqual = 0x05
+)
+
+// decodeCompare reads the control byte of a Compare opcode.
+func (t *Thread) decodeCompare(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control > compareGreaterEqual {
+		return InvalidControlError{Op: opcode.Compare, Control: control}
+	}
+
+	return nil
+}
+
+// opCompare runs the Compare opcode.
+//
+// The top of the stack is compared to the value below it by the relation in
+// the control byte, and 1 is pushed if the relation holds or 0 otherwise.
+// Integers are compared exactly, even when one is signed and the other is not.
+func (t *Thread) opCompare(ins *Instruction) error {
+	result, err := t.compareTop(ins.Control, ins.Operands)
+	if err != nil {
+		return err
+	}
+
+	if result {
+		t.push(UnsignedSlot(1))
+	} else {
+		t.push(UnsignedSlot(0))
+	}
+
+	return nil
+}
+
+// opCompareJumpIf runs a Compare fused with the JumpIf which follows it.
+func (t *Thread) opCompareJumpIf(ins *Instruction) error {
+	result, err := t.compareTop(ins.Control, ins.Operands)
+	if err != nil {
+		t.PC = ins.FusedOffset
+		return err
+	}
+
+	if result != (ins.FusedControl&jumpIfZeroBit != 0) {
+		t.PC = ins.Target
+	}
+
+	return nil
+}
+
+// compareTop pops the top two values of the stack and returns if the given
+// relation holds between them, using a fast path if both are proven to have
+// the given type.
+func (t *Thread) compareTop(relation uint8, operands typeid.ID) (bool, error) {
+	left, err := t.pop()
+	if err != nil {
+		return false, err
+	}
+
+	right, err := t.pop()
+	if err != nil {
+		return false, err
+	}
+
+	order, ordered, err := typedCompare(operands, left, right)
+	if err != nil {
+		return false, err
+	}
+
+	var result bool
+	switch relation {
+	case compareEqual:
+		result = ordered && order == 0
+	case compareNotEqual:
+		result = !ordered || order != 0
+	case compareLess:
+		result = ordered && order < 0
+	case compareLessEqual:
+		result = ordered && order <= 0
+	case compareGreater:
+		result = ordered && order > 0
+	default:
+		result = ordered && order >= 0
+	}
+
+	return result, nil
+}
+
+// compare returns -1, 0 or 1 if left is less than, equal to, or greater than
+// right.
+//
+// If either operand is a NaN the operands are unordered and false is
+// returned.
+func compare(left, right Slot) (int, bool, error) {
+	kind, err := commonKind(left, right)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case kind == typeid.Float64:
+		order, ordered := compareFloats(toFloat(left), toFloat(right))
+		return order, ordered, nil
+	case left.Type == typeid.Int64 && right.Type == typeid.Int64:
+		return cmp.Compare(left.Signed(), right.Signed()), true, nil
+	case left.Type == typeid.Int64 && left.Signed() < 0:
+		return -1, true, nil
+	case right.Type == typeid.Int64 && right.Signed() < 0:
+		return 1, true, nil
+	default:
+		return cmp.Compare(left.Unsigned(), right.Unsigned()), true, nil
+	}
+}
+
+// compareFloats returns -1, 0 or 1 if a is less than, equal to, or greater
+// than b, or false if they are unordered.
+func compareFloats(a, b float64) (int, bool) {
+	switch {
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	case a == b:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,73 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opCompare = uint8(opcode.Compare)
+
+	cmpEq = 0x00
+	cmpNe = 0x01
+	cmpLt = 0x02
+	cmpLe = 0x03
+	cmpGt = 0x04
+	cmpGe = 0x05
+)
+
+func TestThreadCompare(t *testing.T) {
+	t.Parallel()
+
+	nan := types.Float64(math.NaN())
+
+	for _, test := range []struct {
+		name     string
+		control  uint8
+		left     types.Value
+		right    types.Value
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Eq/True", cmpEq, u64(3), u64(3), vals(u64(1)), errOverflow},
+		{"Eq/False", cmpEq, u64(3), u64(4), vals(u64(0)), errOverflow},
+		{"Ne", cmpNe, i64(-1), i64(1), vals(u64(1)), errOverflow},
+		{"Lt/True", cmpLt, u64(1), u64(2), vals(u64(1)), errOverflow},
+		{"Lt/False", cmpLt, u64(2), u64(2), vals(u64(0)), errOverflow},
+		{"Le", cmpLe, u64(2), u64(2), vals(u64(1)), errOverflow},
+		{"Gt", cmpGt, i64(-1), i64(-2), vals(u64(1)), errOverflow},
+		{"Ge", cmpGe, i64(-3), i64(-2), vals(u64(0)), errOverflow},
+		{"Mixed/Negative", cmpLt, i64(-1), u64(math.MaxUint64), vals(u64(1)), errOverflow},
+		{"Mixed/Large", cmpGt, u64(math.MaxUint64), i64(math.MaxInt64), vals(u64(1)), errOverflow},
+		{"Mixed/Equal", cmpEq, u64(5), i64(5), vals(u64(1)), errOverflow},
+		{"Float", cmpLt, f64(1.5), u64(2), vals(u64(1)), errOverflow},
+		{"NaN/Eq", cmpEq, nan, nan, vals(u64(0)), errOverflow},
+		{"NaN/Ne", cmpNe, nan, f64(1), vals(u64(1)), errOverflow},
+		{"NaN/Ge", cmpGe, f64(1), nan, vals(u64(0)), errOverflow},
+		{
+			"BadControl", 0x06, u64(1), u64(1), vals(u64(1), u64(1)),
+			testerr.Is(vm.InvalidControlError{Op: opcode.Compare, Control: 0x06}),
+		},
+		{
+			"BadType", cmpEq, types.Error{}, u64(1), vals(),
+			testerr.Is(vm.UnexpectedTypeError{ID: typeid.Error}),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{
+				Stack: vm.Slots(test.right, test.left),
+				Data:  []uint8{opCompare, test.control},
+			}
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+}
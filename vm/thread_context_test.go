@@ -0,0 +1,82 @@
+package vm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestThreadRunContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Background", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: countdown(3000)}
+		errOverflow.Require(t, th.RunContext(context.Background()))
+		requireStack(t, vals(i64(0)), th.Stack)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		th := &vm.Thread{Data: countdown(3000)}
+		err := th.RunContext(ctx)
+		require.Equal(t, vm.InterruptedError{Offset: 0, Err: context.Canceled}, err)
+		require.ErrorIs(t, err, vm.ErrInterrupted)
+		require.ErrorIs(t, err, context.Canceled)
+		require.EqualError(t, err, "interrupted at 0x0: context canceled")
+		require.Empty(t, th.Stack)
+
+		errOverflow.Require(t, th.RunContext(context.Background()))
+		requireStack(t, vals(i64(0)), th.Stack)
+	})
+
+	t.Run("Compiled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		th := &vm.Thread{Data: countdown(3000)}
+		th.Predecode()
+		require.NoError(t, th.Program.Compile(0))
+
+		interpreted := &vm.Thread{Data: countdown(3000)}
+		interpreted.Predecode()
+		require.NoError(t, th.RunFor(7))
+		require.NoError(t, interpreted.RunFor(7))
+		require.Equal(t, interpreted.PC, th.PC)
+		require.Equal(t, interpreted.Stack, th.Stack)
+
+		errOverflow.Require(t, th.RunContext(ctx))
+		requireStack(t, vals(i64(0)), th.Stack)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		// 0x00: Push 1, Pop 0, Jump 0x00
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opPop, 0x80, opJump, 0x01, 0x00}}
+		th.Predecode()
+		require.NoError(t, th.Program.Compile(0))
+
+		err := th.RunContext(ctx)
+		require.ErrorIs(t, err, vm.ErrInterrupted)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		var interrupted vm.InterruptedError
+		require.ErrorAs(t, err, &interrupted)
+		require.Equal(t, th.PC, interrupted.Offset)
+		require.LessOrEqual(t, len(th.Stack), 1)
+	})
+}
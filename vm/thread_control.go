@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	jumpIfZeroBit   = 0x10
+	jumpIfModeMask  = 0xE0
+	callArgsShift   = 4
+	returnCountMask = 0x0F
+)
+
+// Frame is an active function call on a thread.
+type Frame struct {
+	// Base is the index in the stack of the first value of the frame.
+	Base int
+
+	// Caller is the offset of the Call opcode which created the frame.
+	Caller int
+
+	// Return is the offset execution continues from when the frame returns.
+	Return int
+}
+
+// fetchTarget reads a uN immediate holding the offset of a jump target, where
+// N is the low nibble of the control byte.
+func (t *Thread) fetchTarget(op opcode.ID, control uint8) (int, error) {
+	size := int(control & controlSizeMask)
+	if size < 1 || size > 8 {
+		return 0, InvalidControlError{Op: op, Control: control}
+	}
+
+	v, err := t.FetchUnsigned(size)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(v), nil
+}
+
+// decodeJump reads the control byte and target of a Jump, JumpIf, Call or
+// CoCreate opcode.
+func (t *Thread) decodeJump(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	switch {
+	case (ins.Op == opcode.Jump || ins.Op == opcode.CoCreate) && control&controlTypeMask != 0,
+		ins.Op == opcode.JumpIf && control&jumpIfModeMask != 0:
+		return InvalidControlError{Op: ins.Op, Control: control}
+	}
+
+	ins.Target, err = t.fetchTarget(ins.Op, control)
+
+	return err
+}
+
+func (t *Thread) opJumpIf(ins *Instruction) error {
+	s, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	truth, err := typedTruth(ins.Operands, s)
+	if err != nil {
+		return err
+	}
+
+	if truth != (ins.Control&jumpIfZeroBit != 0) {
+		t.PC = ins.Target
+	}
+
+	return nil
+}
+
+func (t *Thread) opCall(ins *Instruction) error {
+	base := len(t.Stack) - int(ins.Control>>callArgsShift)
+	if base < t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	if t.Limits.Frames > 0 && len(t.Frames) >= t.Limits.Frames {
+		return LimitError{Err: ErrFrameOverflow, Limit: t.Limits.Frames}
+	}
+
+	t.Frames = append(t.Frames, Frame{Base: base, Caller: ins.Offset, Return: t.PC})
+	t.PC = ins.Target
+	t.Program.called(ins.Target)
+
+	return nil
+}
+
+func (t *Thread) opReturn(ins *Instruction) error {
+	count := int(ins.Control & returnCountMask)
+	if len(t.Frames) == 0 {
+		if t.coro != nil {
+			return t.returnCoroutine(count)
+		}
+
+		return ErrFrameUnderflow
+	}
+
+	frame := t.Frames[len(t.Frames)-1]
+	if len(t.Stack)-count < frame.Base {
+		return ErrStackUnderflow
+	}
+
+	copy(t.Stack[frame.Base:], t.Stack[len(t.Stack)-count:])
+	t.Stack = t.Stack[:frame.Base+count]
+	t.Frames = t.Frames[:len(t.Frames)-1]
+	t.PC = frame.Return
+
+	return nil
+}
+
+// isTruthy returns if the given numeric slot is non-zero.
+func isTruthy(s Slot) (bool, error) {
+	switch s.Type {
+	case typeid.Uint64, typeid.Int64:
+		return s.Bits != 0, nil
+	case typeid.Float64:
+		return s.Float() != 0, nil
+	default:
+		return false, UnexpectedTypeError{ID: s.Type}
+	}
+}
@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"github.com/tvarney/illvm/types"
+)
+
+// Handler is an entry in the exception handler table of a thread.
+//
+// A handler covers every opcode which starts in the range [Start, End). When a
+// value is raised from within that range the stack of the frame is truncated
+// to Depth values, the raised value is pushed, and execution continues at
+// Target.
+//
+// Handlers are searched in table order and the first which covers the raising
+// opcode is used, so nested regions must be listed innermost first. If no
+// handler in the current frame covers the opcode, the frame is unwound and the
+// search continues from the Call opcode of that frame.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// Covers returns if the opcode at the given offset is inside the region of
+// the handler.
+func (h Handler) Covers(offset int) bool {
+	return offset >= h.Start && offset < h.End
+}
+
+func (t *Thread) opThrow(ins *Instruction) error {
+	s, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	return t.raise(ins.Offset, s.Value())
+}
+
+// raise throws the given value from the opcode at the given offset.
+//
+// If a handler is found the frames above it are discarded and execution is
+// moved to the handler. A value which is not caught within a coroutine ends
+// the coroutine and is raised again from where it was resumed. Otherwise, the
+// thread is left untouched and an UncaughtExceptionError is returned.
+func (t *Thread) raise(offset int, v types.Value) error {
+	trace := make([]int, 0, len(t.Frames)+1)
+	trace = append(trace, offset)
+
+	site := offset
+	for depth := len(t.Frames); depth >= 0; depth-- {
+		if h, ok := t.findHandler(site); ok {
+			return t.enterHandler(depth, h, v)
+		}
+
+		if depth == 0 {
+			break
+		}
+
+		site = t.Frames[depth-1].Caller
+		trace = append(trace, site)
+	}
+
+	if t.coro != nil {
+		return t.raiseFromCoroutine(v, trace)
+	}
+
+	return UncaughtExceptionError{Value: v, Trace: trace}
+}
+
+// findHandler returns the first handler which covers the given offset.
+func (t *Thread) findHandler(offset int) (Handler, bool) {
+	for _, h := range t.Handlers {
+		if h.Covers(offset) {
+			return h, true
+		}
+	}
+
+	return Handler{}, false
+}
+
+// enterHandler unwinds the thread to the given frame depth and transfers
+// control to the handler.
+//
+// If the frame holds fewer than Depth values the thread is left untouched and
+// ErrStackUnderflow is returned.
+func (t *Thread) enterHandler(depth int, h Handler, v types.Value) error {
+	base, height := 0, len(t.Stack)
+	if depth > 0 {
+		base = t.Frames[depth-1].Base
+	}
+
+	if depth < len(t.Frames) {
+		height = t.Frames[depth].Base
+	}
+
+	if h.Depth < 0 || base+h.Depth > height {
+		return ErrStackUnderflow
+	}
+
+	t.Frames = t.Frames[:depth]
+	t.Stack = append(t.Stack[:base+h.Depth], SlotOf(v))
+	t.PC = h.Target
+
+	return nil
+}
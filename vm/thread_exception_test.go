@@ -0,0 +1,154 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestHandlerCovers(t *testing.T) {
+	t.Parallel()
+
+	h := vm.Handler{Start: 4, End: 8, Target: 10}
+	require.False(t, h.Covers(3))
+	require.True(t, h.Covers(4))
+	require.True(t, h.Covers(7))
+	require.False(t, h.Covers(8))
+}
+
+func TestThreadThrow(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Caught", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 2, Push 3, Throw, Push 9
+		// 0x09: Push 4
+		th := &vm.Thread{
+			Data: []uint8{
+				opPush, 0x81, opPush, 0x82, opPush, 0x83, opThrow, opPush, 0x89,
+				opPush, 0x84,
+			},
+			Handlers: []vm.Handler{{Start: 2, End: 9, Target: 9, Depth: 1}},
+		}
+
+		testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+		requireStack(t, vals(types.Uint64(1), types.Uint64(3), types.Uint64(4)), th.Stack)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{
+			Data: []uint8{opPush, 0x81, opThrow, opPush, 0x82, opPush, 0x83},
+			Handlers: []vm.Handler{
+				{Start: 2, End: 3, Target: 5, Depth: 0},
+				{Start: 0, End: 5, Target: 3, Depth: 0},
+			},
+		}
+
+		testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+		requireStack(t, vals(types.Uint64(1), types.Uint64(3)), th.Stack)
+	})
+
+	t.Run("Unwind", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Push 2, Call 0x0A with 1 arg, Jump 0x0D
+		// 0x0A: Push 5, Throw
+		// 0x0D: Push 6
+		th := &vm.Thread{
+			Data: []uint8{
+				opPush, 0x81, opPush, 0x82, opCall, 0x11, 0x0A, opJump, 0x01, 0x0D,
+				opPush, 0x85, opThrow, opPush, 0x86,
+			},
+			Handlers: []vm.Handler{{Start: 4, End: 7, Target: 13, Depth: 1}},
+		}
+
+		testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+		require.Empty(t, th.Frames)
+		requireStack(t, vals(types.Uint64(1), types.Uint64(5), types.Uint64(6)), th.Stack)
+	})
+
+	t.Run("Uncaught", func(t *testing.T) {
+		t.Parallel()
+
+		// 0x00: Push 1, Call 0x06 with 1 arg
+		// 0x05: NoOp
+		// 0x06: Push 7, Throw
+		th := &vm.Thread{
+			Data:     []uint8{opPush, 0x81, opCall, 0x11, 0x06, 0x00, opPush, 0x87, opThrow},
+			Handlers: []vm.Handler{{Start: 5, End: 6, Target: 0, Depth: 0}},
+		}
+
+		err := th.Run()
+		testerr.Is(vm.ErrUncaughtException).Require(t, err)
+
+		var uncaught vm.UncaughtExceptionError
+		require.ErrorAs(t, err, &uncaught)
+		require.Equal(t, types.Uint64(7), uncaught.Value)
+		require.Equal(t, []int{8, 2}, uncaught.Trace)
+		require.Equal(t, "uncaught exception at 0x8: uint64 7", err.Error())
+
+		// The thread state is preserved for inspection
+		require.Len(t, th.Frames, 1)
+		requireStack(t, vals(types.Uint64(1)), th.Stack)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opThrow}}
+		err := th.Run()
+		testerr.Is(vm.ErrUncaughtException).Require(t, err)
+		testerr.Is(vm.ErrStackUnderflow).Require(t, err)
+	})
+
+	t.Run("HandlerDepth", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{
+			Data:     []uint8{opPush, 0x81, opThrow},
+			Handlers: []vm.Handler{{Start: 0, End: 3, Target: 0, Depth: 2}},
+		}
+
+		testerr.Is(vm.ErrStackUnderflow).Require(t, th.Run())
+		require.Equal(t, 3, th.PC)
+	})
+}
+
+func TestThreadFaultCaught(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: Pop 1 (underflow), Push 9
+	// 0x04: Push 1
+	th := &vm.Thread{
+		Data:     []uint8{opPop, 0x80, opPush, 0x89, opPush, 0x81},
+		Handlers: []vm.Handler{{Start: 0, End: 2, Target: 4, Depth: 0}},
+	}
+
+	testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+	require.Len(t, th.Stack, 2)
+
+	fault, ok := th.Stack[0].Value().(types.Error)
+	require.True(t, ok, "caught fault must be a types.Error")
+	require.ErrorIs(t, fault, vm.ErrStackUnderflow)
+	require.Equal(t, types.Uint64(1), th.Stack[1].Value())
+
+	t.Run("Malformed", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{
+			Data:     []uint8{opPush, 0x30},
+			Handlers: []vm.Handler{{Start: 0, End: 2, Target: 0, Depth: 0}},
+		}
+
+		err := th.Run()
+		testerr.Is(vm.ErrInvalidControl).Require(t, err)
+		require.False(t, errors.Is(err, vm.ErrUncaughtException))
+	})
+}
@@ -1,15 +1,6 @@
 package vm
 
-const (
-	Int24NegativeBit = 0x00800000
-	Int24SignExtend  = 0xFF000000
-	Int40NegativeBit = 0x0000008000000000
-	Int40SignExtend  = 0xFFFFFF0000000000
-	Int48NegativeBit = 0x0000800000000000
-	Int48SignExtend  = 0xFFFF000000000000
-	Int56NegativeBit = 0x0080000000000000
-	Int56SignExtend  = 0xFF00000000000000
-)
+import "github.com/tvarney/illvm/vm/vmath"
 
 // FetchSigned reads count bytes from the bytecode and returns it as an int64.
 //
@@ -70,10 +61,10 @@ func (t *Thread) FetchI16() (int16, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 2}
 	}
 
-	val := ((uint16(t.Data[t.PC])) << 8) + (uint16(t.Data[t.PC+1]))
+	val := vmath.I16FromBytes(t.Data[t.PC], t.Data[t.PC+1])
 	t.PC += 2
 
-	return int16(val), nil
+	return val, nil
 }
 
 // FetchI24 reads 3 bytes from the bytecode and returns it as an int32.
@@ -85,16 +76,10 @@ func (t *Thread) FetchI24() (int32, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 3}
 	}
 
-	val := ((uint32(t.Data[t.PC])) << 16) + ((uint32(t.Data[t.PC+1])) << 8) +
-		(uint32(t.Data[t.PC+2]))
+	val := vmath.I24FromBytes(t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2])
 	t.PC += 3
 
-	// Sign extend
-	if val&Int24NegativeBit != 0 {
-		val |= Int24SignExtend
-	}
-
-	return int32(val), nil
+	return val, nil
 }
 
 // FetchI32 reads 4 bytes from the bytecode and returns it as an int32.
@@ -106,11 +91,12 @@ func (t *Thread) FetchI32() (int32, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 4}
 	}
 
-	val := ((uint32(t.Data[t.PC])) << 24) + ((uint32(t.Data[t.PC+1])) << 16) +
-		((uint32(t.Data[t.PC+2])) << 8) + uint32(t.Data[t.PC+3])
+	val := vmath.I32FromBytes(
+		t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2], t.Data[t.PC+3],
+	)
 	t.PC += 4
 
-	return int32(val), nil
+	return val, nil
 }
 
 // FetchI40 reads 5 bytes from the bytecode and returns it as an int64.
@@ -122,17 +108,13 @@ func (t *Thread) FetchI40() (int64, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 5}
 	}
 
-	val := ((uint64(t.Data[t.PC])) << 32) + ((uint64(t.Data[t.PC+1])) << 24) +
-		((uint64(t.Data[t.PC+2])) << 16) + ((uint64(t.Data[t.PC+3])) << 8) +
-		uint64(t.Data[t.PC+4])
+	val := vmath.I40FromBytes(
+		t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2], t.Data[t.PC+3],
+		t.Data[t.PC+4],
+	)
 	t.PC += 5
 
-	// Sign extend
-	if val&Int40NegativeBit != 0 {
-		val |= Int40SignExtend
-	}
-
-	return int64(val), nil
+	return val, nil
 }
 
 // FetchI48 reads 6 bytes from the bytecode and returns it as an int64.
@@ -144,17 +126,13 @@ func (t *Thread) FetchI48() (int64, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 6}
 	}
 
-	val := (uint64(t.Data[t.PC]) << 40) + (uint64(t.Data[t.PC+1]) << 32) +
-		(uint64(t.Data[t.PC+2]) << 24) + (uint64(t.Data[t.PC+3]) << 16) +
-		(uint64(t.Data[t.PC+4]) << 8) + uint64(t.Data[t.PC+5])
+	val := vmath.I48FromBytes(
+		t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2], t.Data[t.PC+3],
+		t.Data[t.PC+4], t.Data[t.PC+5],
+	)
 	t.PC += 6
 
-	// Sign extend
-	if val&Int48NegativeBit != 0 {
-		val |= Int48SignExtend
-	}
-
-	return int64(val), nil
+	return val, nil
 }
 
 // FetchI56 reads 7 bytes from the bytecode and returns it as an int64.
@@ -166,18 +144,13 @@ func (t *Thread) FetchI56() (int64, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 7}
 	}
 
-	val := (uint64(t.Data[t.PC]) << 48) + (uint64(t.Data[t.PC+1]) << 40) +
-		(uint64(t.Data[t.PC+2]) << 32) + (uint64(t.Data[t.PC+3]) << 24) +
-		(uint64(t.Data[t.PC+4]) << 16) + (uint64(t.Data[t.PC+5]) << 8) +
-		uint64(t.Data[t.PC+6])
+	val := vmath.I56FromBytes(
+		t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2], t.Data[t.PC+3],
+		t.Data[t.PC+4], t.Data[t.PC+5], t.Data[t.PC+6],
+	)
 	t.PC += 7
 
-	// Sign extend
-	if val&Int56NegativeBit != 0 {
-		val |= Int56SignExtend
-	}
-
-	return int64(val), nil
+	return val, nil
 }
 
 // FetchI64 reads 8 bytes from the bytecode and returns it as an int64.
@@ -189,11 +162,11 @@ func (t *Thread) FetchI64() (int64, error) {
 		return 0, FetchNotEnoughBytesError{Bytes: 8}
 	}
 
-	val := (uint64(t.Data[t.PC]) << 56) + (uint64(t.Data[t.PC+1]) << 48) +
-		(uint64(t.Data[t.PC+2]) << 40) + (uint64(t.Data[t.PC+3]) << 32) +
-		(uint64(t.Data[t.PC+4]) << 24) + (uint64(t.Data[t.PC+5]) << 16) +
-		(uint64(t.Data[t.PC+6]) << 8) + uint64(t.Data[t.PC+7])
+	val := vmath.I64FromBytes(
+		t.Data[t.PC], t.Data[t.PC+1], t.Data[t.PC+2], t.Data[t.PC+3],
+		t.Data[t.PC+4], t.Data[t.PC+5], t.Data[t.PC+6], t.Data[t.PC+7],
+	)
 	t.PC += 8
 
-	return int64(val), nil
+	return val, nil
 }
@@ -0,0 +1,29 @@
+package vm
+
+import "github.com/tvarney/illvm/opcode"
+
+// cost returns the fuel cost of the given instruction.
+//
+// A superinstruction costs as much as the opcodes fused into it.
+func (t *Thread) cost(ins *Instruction) uint64 {
+	switch ins.Op {
+	case opcode.PushArith:
+		return t.Costs[opcode.Push] + t.Costs[ins.Fused]
+	case opcode.CompareJumpIf:
+		return t.Costs[opcode.Compare] + t.Costs[ins.Fused]
+	default:
+		return t.Costs[ins.Op]
+	}
+}
+
+// spend removes the given amount of fuel from the thread, or returns an
+// OutOfFuelError without changing the thread if it has too little left.
+func (t *Thread) spend(cost uint64) error {
+	if t.Fuel < cost {
+		return OutOfFuelError{Offset: t.PC, Fuel: t.Fuel, Cost: cost}
+	}
+
+	t.Fuel -= cost
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/bench"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+func TestThreadFuel(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		fuel     uint64
+		errval   testerr.ExpectedError
+		pc       int
+		left     uint64
+		expected int
+	}{
+		{"Enough", []uint8{opPush, 0x81, opPush, 0x82, opAdd, modeWrap}, 3, errOverflow, 6, 0, 1},
+		{"Empty", []uint8{opPush, 0x81}, 0, testerr.Is(vm.ErrOutOfFuel), 0, 0, 0},
+		{"Weighted", []uint8{opPush, 0x81, opPush, 0x82, opDiv, modeWrap}, 5, testerr.Is(vm.ErrOutOfFuel), 4, 3, 2},
+		{"Undefined", []uint8{opPush, 0x81, 0xFF}, 1, testerr.Is(vm.ErrOutOfFuel), 2, 0, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: test.data, Costs: opcode.DefaultCosts(), Fuel: test.fuel}
+			test.errval.Require(t, th.Run())
+			require.Equal(t, test.pc, th.PC)
+			require.Equal(t, test.left, th.Fuel)
+			require.Len(t, th.Stack, test.expected)
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opThrow}, Costs: opcode.DefaultCosts(), Fuel: 4}
+		err := th.Run()
+		require.Equal(t, vm.OutOfFuelError{Offset: 2, Fuel: 3, Cost: 10}, err)
+		require.EqualError(t, err, "out of fuel at 0x2: cost 10, 3 left")
+	})
+
+	t.Run("Costs", func(t *testing.T) {
+		t.Parallel()
+
+		costs := opcode.DefaultCosts()
+		costs[opcode.Push] = 0
+
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opPush, 0x82}, Costs: costs, Fuel: 0}
+		errOverflow.Require(t, th.Run())
+		require.Len(t, th.Stack, 2)
+	})
+}
+
+func TestThreadFuelResume(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name string
+		data []uint8
+	}{
+		{"Countdown", countdown(20)},
+		{"Fib", bench.Fib(8)},
+		{"Fused", []uint8{
+			opPush, 0x85, opPush, 0x11, 0xFF, opAdd, modeWrap, opDupe, 0x00, opPush, 0x80,
+			opCompare, cmpNe, opJumpIf, 0x01, 0x02, opPush, 0x81, opDiv, modeWrap,
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			unmetered := &vm.Thread{Data: test.data}
+			expected := unmetered.Run()
+
+			// Every refill is too small for some opcodes and superinstructions,
+			// so the thread runs out of fuel part way through them.
+			for _, predecode := range []bool{false, true} {
+				th := &vm.Thread{Data: test.data, Costs: opcode.DefaultCosts()}
+				if predecode {
+					th.Predecode()
+					require.NoError(t, th.Program.Compile(0))
+				}
+
+				err := th.Run()
+				for refills := 0; errors.Is(err, vm.ErrOutOfFuel); refills++ {
+					require.Less(t, refills, 10000)
+
+					th.Fuel += 3
+					err = th.Run()
+				}
+
+				require.Equal(t, expected, err)
+				require.Equal(t, unmetered.PC, th.PC)
+				require.Equal(t, vm.Values(unmetered.Stack), vm.Values(th.Stack))
+			}
+		})
+	}
+
+	t.Run("SameOffset", func(t *testing.T) {
+		t.Parallel()
+
+		data := bench.Fib(5)
+		for fuel := range uint64(200) {
+			raw := &vm.Thread{Data: data, Costs: opcode.DefaultCosts(), Fuel: fuel}
+			rawErr := raw.Run()
+
+			th := &vm.Thread{Data: data, Costs: opcode.DefaultCosts(), Fuel: fuel}
+			th.Predecode()
+			require.Equal(t, rawErr, th.Run(), "fuel %d", fuel)
+			require.Equal(t, raw.PC, th.PC, "fuel %d", fuel)
+			require.Equal(t, raw.Fuel, th.Fuel, "fuel %d", fuel)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+func (t *Thread) opYield() error {
+	t.yielded = true
+	return nil
+}
+
+// opJoin pops the ID of a thread once that thread is done. Until then the
+// thread is blocked on it and the Join is left to be run again.
+func (t *Thread) opJoin(ins *Instruction) error {
+	if len(t.Stack) <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	if t.Machine == nil {
+		return ErrNoMachine
+	}
+
+	t.Machine.mu.Lock()
+	defer t.Machine.mu.Unlock()
+
+	s := t.Stack[len(t.Stack)-1]
+
+	id := -1
+	switch s.Type {
+	case typeid.Uint64:
+		if s.Unsigned() <= math.MaxInt32 {
+			id = int(s.Unsigned())
+		}
+	case typeid.Int64:
+		if s.Signed() <= math.MaxInt32 {
+			id = int(s.Signed())
+		}
+	default:
+		return UnexpectedTypeError{ID: s.Type}
+	}
+
+	target, ok := t.Machine.thread(id)
+	switch {
+	case !ok:
+		return ErrNoThread
+	case target == t:
+		return ErrDeadlock
+	case target.State != ThreadDone:
+		return t.block(ins, &wait{op: ins.Op, offset: ins.Offset, join: target, channels: nil}, false)
+	}
+
+	t.Stack = t.Stack[:len(t.Stack)-1]
+
+	return nil
+}
+
+// block stops the thread at the given instruction, which is run again once the
+// thread is woken, returning ErrBlocked. The machine must be locked.
+func (t *Thread) block(ins *Instruction, w *wait, recv bool) error {
+	t.PC = ins.Offset
+	t.Machine.block(t, w, recv)
+
+	return ErrBlocked
+}
@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+const (
+	pushImmediateBit  = 0x80
+	pushImmediateMask = 0x7F
+	pushTypeUnsigned  = 0x00
+	pushTypeSigned    = 0x10
+	pushTypeFloat     = 0x20
+
+	popImmediateBit  = 0x80
+	popImmediateMask = 0x7F
+	popModeMask      = 0xC0
+	popModeClear     = 0x40
+
+	dupeReservedBit = 0x80
+
+	controlTypeMask = 0xF0
+	controlSizeMask = 0x0F
+)
+
+// FrameBase returns the index in the stack of the first value of the current
+// frame.
+func (t *Thread) FrameBase() int {
+	if len(t.Frames) == 0 {
+		return 0
+	}
+
+	return t.Frames[len(t.Frames)-1].Base
+}
+
+// StackUsed returns the number of slots counted against the stack limit of the
+// thread: those of its stack, and of the stacks of whatever resumed the
+// coroutines it is running.
+func (t *Thread) StackUsed() int {
+	return len(t.Stack) + t.resumed
+}
+
+// push pushes the given slot onto the stack.
+func (t *Thread) push(s Slot) {
+	t.Stack = append(t.Stack, s)
+}
+
+// pop removes the top slot of the current frame and returns it.
+func (t *Thread) pop() (Slot, error) {
+	if len(t.Stack) <= t.FrameBase() {
+		return Slot{}, ErrStackUnderflow
+	}
+
+	s := t.Stack[len(t.Stack)-1]
+	t.Stack = t.Stack[:len(t.Stack)-1]
+
+	return s, nil
+}
+
+// popN removes the top count values of the current frame.
+func (t *Thread) popN(count int) error {
+	if count < 0 || len(t.Stack)-count < t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	t.Stack = t.Stack[:len(t.Stack)-count]
+
+	return nil
+}
+
+// fetchControl reads the control byte of the current opcode.
+func (t *Thread) fetchControl() (uint8, error) {
+	return t.FetchU8()
+}
+
+// decodePush reads the control byte and immediate of a Push opcode.
+func (t *Thread) decodePush(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control&pushImmediateBit != 0 {
+		ins.Value = UnsignedSlot(uint64(control & pushImmediateMask))
+		return nil
+	}
+
+	size := int(control & controlSizeMask)
+	switch control & controlTypeMask {
+	case pushTypeUnsigned:
+		if size < 1 || size > 8 {
+			return InvalidControlError{Op: opcode.Push, Control: control}
+		}
+
+		v, err := t.FetchUnsigned(size)
+		if err != nil {
+			return err
+		}
+
+		ins.Value = UnsignedSlot(v)
+	case pushTypeSigned:
+		if size < 1 || size > 8 {
+			return InvalidControlError{Op: opcode.Push, Control: control}
+		}
+
+		v, err := t.FetchSigned(size)
+		if err != nil {
+			return err
+		}
+
+		ins.Value = SignedSlot(v)
+	case pushTypeFloat:
+		switch size {
+		case 4:
+			v, err := t.FetchU32()
+			if err != nil {
+				return err
+			}
+
+			ins.Value = FloatSlot(float64(math.Float32frombits(v)))
+		case 8:
+			v, err := t.FetchU64()
+			if err != nil {
+				return err
+			}
+
+			ins.Value = FloatSlot(math.Float64frombits(v))
+		default:
+			return InvalidControlError{Op: opcode.Push, Control: control}
+		}
+	default:
+		return InvalidControlError{Op: opcode.Push, Control: control}
+	}
+
+	return nil
+}
+
+// decodeDupe reads the control byte of a Dupe opcode.
+func (t *Thread) decodeDupe(ins *Instruction) error {
+	control, err := t.fetchControl()
+	if err != nil {
+		return err
+	}
+
+	ins.Control = control
+	if control&dupeReservedBit != 0 {
+		return InvalidControlError{Op: opcode.Dupe, Control: control}
+	}
+
+	return nil
+}
+
+func (t *Thread) opDupe(ins *Instruction) error {
+	idx := len(t.Stack) - 1 - int(ins.Control)
+	if idx < t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	if err := t.grow(); err != nil {
+		return err
+	}
+
+	t.push(t.Stack[idx])
+
+	return nil
+}
+
+func (t *Thread) opPop(ins *Instruction) error {
+	control := ins.Control
+	if control&popImmediateBit != 0 {
+		return t.popN(int(control&popImmediateMask) + 1)
+	}
+
+	if control&popModeMask == popModeClear {
+		t.Stack = t.Stack[:t.FrameBase()]
+		return nil
+	}
+
+	s, err := t.pop()
+	if err != nil {
+		return err
+	}
+
+	count, err := toCount(s)
+	if err != nil {
+		return err
+	}
+
+	return t.popN(count + 1)
+}
+
+// toCount converts an integer slot to a count of values.
+func toCount(s Slot) (int, error) {
+	switch s.Type {
+	case typeid.Uint64:
+		if s.Unsigned() > math.MaxInt32 {
+			return 0, ErrStackUnderflow
+		}
+
+		return int(s.Unsigned()), nil
+	case typeid.Int64:
+		if s.Signed() < 0 || s.Signed() > math.MaxInt32 {
+			return 0, ErrStackUnderflow
+		}
+
+		return int(s.Signed()), nil
+	default:
+		return 0, UnexpectedTypeError{ID: s.Type}
+	}
+}
@@ -0,0 +1,221 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opPush   = uint8(opcode.Push)
+	opDupe   = uint8(opcode.Dupe)
+	opPop    = uint8(opcode.Pop)
+	opJump   = uint8(opcode.Jump)
+	opJumpIf = uint8(opcode.JumpIf)
+	opCall   = uint8(opcode.Call)
+	opReturn = uint8(opcode.Return)
+	opThrow  = uint8(opcode.Throw)
+)
+
+var errOverflow = testerr.Is(vm.ErrBytecodeOverflow)
+
+func TestThreadPush(t *testing.T) {
+	t.Parallel()
+
+	f32 := math.Float32bits(1.5)
+	f64 := math.Float64bits(-2.25)
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Immediate", []uint8{opPush, 0xFF}, vals(types.Uint64(0x7F)), errOverflow},
+		{"Unsigned1", []uint8{opPush, 0x01, 0xFE}, vals(types.Uint64(0xFE)), errOverflow},
+		{"Unsigned3", []uint8{opPush, 0x03, 0x01, 0x02, 0x03}, vals(types.Uint64(0x010203)), errOverflow},
+		{"Signed1", []uint8{opPush, 0x11, 0xFE}, vals(types.Int64(-2)), errOverflow},
+		{"Signed2", []uint8{opPush, 0x12, 0x01, 0x00}, vals(types.Int64(256)), errOverflow},
+		{
+			"Float4",
+			[]uint8{opPush, 0x24, uint8(f32 >> 24), uint8(f32 >> 16), uint8(f32 >> 8), uint8(f32)},
+			vals(types.Float64(1.5)), errOverflow,
+		},
+		{
+			"Float8",
+			[]uint8{
+				opPush, 0x28, uint8(f64 >> 56), uint8(f64 >> 48), uint8(f64 >> 40), uint8(f64 >> 32),
+				uint8(f64 >> 24), uint8(f64 >> 16), uint8(f64 >> 8), uint8(f64),
+			},
+			vals(types.Float64(-2.25)), errOverflow,
+		},
+		{"NoControl", []uint8{opPush}, vals(), errRead1},
+		{"SizeZero", []uint8{opPush, 0x00}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Push, Control: 0x00})},
+		{"SizeNine", []uint8{opPush, 0x19}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Push, Control: 0x19})},
+		{"FloatSize", []uint8{opPush, 0x22}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Push, Control: 0x22})},
+		{"BadType", []uint8{opPush, 0x31}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Push, Control: 0x31})},
+		{"Truncated", []uint8{opPush, 0x04, 0x01}, vals(), errRead4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: test.data}
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+}
+
+func TestThreadDupe(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Top", []uint8{opPush, 0x81, opDupe, 0x00}, vals(u64(1), u64(1)), errOverflow},
+		{
+			"Index", []uint8{opPush, 0x81, opPush, 0x82, opDupe, 0x01},
+			vals(u64(1), u64(2), u64(1)), errOverflow,
+		},
+		{"Underflow", []uint8{opPush, 0x81, opDupe, 0x01}, vals(u64(1)), testerr.Is(vm.ErrStackUnderflow)},
+		{"Empty", []uint8{opDupe, 0x00}, vals(), testerr.Is(vm.ErrStackUnderflow)},
+		{
+			"Reserved", []uint8{opPush, 0x81, opDupe, 0x80},
+			vals(u64(1)), testerr.Is(vm.InvalidControlError{Op: opcode.Dupe, Control: 0x80}),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: test.data}
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+}
+
+func TestThreadPop(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Immediate", []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x83, opPop, 0x81}, vals(types.Uint64(1)), errOverflow},
+		{"Dynamic", []uint8{opPush, 0x81, opPush, 0x82, opPush, 0x80, opPop, 0x00}, vals(types.Uint64(1)), errOverflow},
+		{"Clear", []uint8{opPush, 0x81, opPush, 0x82, opPop, 0x40}, vals(), errOverflow},
+		{"Underflow", []uint8{opPush, 0x81, opPop, 0x81}, vals(types.Uint64(1)), testerr.Is(vm.ErrStackUnderflow)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: test.data}
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+}
+
+func TestThreadJump(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected []types.Value
+		errval   testerr.ExpectedError
+	}{
+		{"Jump", []uint8{opJump, 0x01, 0x05, opPush, 0x81, opPush, 0x82}, vals(types.Uint64(2)), errOverflow},
+		{"JumpIf/Taken", []uint8{opPush, 0x81, opJumpIf, 0x01, 0x07, opPush, 0x81, opPush, 0x82}, vals(types.Uint64(2)), errOverflow},
+		{
+			"JumpIf/NotTaken", []uint8{opPush, 0x80, opJumpIf, 0x01, 0x07, opPush, 0x81, opPush, 0x82},
+			vals(types.Uint64(1), types.Uint64(2)), errOverflow,
+		},
+		{"JumpIfZero/Taken", []uint8{opPush, 0x80, opJumpIf, 0x11, 0x07, opPush, 0x81, opPush, 0x82}, vals(types.Uint64(2)), errOverflow},
+		{"BadControl", []uint8{opJump, 0x11, 0x00}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Jump, Control: 0x11})},
+		{"BadSize", []uint8{opJump, 0x09}, vals(), testerr.Is(vm.InvalidControlError{Op: opcode.Jump, Control: 0x09})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Data: test.data}
+			test.errval.Require(t, th.Run())
+			requireStack(t, test.expected, th.Stack)
+		})
+	}
+}
+
+func TestThreadCall(t *testing.T) {
+	t.Parallel()
+
+	// 0x00: Push 1, Push 5, Push 6, Call 0x0C with 2 args, Jump 0x12
+	// 0x0C: Push 7, Pop 1, Return 1 value
+	// 0x12: Push 8
+	data := []uint8{
+		opPush, 0x81, opPush, 0x85, opPush, 0x86, opCall, 0x21, 0x0C, opJump, 0x01, 0x12,
+		opPush, 0x87, opPop, 0x80, opReturn, 0x01, opPush, 0x88,
+	}
+
+	th := &vm.Thread{Data: data}
+	require.NoError(t, th.RunFor(5))
+	require.Equal(t, []vm.Frame{{Base: 1, Caller: 6, Return: 9}}, th.Frames)
+	require.Equal(t, 1, th.FrameBase())
+
+	testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+	require.Empty(t, th.Frames)
+	requireStack(t, vals(types.Uint64(1), types.Uint64(6), types.Uint64(8)), th.Stack)
+
+	t.Run("NoFrame", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opReturn, 0x00}}
+		testerr.Is(vm.ErrFrameUnderflow).Require(t, th.Run())
+	})
+
+	t.Run("ArgumentUnderflow", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opPush, 0x81, opCall, 0x21, 0x00}}
+		testerr.Is(vm.ErrStackUnderflow).Require(t, th.Run())
+	})
+}
+
+// Helper functions
+// ================
+
+func vals(v ...types.Value) []types.Value {
+	return v
+}
+
+func requireStack(t *testing.T, expected []types.Value, actual []vm.Slot) {
+	t.Helper()
+
+	if len(expected) == 0 {
+		require.Empty(t, actual)
+		return
+	}
+
+	require.Equal(t, expected, vm.Values(actual))
+}
+
+func u64(v uint64) types.Uint64 {
+	return types.Uint64(v)
+}
+
+func i64(v int64) types.Int64 {
+	return types.Int64(v)
+}
+
+func f64(v float64) types.Float64 {
+	return types.Float64(v)
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// Specialize records that the operands of the instruction at the given offset
+// are proven to all have the given stack type, so that it is run by a fast
+// path which does not check the types of its operands.
+//
+// Integer Add, Sub and Mul in wrapping mode, float arithmetic other than
+// DivMod, Compare and JumpIf have fast paths; other instructions are run as
+// before. The proof is not checked, so recording a type the operands may not
+// have makes the program misbehave. It must hold whichever entry the program
+// is started at, including the entries of spawned threads and coroutines.
+// Passing typeid.Void removes the record. If no instruction starts at the
+// offset false is returned.
+//
+// Specializing an instruction discards any compiled form of it.
+func (p *Program) Specialize(offset int, operands typeid.ID) bool {
+	idx, ok := p.Lookup(offset)
+	if !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	closures := slices.Clone(p.compiled())
+
+	p.Instructions[idx].Operands = operands
+	if p.code[idx].Op != opcode.PushArith {
+		p.code[idx].Operands = operands
+	}
+
+	if closures != nil {
+		closures[idx] = nil
+	}
+
+	// The operands of a PushArith are those of the arithmetic opcode fused
+	// into it.
+	if prev := idx - 1; prev >= 0 && p.code[prev].Op == opcode.PushArith {
+		p.code[prev].Operands = operands
+		if closures != nil {
+			closures[prev] = nil
+		}
+	}
+
+	if closures != nil {
+		p.closures.Store(&closures)
+	}
+
+	return true
+}
+
+// typedArith runs the arithmetic opcode op with the given left operand and
+// the top of the stack as the right operand, where both are proven to have the
+// given type.
+//
+// If the operation has no fast path, or the frame is empty, the stack is left
+// as it was and false is returned.
+func (t *Thread) typedArith(op opcode.ID, mode uint8, operands typeid.ID, left Slot) bool {
+	n := len(t.Stack)
+	if n <= t.FrameBase() {
+		return false
+	}
+
+	right := &t.Stack[n-1]
+
+	var bits uint64
+	switch operands {
+	case typeid.Uint64, typeid.Int64:
+		if mode != arithModeWrap {
+			return false
+		}
+
+		switch op {
+		case opcode.Add:
+			bits = left.Bits + right.Bits
+		case opcode.Sub:
+			bits = left.Bits - right.Bits
+		case opcode.Mul:
+			bits = left.Bits * right.Bits
+		default:
+			return false
+		}
+	case typeid.Float64:
+		if op == opcode.DivMod {
+			return false
+		}
+
+		bits = t.canonical(FloatSlot(floatArith(op, left.Float(), right.Float()))).Bits
+	default:
+		return false
+	}
+
+	right.Bits = bits
+
+	return true
+}
+
+// typedCompare returns the order of the given operands as compare does, using
+// a fast path when both are proven to have the given type.
+func typedCompare(operands typeid.ID, left, right Slot) (int, bool, error) {
+	switch operands {
+	case typeid.Uint64:
+		return cmp.Compare(left.Bits, right.Bits), true, nil
+	case typeid.Int64:
+		return cmp.Compare(left.Signed(), right.Signed()), true, nil
+	case typeid.Float64:
+		order, ordered := compareFloats(left.Float(), right.Float())
+		return order, ordered, nil
+	default:
+		return compare(left, right)
+	}
+}
+
+// typedTruth returns if the given slot is non-zero as isTruthy does, using a
+// fast path when it is proven to have the given type.
+func typedTruth(operands typeid.ID, s Slot) (bool, error) {
+	switch operands {
+	case typeid.Uint64, typeid.Int64:
+		return s.Bits != 0, nil
+	case typeid.Float64:
+		return s.Float() != 0, nil
+	default:
+		return isTruthy(s)
+	}
+}
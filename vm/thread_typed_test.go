@@ -0,0 +1,103 @@
+package vm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+)
+
+func TestProgramSpecialize(t *testing.T) {
+	t.Parallel()
+
+	// specialized holds the offset of a specialized instruction and the type
+	// of its operands.
+	type specialized struct {
+		offset   int
+		operands typeid.ID
+	}
+
+	maxI64 := []uint8{opPush, 0x18, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	for _, test := range []struct {
+		name  string
+		data  []uint8
+		typed []specialized
+	}{
+		{"AddSigned", concat(maxI64, []uint8{opPush, 0x11, 0x02, opAdd, modeWrap}), []specialized{{13, typeid.Int64}}},
+		{"SubUnsigned", []uint8{opPush, 0x83, opPush, 0x81, opSub, modeWrap}, []specialized{{4, typeid.Uint64}}},
+		{"MulFused", []uint8{opPush, 0x11, 0xFD, opPush, 0x11, 0x05, opMul, modeWrap}, []specialized{{6, typeid.Int64}}},
+		{"Trap", concat(maxI64, []uint8{opPush, 0x11, 0x02, opAdd, modeTrap}), []specialized{{13, typeid.Int64}}},
+		{"DivZero", []uint8{opPush, 0x80, opPush, 0x81, opDiv, modeWrap}, []specialized{{4, typeid.Uint64}}},
+		{"Float", concat(pushF64(1.5), pushF64(-0.5), []uint8{opFDiv, modeWrap, opDupe, 0x00, opMod, modeWrap}),
+			[]specialized{{20, typeid.Float64}, {24, typeid.Float64}}},
+		{"CompareSigned", []uint8{opPush, 0x11, 0xFF, opPush, 0x11, 0x01, opCompare, cmpGt},
+			[]specialized{{6, typeid.Int64}}},
+		{"CompareNaN", concat(pushF64(math.NaN()), pushF64(1), []uint8{opCompare, cmpNe}),
+			[]specialized{{20, typeid.Float64}}},
+		{"Countdown", countdown(10), []specialized{{7, typeid.Int64}, {11, typeid.Int64}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw := &vm.Thread{Data: test.data}
+			expected := raw.Run()
+
+			for _, compile := range []bool{false, true} {
+				th := &vm.Thread{Data: test.data}
+				th.Predecode()
+				for _, s := range test.typed {
+					require.True(t, th.Program.Specialize(s.offset, s.operands))
+				}
+
+				if compile {
+					require.NoError(t, th.Program.Compile(0))
+				}
+
+				require.Equal(t, expected, th.Run())
+				require.Equal(t, raw.PC, th.PC)
+				require.Equal(t, vm.Values(raw.Stack), vm.Values(th.Stack))
+			}
+		})
+	}
+
+	t.Run("NotInstruction", func(t *testing.T) {
+		t.Parallel()
+
+		p := vm.Decode([]uint8{opPush, 0x81})
+		require.False(t, p.Specialize(1, typeid.Uint64))
+		require.False(t, p.Specialize(2, typeid.Uint64))
+	})
+
+	t.Run("Recorded", func(t *testing.T) {
+		t.Parallel()
+
+		p := vm.Decode([]uint8{opPush, 0x81, opPush, 0x81, opAdd, modeWrap})
+		require.True(t, p.Specialize(4, typeid.Uint64))
+		require.Equal(t, typeid.Uint64, p.Instructions[2].Operands)
+		require.True(t, p.Specialize(4, typeid.Void))
+		require.Equal(t, typeid.Void, p.Instructions[2].Operands)
+	})
+}
+
+// pushF64 returns a Push of the given float64 value.
+func pushF64(v float64) []uint8 {
+	bits := math.Float64bits(v)
+
+	return []uint8{
+		opPush, 0x28, uint8(bits >> 56), uint8(bits >> 48), uint8(bits >> 40), uint8(bits >> 32),
+		uint8(bits >> 24), uint8(bits >> 16), uint8(bits >> 8), uint8(bits),
+	}
+}
+
+// concat returns the given pieces of bytecode joined in order.
+func concat(pieces ...[]uint8) []uint8 {
+	var data []uint8
+	for _, p := range pieces {
+		data = append(data, p...)
+	}
+
+	return data
+}
@@ -0,0 +1,206 @@
+package vmath
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/tvarney/consterr"
+)
+
+const (
+	// ErrDivideByZero indicates that an integer division had a divisor of 0.
+	ErrDivideByZero consterr.Error = "integer divide by zero"
+
+	// ErrOverflow indicates that the result of an integer operation does not
+	// fit in the type of the operands.
+	ErrOverflow consterr.Error = "integer overflow"
+)
+
+// AddU64 returns the sum of a and b, wrapping on overflow, and whether the sum
+// overflowed.
+func AddU64(a, b uint64) (uint64, bool) {
+	sum, carry := bits.Add64(a, b, 0)
+	return sum, carry != 0
+}
+
+// SubU64 returns the difference of a and b, wrapping on overflow, and whether
+// the difference overflowed.
+func SubU64(a, b uint64) (uint64, bool) {
+	diff, borrow := bits.Sub64(a, b, 0)
+	return diff, borrow != 0
+}
+
+// MulU64 returns the product of a and b, wrapping on overflow, and whether the
+// product overflowed.
+func MulU64(a, b uint64) (uint64, bool) {
+	hi, lo := bits.Mul64(a, b)
+	return lo, hi != 0
+}
+
+// AddI64 returns the sum of a and b, wrapping on overflow, and whether the sum
+// overflowed.
+func AddI64(a, b int64) (int64, bool) {
+	sum := a + b
+
+	// Overflow happens only when both operands have the same sign and the sum
+	// has the other.
+	return sum, (a^sum)&(b^sum) < 0
+}
+
+// SubI64 returns the difference of a and b, wrapping on overflow, and whether
+// the difference overflowed.
+func SubI64(a, b int64) (int64, bool) {
+	diff := a - b
+
+	// Overflow happens only when the operands have different signs and the
+	// difference has the sign of b.
+	return diff, (a^b)&(a^diff) < 0
+}
+
+// MulI64 returns the product of a and b, wrapping on overflow, and whether the
+// product overflowed.
+func MulI64(a, b int64) (int64, bool) {
+	hi, lo := MulWideI64(a, b)
+
+	// The product fits if the high half is only the sign extension of the low
+	// half.
+	return int64(lo), hi != int64(lo)>>63
+}
+
+// MulWideU64 returns the full 128-bit product of a and b as its high and low
+// halves.
+func MulWideU64(a, b uint64) (uint64, uint64) {
+	return bits.Mul64(a, b)
+}
+
+// MulWideI64 returns the full 128-bit two's complement product of a and b as
+// its high and low halves.
+func MulWideI64(a, b int64) (int64, uint64) {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+
+	// The unsigned product treats a negative operand x as x + 2^64, which adds
+	// the other operand to the high half; remove it again.
+	if a < 0 {
+		hi -= uint64(b)
+	}
+
+	if b < 0 {
+		hi -= uint64(a)
+	}
+
+	return int64(hi), lo
+}
+
+// DivU64 returns the quotient and remainder of a divided by b.
+//
+// If b is 0, ErrDivideByZero is returned.
+func DivU64(a, b uint64) (uint64, uint64, error) {
+	if b == 0 {
+		return 0, 0, ErrDivideByZero
+	}
+
+	return a / b, a % b, nil
+}
+
+// DivI64 returns the quotient and remainder of a divided by b, with the
+// quotient truncated towards zero. The remainder has the sign of a.
+//
+// If b is 0, ErrDivideByZero is returned. The only other input which can not
+// be represented is math.MinInt64 / -1; the quotient wraps to math.MinInt64
+// with a remainder of 0 and ErrOverflow is returned alongside the wrapped
+// values.
+func DivI64(a, b int64) (int64, int64, error) {
+	switch {
+	case b == 0:
+		return 0, 0, ErrDivideByZero
+	case a == math.MinInt64 && b == -1:
+		return math.MinInt64, 0, ErrOverflow
+	default:
+		return a / b, a % b, nil
+	}
+}
+
+// FloorDivI64 returns the quotient and remainder of a divided by b, with the
+// quotient rounded towards negative infinity. The remainder has the sign of b.
+//
+// Errors are returned in the same cases as DivI64.
+func FloorDivI64(a, b int64) (int64, int64, error) {
+	q, r, err := DivI64(a, b)
+	if err != nil {
+		return q, r, err
+	}
+
+	if r != 0 && (r < 0) != (b < 0) {
+		q--
+		r += b
+	}
+
+	return q, r, nil
+}
+
+// AddSatU64 returns the sum of a and b, clamped to math.MaxUint64.
+func AddSatU64(a, b uint64) uint64 {
+	if sum, overflow := AddU64(a, b); !overflow {
+		return sum
+	}
+
+	return math.MaxUint64
+}
+
+// SubSatU64 returns the difference of a and b, clamped to 0.
+func SubSatU64(a, b uint64) uint64 {
+	if diff, overflow := SubU64(a, b); !overflow {
+		return diff
+	}
+
+	return 0
+}
+
+// MulSatU64 returns the product of a and b, clamped to math.MaxUint64.
+func MulSatU64(a, b uint64) uint64 {
+	if prod, overflow := MulU64(a, b); !overflow {
+		return prod
+	}
+
+	return math.MaxUint64
+}
+
+// AddSatI64 returns the sum of a and b, clamped to the range of an int64.
+func AddSatI64(a, b int64) int64 {
+	sum, overflow := AddI64(a, b)
+	switch {
+	case !overflow:
+		return sum
+	case a < 0:
+		return math.MinInt64
+	default:
+		return math.MaxInt64
+	}
+}
+
+// SubSatI64 returns the difference of a and b, clamped to the range of an
+// int64.
+func SubSatI64(a, b int64) int64 {
+	diff, overflow := SubI64(a, b)
+	switch {
+	case !overflow:
+		return diff
+	case a < 0:
+		return math.MinInt64
+	default:
+		return math.MaxInt64
+	}
+}
+
+// MulSatI64 returns the product of a and b, clamped to the range of an int64.
+func MulSatI64(a, b int64) int64 {
+	prod, overflow := MulI64(a, b)
+	switch {
+	case !overflow:
+		return prod
+	case (a < 0) != (b < 0):
+		return math.MinInt64
+	default:
+		return math.MaxInt64
+	}
+}
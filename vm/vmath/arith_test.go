@@ -0,0 +1,182 @@
+package vmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/vm/vmath"
+	"github.com/tvarney/testerr"
+)
+
+func TestUnsignedArithmetic(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		fn       func(a, b uint64) (uint64, bool)
+		a        uint64
+		b        uint64
+		expected uint64
+		overflow bool
+	}{
+		{"Add/Normal", vmath.AddU64, 1, 2, 3, false},
+		{"Add/Max", vmath.AddU64, math.MaxUint64 - 1, 1, math.MaxUint64, false},
+		{"Add/Overflow", vmath.AddU64, math.MaxUint64, 2, 1, true},
+		{"Sub/Normal", vmath.SubU64, 5, 3, 2, false},
+		{"Sub/Zero", vmath.SubU64, 3, 3, 0, false},
+		{"Sub/Overflow", vmath.SubU64, 3, 5, math.MaxUint64 - 1, true},
+		{"Mul/Normal", vmath.MulU64, 6, 7, 42, false},
+		{"Mul/Max", vmath.MulU64, math.MaxUint32, math.MaxUint32 + 2, math.MaxUint64, false},
+		{"Mul/Overflow", vmath.MulU64, 1 << 32, 1 << 32, 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, overflow := test.fn(test.a, test.b)
+			require.Equal(t, test.expected, actual)
+			require.Equal(t, test.overflow, overflow)
+		})
+	}
+}
+
+func TestSignedArithmetic(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		fn       func(a, b int64) (int64, bool)
+		a        int64
+		b        int64
+		expected int64
+		overflow bool
+	}{
+		{"Add/Normal", vmath.AddI64, -1, 2, 1, false},
+		{"Add/Max", vmath.AddI64, math.MaxInt64 - 1, 1, math.MaxInt64, false},
+		{"Add/Overflow", vmath.AddI64, math.MaxInt64, 1, math.MinInt64, true},
+		{"Add/Underflow", vmath.AddI64, math.MinInt64, -1, math.MaxInt64, true},
+		{"Add/MixedSigns", vmath.AddI64, math.MinInt64, math.MaxInt64, -1, false},
+		{"Sub/Normal", vmath.SubI64, 5, 7, -2, false},
+		{"Sub/Min", vmath.SubI64, -1, math.MaxInt64, math.MinInt64, false},
+		{"Sub/Overflow", vmath.SubI64, math.MaxInt64, -1, math.MinInt64, true},
+		{"Sub/Underflow", vmath.SubI64, math.MinInt64, 1, math.MaxInt64, true},
+		{"Sub/ZeroMin", vmath.SubI64, 0, math.MinInt64, math.MinInt64, true},
+		{"Mul/Normal", vmath.MulI64, -6, 7, -42, false},
+		{"Mul/Min", vmath.MulI64, math.MinInt64 / 2, 2, math.MinInt64, false},
+		{"Mul/Overflow", vmath.MulI64, math.MaxInt64/2 + 1, 2, math.MinInt64, true},
+		{"Mul/NegateMin", vmath.MulI64, math.MinInt64, -1, math.MinInt64, true},
+		{"Mul/NegativeOverflow", vmath.MulI64, -(1 << 32), 1 << 32, 0, true},
+		{"Mul/Zero", vmath.MulI64, math.MinInt64, 0, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, overflow := test.fn(test.a, test.b)
+			require.Equal(t, test.expected, actual)
+			require.Equal(t, test.overflow, overflow)
+		})
+	}
+}
+
+func TestMulWide(t *testing.T) {
+	t.Parallel()
+
+	hi, lo := vmath.MulWideU64(math.MaxUint64, math.MaxUint64)
+	require.Equal(t, uint64(math.MaxUint64-1), hi)
+	require.Equal(t, uint64(1), lo)
+
+	for _, test := range []struct {
+		a  int64
+		b  int64
+		hi int64
+		lo uint64
+	}{
+		{2, 3, 0, 6},
+		{-2, 3, -1, math.MaxUint64 - 5},
+		{-2, -3, 0, 6},
+		{math.MinInt64, math.MinInt64, 1 << 62, 0},
+		{math.MinInt64, -1, 0, 1 << 63},
+		{math.MaxInt64, math.MinInt64, -(1 << 62), 1 << 63},
+	} {
+		hi, lo := vmath.MulWideI64(test.a, test.b)
+		require.Equal(t, test.hi, hi, "high half of %d * %d", test.a, test.b)
+		require.Equal(t, test.lo, lo, "low half of %d * %d", test.a, test.b)
+	}
+}
+
+func TestDivision(t *testing.T) {
+	t.Parallel()
+
+	nilErr := testerr.Nil()
+	errZero := testerr.Is(vmath.ErrDivideByZero)
+	errOverflow := testerr.Is(vmath.ErrOverflow)
+
+	t.Run("Unsigned", func(t *testing.T) {
+		t.Parallel()
+
+		q, r, err := vmath.DivU64(17, 5)
+		nilErr.Require(t, err)
+		require.Equal(t, uint64(3), q)
+		require.Equal(t, uint64(2), r)
+
+		_, _, err = vmath.DivU64(17, 0)
+		errZero.Require(t, err)
+	})
+
+	for _, test := range []struct {
+		name  string
+		fn    func(a, b int64) (int64, int64, error)
+		a     int64
+		b     int64
+		q     int64
+		r     int64
+		errfn testerr.ExpectedError
+	}{
+		{"Trunc/PosPos", vmath.DivI64, 7, 2, 3, 1, nilErr},
+		{"Trunc/NegPos", vmath.DivI64, -7, 2, -3, -1, nilErr},
+		{"Trunc/PosNeg", vmath.DivI64, 7, -2, -3, 1, nilErr},
+		{"Trunc/NegNeg", vmath.DivI64, -7, -2, 3, -1, nilErr},
+		{"Trunc/Zero", vmath.DivI64, 7, 0, 0, 0, errZero},
+		{"Trunc/Overflow", vmath.DivI64, math.MinInt64, -1, math.MinInt64, 0, errOverflow},
+		{"Trunc/Min", vmath.DivI64, math.MinInt64, 1, math.MinInt64, 0, nilErr},
+		{"Floor/PosPos", vmath.FloorDivI64, 7, 2, 3, 1, nilErr},
+		{"Floor/NegPos", vmath.FloorDivI64, -7, 2, -4, 1, nilErr},
+		{"Floor/PosNeg", vmath.FloorDivI64, 7, -2, -4, -1, nilErr},
+		{"Floor/NegNeg", vmath.FloorDivI64, -7, -2, 3, -1, nilErr},
+		{"Floor/Exact", vmath.FloorDivI64, -8, 2, -4, 0, nilErr},
+		{"Floor/Zero", vmath.FloorDivI64, 7, 0, 0, 0, errZero},
+		{"Floor/Overflow", vmath.FloorDivI64, math.MinInt64, -1, math.MinInt64, 0, errOverflow},
+		{"Floor/MinMax", vmath.FloorDivI64, math.MinInt64, math.MaxInt64, -2, math.MaxInt64 - 1, nilErr},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q, r, err := test.fn(test.a, test.b)
+			test.errfn.Require(t, err)
+			require.Equal(t, test.q, q, "quotient")
+			require.Equal(t, test.r, r, "remainder")
+		})
+	}
+}
+
+func TestSaturatingArithmetic(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, uint64(3), vmath.AddSatU64(1, 2))
+	require.Equal(t, uint64(math.MaxUint64), vmath.AddSatU64(math.MaxUint64, 2))
+	require.Equal(t, uint64(2), vmath.SubSatU64(5, 3))
+	require.Equal(t, uint64(0), vmath.SubSatU64(3, 5))
+	require.Equal(t, uint64(42), vmath.MulSatU64(6, 7))
+	require.Equal(t, uint64(math.MaxUint64), vmath.MulSatU64(1<<32, 1<<32))
+
+	require.Equal(t, int64(-1), vmath.AddSatI64(1, -2))
+	require.Equal(t, int64(math.MaxInt64), vmath.AddSatI64(math.MaxInt64, 1))
+	require.Equal(t, int64(math.MinInt64), vmath.AddSatI64(math.MinInt64, -1))
+	require.Equal(t, int64(-2), vmath.SubSatI64(5, 7))
+	require.Equal(t, int64(math.MaxInt64), vmath.SubSatI64(0, math.MinInt64))
+	require.Equal(t, int64(math.MinInt64), vmath.SubSatI64(-2, math.MaxInt64))
+	require.Equal(t, int64(-42), vmath.MulSatI64(-6, 7))
+	require.Equal(t, int64(math.MaxInt64), vmath.MulSatI64(math.MinInt64, -1))
+	require.Equal(t, int64(math.MinInt64), vmath.MulSatI64(math.MaxInt64, -2))
+	require.Equal(t, int64(math.MaxInt64), vmath.MulSatI64(-(1<<32), -(1<<32)))
+}
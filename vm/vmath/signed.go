@@ -0,0 +1,209 @@
+package vmath
+
+const (
+	i24SignExtend = 0xFFFFFFFFFF000000
+	i40SignExtend = 0xFFFFFF0000000000
+	i48SignExtend = 0xFFFF000000000000
+	i56SignExtend = 0xFF00000000000000
+)
+
+// SignedByteSize returns how many bytes are needed to hold the given int64 as
+// a two's complement value.
+func SignedByteSize(val int64) int {
+	// Folding negative values onto their one's complement leaves the number of
+	// significant bits unchanged, leaving room for the sign bit.
+	if val < 0 {
+		val = ^val
+	}
+
+	return UnsignedByteSize(uint64(val) << 1)
+}
+
+// I16FromBytes assembles an i16 value from 2 bytes.
+func I16FromBytes(msb, lsb uint8) int16 {
+	return int16(U16FromBytes(msb, lsb))
+}
+
+// I24FromBytes assembles an i24 value from 3 bytes.
+//
+// As int24 does not exist, this function returns a sign extended int32.
+func I24FromBytes(msb, b2, lsb uint8) int32 {
+	val := uint64(U24FromBytes(msb, b2, lsb))
+	if msb&0x80 != 0 {
+		val |= i24SignExtend
+	}
+
+	return int32(int64(val))
+}
+
+// I32FromBytes assembles an i32 value from 4 bytes.
+func I32FromBytes(msb, b2, b3, lsb uint8) int32 {
+	return int32(U32FromBytes(msb, b2, b3, lsb))
+}
+
+// I40FromBytes assembles an i40 value from 5 bytes.
+//
+// As int40 does not exist, this function returns a sign extended int64.
+func I40FromBytes(msb, b2, b3, b4, lsb uint8) int64 {
+	val := U40FromBytes(msb, b2, b3, b4, lsb)
+	if msb&0x80 != 0 {
+		val |= i40SignExtend
+	}
+
+	return int64(val)
+}
+
+// I48FromBytes assembles an i48 value from 6 bytes.
+//
+// As int48 does not exist, this function returns a sign extended int64.
+func I48FromBytes(msb, b2, b3, b4, b5, lsb uint8) int64 {
+	val := U48FromBytes(msb, b2, b3, b4, b5, lsb)
+	if msb&0x80 != 0 {
+		val |= i48SignExtend
+	}
+
+	return int64(val)
+}
+
+// I56FromBytes assembles an i56 value from 7 bytes.
+//
+// As int56 does not exist, this function returns a sign extended int64.
+func I56FromBytes(msb, b2, b3, b4, b5, b6, lsb uint8) int64 {
+	val := U56FromBytes(msb, b2, b3, b4, b5, b6, lsb)
+	if msb&0x80 != 0 {
+		val |= i56SignExtend
+	}
+
+	return int64(val)
+}
+
+// I64FromBytes assembles an i64 value from 8 bytes.
+func I64FromBytes(msb, b2, b3, b4, b5, b6, b7, lsb uint8) int64 {
+	return int64(U64FromBytes(msb, b2, b3, b4, b5, b6, b7, lsb))
+}
+
+// I16ToBytes decomposes an i16 to an array of 2 bytes.
+//
+// The resulting array can be used to reassemble the i16 by passing the values
+// to I16FromBytes.
+func I16ToBytes(val int16) []uint8 {
+	return U16ToBytes(uint16(val))
+}
+
+// I24ToBytes decomposes an i24 to an array of 3 bytes.
+//
+// As int24 does not exist, this function takes an int32 and ignores the most
+// significant byte.
+//
+// The resulting array can be used to reassemble the i24 by passing the values
+// to I24FromBytes.
+func I24ToBytes(val int32) []uint8 {
+	return U24ToBytes(uint32(val))
+}
+
+// I32ToBytes decomposes an i32 to an array of 4 bytes.
+//
+// The resulting array can be used to reassemble the i32 by passing the values
+// to I32FromBytes.
+func I32ToBytes(val int32) []uint8 {
+	return U32ToBytes(uint32(val))
+}
+
+// I40ToBytes decomposes an i40 to an array of 5 bytes.
+//
+// As int40 does not exist, this function takes an int64 and ignores the most
+// significant 3 bytes.
+//
+// The resulting array can be used to reassemble the i40 by passing the values
+// to I40FromBytes.
+func I40ToBytes(val int64) []uint8 {
+	return U40ToBytes(uint64(val))
+}
+
+// I48ToBytes decomposes an i48 to an array of 6 bytes.
+//
+// As int48 does not exist, this function takes an int64 and ignores the most
+// significant 2 bytes.
+//
+// The resulting array can be used to reassemble the i48 by passing the values
+// to I48FromBytes.
+func I48ToBytes(val int64) []uint8 {
+	return U48ToBytes(uint64(val))
+}
+
+// I56ToBytes decomposes an i56 to an array of 7 bytes.
+//
+// As int56 does not exist, this function takes an int64 and ignores the most
+// significant byte.
+//
+// The resulting array can be used to reassemble the i56 by passing the values
+// to I56FromBytes.
+func I56ToBytes(val int64) []uint8 {
+	return U56ToBytes(uint64(val))
+}
+
+// I64ToBytes decomposes an i64 to an array of 8 bytes.
+//
+// The resulting array can be used to reassemble the i64 by passing the values
+// to I64FromBytes.
+func I64ToBytes(val int64) []uint8 {
+	return U64ToBytes(uint64(val))
+}
+
+// SignedToBytes returns the smallest slice of uint8 which holds the given
+// value as a two's complement value.
+func SignedToBytes(val int64) []uint8 {
+	switch SignedByteSize(val) {
+	case 1:
+		return []uint8{uint8(val)}
+	case 2:
+		return I16ToBytes(int16(val))
+	case 3:
+		return I24ToBytes(int32(val))
+	case 4:
+		return I32ToBytes(int32(val))
+	case 5:
+		return I40ToBytes(val)
+	case 6:
+		return I48ToBytes(val)
+	case 7:
+		return I56ToBytes(val)
+	default:
+		return I64ToBytes(val)
+	}
+}
+
+// SignedFromBytes builds a sign extended integer from the given slice of
+// bytes.
+//
+// If the length of the slice is 0, 0 is returned. If the length of the slice is
+// greater than 8 then only the first 8 bytes are used.
+func SignedFromBytes(data []uint8) int64 {
+	switch len(data) {
+	case 0:
+		return 0
+	case 1:
+		return int64(int8(data[0]))
+	case 2:
+		return int64(I16FromBytes(data[0], data[1]))
+	case 3:
+		return int64(I24FromBytes(data[0], data[1], data[2]))
+	case 4:
+		return int64(I32FromBytes(data[0], data[1], data[2], data[3]))
+	case 5:
+		return I40FromBytes(data[0], data[1], data[2], data[3], data[4])
+	case 6:
+		return I48FromBytes(
+			data[0], data[1], data[2], data[3], data[4], data[5],
+		)
+	case 7:
+		return I56FromBytes(
+			data[0], data[1], data[2], data[3], data[4], data[5], data[6],
+		)
+	default:
+		return I64FromBytes(
+			data[0], data[1], data[2], data[3], data[4], data[5], data[6],
+			data[7],
+		)
+	}
+}
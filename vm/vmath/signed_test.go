@@ -0,0 +1,83 @@
+package vmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/vm/vmath"
+)
+
+func TestSignedByteSize(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		value    int64
+		expected int
+	}{
+		{0, 1}, {1, 1}, {-1, 1}, {127, 1}, {-128, 1},
+		{128, 2}, {-129, 2}, {math.MaxInt16, 2}, {math.MinInt16, 2},
+		{math.MaxInt16 + 1, 3}, {-0x800000, 3}, {0x7FFFFF, 3},
+		{0x800000, 4}, {math.MinInt32, 4}, {math.MaxInt32, 4},
+		{math.MaxInt32 + 1, 5}, {-0x8000000000, 5},
+		{0x8000000000, 6}, {-0x800000000000, 6},
+		{0x800000000000, 7}, {-0x80000000000000, 7},
+		{0x80000000000000, 8}, {math.MinInt64, 8}, {math.MaxInt64, 8},
+	} {
+		assert.Equal(t, test.expected, vmath.SignedByteSize(test.value), "bytes for %d", test.value)
+	}
+}
+
+func TestSignedFromBytes(t *testing.T) {
+	t.Parallel()
+
+	d := func(data ...uint8) []uint8 { return data }
+
+	require.Equal(t, int16(-2), vmath.I16FromBytes(0xFF, 0xFE))
+	require.Equal(t, int32(-0x800000), vmath.I24FromBytes(0x80, 0x00, 0x00))
+	require.Equal(t, int32(0x7FFFFF), vmath.I24FromBytes(0x7F, 0xFF, 0xFF))
+	require.Equal(t, int32(-1), vmath.I32FromBytes(0xFF, 0xFF, 0xFF, 0xFF))
+	require.Equal(t, int64(-0x8000000000), vmath.I40FromBytes(0x80, 0, 0, 0, 0))
+	require.Equal(t, int64(0x0102030405), vmath.I40FromBytes(1, 2, 3, 4, 5))
+	require.Equal(t, int64(-1), vmath.I48FromBytes(0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF))
+	require.Equal(t, int64(-0x80000000000000), vmath.I56FromBytes(0x80, 0, 0, 0, 0, 0, 0))
+	require.Equal(t, int64(math.MinInt64), vmath.I64FromBytes(0x80, 0, 0, 0, 0, 0, 0, 0))
+
+	require.Equal(t, int64(0), vmath.SignedFromBytes(d()), "Len0")
+	require.Equal(t, int64(-1), vmath.SignedFromBytes(d(0xFF)), "Len1")
+	require.Equal(t, int64(-0x5324), vmath.SignedFromBytes(d(0xAC, 0xDC)), "Len2")
+	require.Equal(t, int64(0x012304), vmath.SignedFromBytes(d(0x01, 0x23, 0x04)), "Len3")
+	require.Equal(t, int64(-0x01124111), vmath.SignedFromBytes(d(0xFE, 0xED, 0xBE, 0xEF)), "Len4")
+	require.Equal(t, int64(-2), vmath.SignedFromBytes(d(0xFF, 0xFF, 0xFF, 0xFF, 0xFE)), "Len5")
+	require.Equal(t, int64(0x010203040506), vmath.SignedFromBytes(d(1, 2, 3, 4, 5, 6)), "Len6")
+	require.Equal(t, int64(-3), vmath.SignedFromBytes(d(0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFD)), "Len7")
+	require.Equal(t, int64(-4), vmath.SignedFromBytes(
+		d(0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFC, 0x01),
+	), "Len9")
+}
+
+func TestSignedToBytes(t *testing.T) {
+	t.Parallel()
+
+	d := func(data ...uint8) []uint8 { return data }
+
+	require.Equal(t, d(0xFF, 0xFE), vmath.I16ToBytes(-2))
+	require.Equal(t, d(0x80, 0x00, 0x00), vmath.I24ToBytes(-0x800000))
+	require.Equal(t, d(0xFF, 0xFF, 0xFF, 0xFF), vmath.I32ToBytes(-1))
+	require.Equal(t, d(0xFF, 0xFF, 0xFF, 0xFF, 0xFE), vmath.I40ToBytes(-2))
+	require.Equal(t, d(0x80, 0, 0, 0, 0, 0), vmath.I48ToBytes(-0x800000000000))
+	require.Equal(t, d(0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFD), vmath.I56ToBytes(-3))
+	require.Equal(t, d(0x80, 0, 0, 0, 0, 0, 0, 0), vmath.I64ToBytes(math.MinInt64))
+
+	for _, value := range []int64{
+		0, 1, -1, 127, -128, 128, -129, math.MaxInt16, math.MinInt16, 0x7FFFFF,
+		-0x800000, math.MaxInt32, math.MinInt32, 0x7FFFFFFFFF, -0x8000000000,
+		0x7FFFFFFFFFFF, -0x800000000000, 0x7FFFFFFFFFFFFF, -0x80000000000000,
+		math.MaxInt64, math.MinInt64,
+	} {
+		data := vmath.SignedToBytes(value)
+		require.Len(t, data, vmath.SignedByteSize(value), "length for %d", value)
+		require.Equal(t, value, vmath.SignedFromBytes(data), "round trip of %d", value)
+	}
+}
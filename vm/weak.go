@@ -0,0 +1,136 @@
+package vm
+
+import (
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+)
+
+// weakRef is a heap object holding a weak reference to another heap object.
+//
+// A weak reference does not keep its target alive; once the target is
+// collected the reference is cleared.
+type weakRef struct {
+	target Slot
+}
+
+func (w *weakRef) ID() typeid.ID {
+	return typeid.Weak
+}
+
+func (w *weakRef) Size() int {
+	return 16
+}
+
+func (w *weakRef) Upcast() types.StackValue {
+	return nil
+}
+
+// cleared returns if the target of the reference was not marked by the
+// collection running on the given heap.
+func (w *weakRef) cleared(h *Heap) bool {
+	handle, ok := w.target.Handle()
+	return ok && !h.objects[handle-1].marked
+}
+
+// NewWeak returns a slot holding a weak reference to the heap object the given
+// slot refers to.
+//
+// The weak reference is itself a heap object, accounted for as by New. If the
+// slot does not refer to a heap object ErrNotReference is returned.
+func (t *Thread) NewWeak(target Slot) (Slot, error) {
+	if _, ok := target.Handle(); !ok {
+		return Slot{}, ErrNotReference
+	}
+
+	return t.New(&weakRef{target: target})
+}
+
+// opWeak replaces the reference on the top of the stack with a weak reference
+// to the heap object it refers to.
+func (t *Thread) opWeak() error {
+	n := len(t.Stack)
+	if n <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	weak, err := t.NewWeak(t.Stack[n-1])
+	if err != nil {
+		return err
+	}
+
+	t.Stack[n-1] = weak
+
+	return nil
+}
+
+// opDeref replaces the weak reference on the top of the stack with a reference
+// to its target, or with void if the target has been collected.
+func (t *Thread) opDeref() error {
+	n := len(t.Stack)
+	if n <= t.FrameBase() {
+		return ErrStackUnderflow
+	}
+
+	if t.Machine == nil {
+		return ErrNoMachine
+	}
+
+	weak := t.Stack[n-1]
+	if weak.Type != typeid.Weak {
+		return UnexpectedTypeError{ID: weak.Type}
+	}
+
+	// A cleared reference has a void target.
+	t.Stack[n-1], _ = t.Machine.Deref(weak)
+
+	return nil
+}
+
+// NewHost places the given host value on the heap of the thread's machine as
+// by New, setting the given finalizer to be called once it is collected.
+//
+// The finalizer may release any resource held by the host value; it is not
+// called if the finalizer is nil.
+func (t *Thread) NewHost(v types.Host, fn func(types.Host)) (Slot, error) {
+	s, err := t.New(v)
+	if err != nil || fn == nil {
+		return s, err
+	}
+
+	handle, _ := s.Handle()
+	t.Machine.SetFinalizer(handle, func(v types.Value) {
+		if host, ok := v.(types.Host); ok {
+			fn(host)
+		}
+	})
+
+	return s, nil
+}
+
+// Deref returns a slot referring to the target of the weak reference the given
+// slot refers to.
+//
+// If the slot does not refer to a weak reference, or the target has been
+// collected, false is returned.
+func (m *Machine) Deref(weak Slot) (Slot, bool) {
+	handle, ok := weak.Handle()
+	if !ok || weak.Type != typeid.Weak {
+		return Slot{}, false
+	}
+
+	h := &m.heap
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if int(handle) > len(h.objects) {
+		return Slot{}, false
+	}
+
+	w, ok := h.objects[handle-1].value.(*weakRef)
+	if !ok || w.target.Type == typeid.Void {
+		return Slot{}, false
+	}
+
+	return w.target, true
+}
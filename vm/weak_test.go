@@ -0,0 +1,164 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/illvm/opcode"
+	"github.com/tvarney/illvm/types"
+	"github.com/tvarney/illvm/types/typeid"
+	"github.com/tvarney/illvm/vm"
+	"github.com/tvarney/testerr"
+)
+
+const (
+	opWeak  = uint8(opcode.Weak)
+	opDeref = uint8(opcode.Deref)
+)
+
+func TestThreadNewWeak(t *testing.T) {
+	t.Parallel()
+
+	m := &vm.Machine{}
+	th := &vm.Thread{Machine: m}
+	m.Heap().AddRoots(th)
+
+	target, err := th.New(&cell{})
+	require.NoError(t, err)
+	weak, err := th.NewWeak(target)
+	require.NoError(t, err)
+	require.Equal(t, typeid.Weak, weak.Type)
+
+	th.Stack = append(th.Stack, target, weak)
+	require.Equal(t, 0, m.Collect())
+
+	actual, ok := m.Deref(weak)
+	require.True(t, ok)
+	require.Equal(t, target, actual)
+
+	// The weak reference alone does not keep its target alive.
+	th.Stack = th.Stack[1:]
+	require.Equal(t, 1, m.Collect())
+
+	_, ok = m.Deref(weak)
+	require.False(t, ok)
+
+	// The handle of the target is reused, but the reference stays cleared.
+	_, err = th.New(&cell{})
+	require.NoError(t, err)
+
+	_, ok = m.Deref(weak)
+	require.False(t, ok)
+
+	t.Run("NotReference", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Machine: &vm.Machine{}}
+		_, err := th.NewWeak(vm.UnsignedSlot(1))
+		testerr.Is(vm.ErrNotReference).Require(t, err)
+	})
+
+	t.Run("NotWeak", func(t *testing.T) {
+		t.Parallel()
+
+		m := &vm.Machine{}
+		_, ok := m.Deref(vm.RefSlot(1, typeid.Weak))
+		require.False(t, ok)
+
+		h, err := m.New(&cell{})
+		require.NoError(t, err)
+		_, ok = m.Deref(vm.RefSlot(h, typeid.List))
+		require.False(t, ok)
+	})
+}
+
+func TestThreadWeak(t *testing.T) {
+	t.Parallel()
+
+	m := &vm.Machine{}
+	th := &vm.Thread{Machine: m, Data: []uint8{opDupe, 0x00, opWeak, opDeref, opWeak, opDeref}}
+	m.Heap().AddRoots(th)
+
+	target, err := th.New(&cell{})
+	require.NoError(t, err)
+
+	th.Stack = append(th.Stack, target)
+	require.NoError(t, th.RunFor(3))
+	require.Equal(t, []vm.Slot{target, target}, th.Stack)
+
+	require.NoError(t, th.RunFor(1))
+	require.Equal(t, typeid.Weak, th.Stack[1].Type)
+
+	// Once only the second weak reference is left the target is collected,
+	// along with the first weak reference, and dereferencing it gives void.
+	th.Stack = th.Stack[1:]
+	require.Equal(t, 2, m.Collect())
+	testerr.Is(vm.ErrBytecodeOverflow).Require(t, th.Run())
+	require.Equal(t, []vm.Slot{{}}, th.Stack)
+	require.Equal(t, []types.Value{nil}, vm.Values(th.Stack))
+
+	for _, test := range []struct {
+		name   string
+		data   []uint8
+		errval testerr.ExpectedError
+	}{
+		{"WeakUnderflow", []uint8{opWeak}, testerr.Is(vm.ErrStackUnderflow)},
+		{"DerefUnderflow", []uint8{opDeref}, testerr.Is(vm.ErrStackUnderflow)},
+		{"NotReference", []uint8{opPush, 0x81, opWeak}, testerr.Is(vm.ErrNotReference)},
+		{"NotWeak", []uint8{opPush, 0x81, opDeref}, testerr.Is(vm.UnexpectedTypeError{ID: typeid.Uint64})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			th := &vm.Thread{Machine: &vm.Machine{}, Data: test.data}
+			test.errval.Require(t, th.Run())
+		})
+	}
+
+	t.Run("NoMachine", func(t *testing.T) {
+		t.Parallel()
+
+		th := &vm.Thread{Data: []uint8{opDeref}, Stack: []vm.Slot{vm.RefSlot(1, typeid.Weak)}}
+		require.ErrorIs(t, th.Run(), vm.ErrNoMachine)
+	})
+}
+
+func TestThreadNewHost(t *testing.T) {
+	t.Parallel()
+
+	m := &vm.Machine{}
+	th := &vm.Thread{Machine: m}
+	m.Heap().AddRoots(th)
+
+	var closed []string
+	closer := func(h types.Host) {
+		closed = append(closed, h.Value.(string))
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		s, err := th.NewHost(types.Host{Tag: 1, Value: name}, closer)
+		require.NoError(t, err)
+		require.Equal(t, typeid.Host, s.Type)
+		th.Stack = append(th.Stack, s)
+	}
+
+	plain, err := th.NewHost(types.Host{Tag: 2, Value: "d"}, nil)
+	require.NoError(t, err)
+
+	th.Stack = th.Stack[1:2]
+	require.Equal(t, 3, m.Collect())
+	require.Equal(t, []string{"a", "c"}, closed)
+
+	h, _ := plain.Handle()
+	require.False(t, m.SetFinalizer(h, nil))
+
+	kept, _ := th.Stack[0].Handle()
+	v, ok := m.Heap().Get(kept)
+	require.True(t, ok)
+	require.Equal(t, types.Host{Tag: 1, Value: "b"}, v)
+
+	require.True(t, m.SetFinalizer(kept, nil))
+	th.Stack = nil
+	require.Equal(t, 1, m.Collect())
+	require.Equal(t, []string{"a", "c"}, closed)
+}